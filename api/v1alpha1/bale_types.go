@@ -22,25 +22,251 @@
 package v1alpha1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
+// DeletionPolicy determines which Turtles a Bale removes first when scaling down.
+type DeletionPolicy string
+
+const (
+	// DeletionPolicyOldest removes the Turtles with the oldest creation timestamp first.
+	DeletionPolicyOldest DeletionPolicy = "Oldest"
+	// DeletionPolicyNewest removes the Turtles with the newest creation timestamp first.
+	DeletionPolicyNewest DeletionPolicy = "Newest"
+	// DeletionPolicyUnhealthyFirst removes Turtles which are not ready first,
+	// falling back to the newest Turtles.
+	DeletionPolicyUnhealthyFirst DeletionPolicy = "UnhealthyFirst"
+	// DeletionPolicyPriority removes Turtles with the highest value of the
+	// DeletePriorityAnnotation first, falling back to the newest Turtles.
+	// Turtles without the annotation have a priority of zero.
+	DeletionPolicyPriority DeletionPolicy = "Priority"
+)
+
+// DeletePriorityAnnotation is an integer annotation on a Turtle consulted by
+// DeletionPolicyPriority. Higher values are deleted first.
+const DeletePriorityAnnotation = "infra.alexeldeib.xyz/delete-priority"
+
+// TemplateHashLabel is set on every Turtle created by a Bale and records the
+// hash of the Bale template spec the Turtle was last created or updated from.
+const TemplateHashLabel = "infra.alexeldeib.xyz/template-hash"
+
+// BaleNameLabel is set on the ControllerRevisions recording the template
+// history of a Bale, and holds the name of the Bale.
+const BaleNameLabel = "infra.alexeldeib.xyz/bale-name"
+
+// RollbackToAnnotation requests that a Bale's template, or the helm release
+// of a TurtleAddon, be rolled back to the given revision, as recorded in
+// status.revision and on their ControllerRevisions. A value of 0 selects
+// the previous revision, like `kubectl rollout undo`. A Bale isn't rolled
+// back to a revision it couldn't be upgraded to, such as one with an older
+// Kubernetes version. The controller removes the annotation once the
+// rollback has been applied or has failed.
+const RollbackToAnnotation = "infra.alexeldeib.xyz/rollback-to"
+
+// BaleUpdateStrategyType is the method used to bring existing Turtles up to
+// date with a changed Bale template.
+type BaleUpdateStrategyType string
+
+const (
+	// RollingUpdateBaleStrategyType replaces out of date Turtles with newly
+	// created ones, honoring maxSurge and maxUnavailable.
+	RollingUpdateBaleStrategyType BaleUpdateStrategyType = "RollingUpdate"
+	// InPlaceBaleStrategyType patches the spec of out of date Turtles,
+	// honoring maxUnavailable. Turtles are still replaced when the location
+	// of the template changes, since a cluster can't move between regions.
+	InPlaceBaleStrategyType BaleUpdateStrategyType = "InPlace"
+)
+
+// BaleUpdateStrategy describes how out of date Turtles are updated.
+type BaleUpdateStrategy struct {
+	// +kubebuilder:validation:Enum=RollingUpdate;InPlace
+	// +kubebuilder:default=RollingUpdate
+	Type BaleUpdateStrategyType `json:"type,omitempty"`
+	// RollingUpdate tunes the pace of an update. It is honored by both
+	// strategies, although InPlace ignores maxSurge.
+	RollingUpdate *RollingUpdateBale `json:"rollingUpdate,omitempty"`
+}
+
+// RollingUpdateBale bounds the number of Turtles above and below the desired
+// replicas during an update.
+type RollingUpdateBale struct {
+	// MaxUnavailable is the number or percentage of Turtles which may be
+	// unready during an update. Percentages round down. Defaults to 0, or 1
+	// when maxSurge is also 0 or the strategy is InPlace.
+	MaxUnavailable *intstr.IntOrString `json:"maxUnavailable,omitempty"`
+	// MaxSurge is the number or percentage of Turtles which may be created
+	// above the desired replicas during an update. Percentages round up.
+	// Defaults to 1.
+	MaxSurge *intstr.IntOrString `json:"maxSurge,omitempty"`
+}
+
+// TurtleTemplateSpec describes the Turtles a Bale creates.
+type TurtleTemplateSpec struct {
+	// Metadata is applied to every Turtle created from the template.
+	Metadata TemplateMetadata `json:"metadata,omitempty"`
+	// Spec is the desired state of every Turtle created from the template.
+	Spec TurtleSpec `json:"spec,omitempty"`
+}
+
+// TemplateMetadata is the subset of object metadata which may be set on
+// Turtles created from a template.
+type TemplateMetadata struct {
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// NamingMode determines how the names of Turtles created by a Bale are generated.
+type NamingMode string
+
+const (
+	// RandomNamingMode names Turtles <prefix>-<random suffix>, retrying with a
+	// new suffix if the name is taken.
+	RandomNamingMode NamingMode = "Random"
+	// OrdinalNamingMode names Turtles <prefix>-<ordinal> for ordinals 0 to
+	// replicas-1, like a StatefulSet. Turtles are created in ascending order,
+	// each once its predecessors are ready, and deleted or replaced in
+	// descending order one at a time. The deletion policy is ignored.
+	OrdinalNamingMode NamingMode = "Ordinal"
+)
+
+// NamingSpec controls the names of Turtles created by a Bale. Turtle names
+// are also used to name Azure resources, including the resource group.
+type NamingSpec struct {
+	// +kubebuilder:validation:Enum=Random;Ordinal
+	// +kubebuilder:default=Random
+	Mode NamingMode `json:"mode,omitempty"`
+	// Prefix of generated Turtle names. Defaults to the name of the Bale.
+	// +kubebuilder:validation:MaxLength=40
+	// +kubebuilder:validation:Pattern=`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`
+	Prefix string `json:"prefix,omitempty"`
+}
+
+// LocationSpread spreads the Turtles of a Bale over several Azure regions.
+// Each location gets a share of the replicas proportional to its weight.
+type LocationSpread struct {
+	// Locations the Turtles are spread over. Turtles in locations which are
+	// not listed are moved to listed ones.
+	// +kubebuilder:validation:MinItems=1
+	Locations []WeightedLocation `json:"locations"`
+	// MaxSkew is how many Turtles a location may have above its share
+	// before they are moved to other locations with a rolling update.
+	// +kubebuilder:default=1
+	// +kubebuilder:validation:Minimum=1
+	MaxSkew int32 `json:"maxSkew,omitempty"`
+}
+
+// WeightedLocation is an Azure region and its relative share of Turtles.
+type WeightedLocation struct {
+	// Name of the Azure region, e.g. westus2.
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+	// Weight of the location relative to the others. A weight of zero
+	// drains the location.
+	// +kubebuilder:default=1
+	// +kubebuilder:validation:Minimum=0
+	Weight int32 `json:"weight,omitempty"`
+}
+
 // BaleSpec defines the desired state of Bale
 type BaleSpec struct {
 	// +kubebuilder:default=1
-	Replicas       int32                 `json:"replicas,omitempty"`
-	Selector       *metav1.LabelSelector `json:"selector"`
-	SubscriptionID string                `json:"subscriptionId,omitempty"`
-	Template       TurtleSpec            `json:"template,omitempty"`
+	// +kubebuilder:validation:Minimum=0
+	Replicas int32 `json:"replicas,omitempty"`
+	// Paused stops the controller from creating, updating, adopting or
+	// deleting Turtles of this Bale. Status is still reported. It doesn't
+	// pause the Turtles themselves.
+	Paused bool `json:"paused,omitempty"`
+	// DeletionPolicy determines which Turtles are removed first when the
+	// number of replicas is lowered.
+	// +kubebuilder:validation:Enum=Oldest;Newest;UnhealthyFirst;Priority
+	// +kubebuilder:default=UnhealthyFirst
+	DeletionPolicy DeletionPolicy `json:"deletionPolicy,omitempty"`
+	// Selector is a label query over the Turtles counted towards this Bale.
+	// Orphaned Turtles matching it are adopted, and owned Turtles which stop
+	// matching it are released.
+	Selector *metav1.LabelSelector `json:"selector"`
+	// SubscriptionID is set on created Turtles whose template doesn't name a
+	// subscription. Changing it only affects Turtles created afterwards.
+	SubscriptionID string `json:"subscriptionId,omitempty"`
+	// CredentialsRef is set on created Turtles whose template doesn't
+	// reference credentials. It must name a Secret in the Bale's namespace,
+	// with the same tenant-id and client-id as the manager's credentials.
+	CredentialsRef *corev1.LocalObjectReference `json:"credentialsRef,omitempty"`
+	// Template describes the Turtles created by this Bale. Its labels must
+	// match the selector.
+	Template TurtleTemplateSpec `json:"template,omitempty"`
+	// Strategy describes how existing Turtles are updated when the template changes.
+	Strategy BaleUpdateStrategy `json:"strategy,omitempty"`
+	// Naming controls the names of created Turtles. It is immutable.
+	Naming NamingSpec `json:"naming,omitempty"`
+	// Spread distributes Turtles over several locations, overriding the
+	// location of the template. Turtles are placed as they are created and
+	// removed from the most crowded location first. Bales using the
+	// RollingUpdate strategy and random naming also move Turtles out of
+	// locations exceeding maxSkew.
+	Spread *LocationSpread `json:"spread,omitempty"`
+	// RevisionHistoryLimit is the number of previous templates kept as
+	// ControllerRevisions for rollback.
+	// +kubebuilder:default=10
+	// +kubebuilder:validation:Minimum=0
+	RevisionHistoryLimit *int32 `json:"revisionHistoryLimit,omitempty"`
 }
 
+// Conditions reported on a Bale.
+const (
+	// BaleAvailableCondition is true when at least replicas - maxUnavailable
+	// Turtles are ready.
+	BaleAvailableCondition ConditionType = "Available"
+	// BaleProgressingCondition is true while the Bale is scaling or updating
+	// its Turtles, and false once it has converged.
+	BaleProgressingCondition ConditionType = "Progressing"
+	// BaleReplicaFailureCondition is true when the Bale failed to create,
+	// update or delete Turtles.
+	BaleReplicaFailureCondition ConditionType = "ReplicaFailure"
+)
+
 // BaleStatus defines the observed state of Bale
 type BaleStatus struct {
-	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
+	// Replicas is the number of Turtles selected by this Bale which are not
+	// being deleted.
+	Replicas int32 `json:"replicas,omitempty"`
+	// ReadyReplicas is the number of selected Turtles which are ready.
+	ReadyReplicas int32 `json:"readyReplicas,omitempty"`
+	// UpdatedReplicas is the number of selected Turtles which match the
+	// current template.
+	UpdatedReplicas int32 `json:"updatedReplicas,omitempty"`
+	// Selector is the string form of spec.selector, used by the scale subresource.
+	Selector string `json:"selector,omitempty"`
+	// ObservedGeneration is the most recent generation observed by the controller.
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Revision is the number of the ControllerRevision matching the current template.
+	Revision int64 `json:"revision,omitempty"`
+	// Locations is the number of Turtles in each location.
+	Locations []LocationReplicas `json:"locations,omitempty"`
+	// Conditions describe the current state of the Bale.
+	Conditions Conditions `json:"conditions,omitempty"`
+}
+
+// LocationReplicas is the number of Turtles of a Bale in a location.
+type LocationReplicas struct {
+	Name string `json:"name"`
+	// Replicas is the number of Turtles in the location.
+	Replicas int32 `json:"replicas"`
+	// DesiredReplicas is the share of the location according to its weight.
+	DesiredReplicas int32 `json:"desiredReplicas"`
 }
 
 // +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:subresource:scale:specpath=.spec.replicas,statuspath=.status.replicas,selectorpath=.status.selector
+// +kubebuilder:printcolumn:name="Desired",type="integer",JSONPath=".spec.replicas"
+// +kubebuilder:printcolumn:name="Current",type="integer",JSONPath=".status.replicas"
+// +kubebuilder:printcolumn:name="Ready",type="integer",JSONPath=".status.readyReplicas"
+// +kubebuilder:printcolumn:name="Up-to-date",type="integer",JSONPath=".status.updatedReplicas"
+// +kubebuilder:printcolumn:name="Revision",type="integer",JSONPath=".status.revision",priority=1
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
 
 // Bale is the Schema for the bales API
 type Bale struct {
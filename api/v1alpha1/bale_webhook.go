@@ -23,10 +23,15 @@ package v1alpha1
 
 import (
 	"fmt"
+	"reflect"
+	"strconv"
 
 	"github.com/blang/semver"
 	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
 	ctrl "sigs.k8s.io/controller-runtime"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
@@ -51,13 +56,8 @@ var _ webhook.Defaulter = &Bale{}
 func (r *Bale) Default() {
 	balelog.Info("default", "name", r.Name)
 
-	controlPlaneVersion := r.Spec.Template.Version
-	for i := range r.Spec.Template.Hatchlings {
-		hatchling := r.Spec.Template.Hatchlings[i]
-		if hatchling.Version == "" {
-			hatchling.Version = controlPlaneVersion
-		}
-	}
+	// Hatchlings without a version are left alone: they follow the control
+	// plane, including through upgrades.
 }
 
 // TODO(user): change verbs to "verbs=create;update;delete" if you want to enable deletion validation.
@@ -69,28 +69,203 @@ var _ webhook.Validator = &Bale{}
 func (r *Bale) ValidateCreate() error {
 	balelog.Info("validate create", "name", r.Name)
 
-	// validate control plane has higher version than all workers
-	controlPlaneVersion := r.Spec.Template.Version
-	for i := range r.Spec.Template.Hatchlings {
-		hatchling := r.Spec.Template.Hatchlings[i]
-		if hatchling.Version != "" {
-			var cpSemver, hatchlingSemver semver.Version
-			var err error
+	return r.validate()
+}
 
-			cpSemver, err = semver.Make(controlPlaneVersion)
-			if err != nil {
-				return apierr.NewInternalError(err)
-			}
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (r *Bale) ValidateUpdate(old runtime.Object) error {
+	balelog.Info("validate update", "name", r.Name)
+
+	oldBale, ok := old.(*Bale)
+	if !ok {
+		return apierr.NewInternalError(fmt.Errorf("expected a Bale but got a %T", old))
+	}
+
+	if !reflect.DeepEqual(oldBale.Spec.Selector, r.Spec.Selector) {
+		return apierr.NewBadRequest("selector is immutable")
+	}
+
+	if oldBale.Spec.Naming != r.Spec.Naming {
+		return apierr.NewBadRequest("naming is immutable")
+	}
+
+	if err := r.validateUpgrade(oldBale); err != nil {
+		return err
+	}
+
+	return r.validate()
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (r *Bale) ValidateDelete() error {
+	balelog.Info("validate delete", "name", r.Name)
+
+	// TODO(user): fill in your validation logic upon object deletion.
+	return nil
+}
+
+func (r *Bale) validate() error {
+	if r.Spec.Replicas < 0 {
+		return apierr.NewBadRequest(fmt.Sprintf("replicas must not be negative, got %d", r.Spec.Replicas))
+	}
+	if err := r.validateSelector(); err != nil {
+		return err
+	}
+	if err := r.validateSpread(); err != nil {
+		return err
+	}
+	if err := r.validateNodeConfig(); err != nil {
+		return err
+	}
+	if err := r.validateNetwork(); err != nil {
+		return err
+	}
+	if err := r.validateNameLengths(); err != nil {
+		return err
+	}
+	return r.validateVersions()
+}
+
+// randomTurtleNameSuffixLength is the length of the suffix appended to the
+// prefix of turtle names in Random naming mode: a dash and six random
+// characters.
+const randomTurtleNameSuffixLength = 7
+
+// turtleNameSuffixLength returns the length of the longest suffix appended
+// to the prefix of turtle names. Ordinals go up to replicas-1.
+func (r *Bale) turtleNameSuffixLength() int {
+	if r.Spec.Naming.Mode != OrdinalNamingMode {
+		return randomTurtleNameSuffixLength
+	}
+	highest := r.Spec.Replicas - 1
+	if highest < 0 {
+		highest = 0
+	}
+	return 1 + len(strconv.Itoa(int(highest)))
+}
+
+// validateNameLengths ensures the names of turtles, and the names of the
+// machine deployments of their hatchlings, fit in label values, which
+// Cluster API and the controller label objects with.
+func (r *Bale) validateNameLengths() error {
+	prefix := r.Spec.Naming.Prefix
+	if prefix == "" {
+		prefix = r.Name
+	}
+
+	turtle := len(prefix) + r.turtleNameSuffixLength()
+	if turtle > validation.LabelValueMaxLength {
+		return apierr.NewBadRequest(fmt.Sprintf(
+			"turtle names would be up to %d characters, more than %d: shorten the bale name, set naming.prefix or lower replicas",
+			turtle, validation.LabelValueMaxLength))
+	}
+
+	for _, hatchling := range r.Spec.Template.Spec.Hatchlings {
+		if length := turtle + 1 + len(hatchling.Name); length > validation.LabelValueMaxLength {
+			return apierr.NewBadRequest(fmt.Sprintf(
+				"names of hatchling %s would be up to %d characters, more than %d: shorten the hatchling or turtle names",
+				hatchling.Name, length, validation.LabelValueMaxLength))
+		}
+	}
 
-			hatchlingSemver, err = semver.Make(hatchling.Version)
+	return nil
+}
+
+// validateNodeConfig ensures the nodes of every hatchling can be configured
+// as requested.
+func (r *Bale) validateNodeConfig() error {
+	for i := range r.Spec.Template.Spec.Hatchlings {
+		hatchling := &r.Spec.Template.Spec.Hatchlings[i]
+		if err := hatchling.ValidateNodeConfig(); err != nil {
+			return apierr.NewBadRequest(fmt.Sprintf("hatchling %s: %s", hatchling.Name, err))
+		}
+	}
+	return nil
+}
+
+// validateNetwork ensures a pinned CNI version is a version of a plugin Bale
+// installs.
+func (r *Bale) validateNetwork() error {
+	return r.Spec.Template.Spec.Network.validate()
+}
+
+// validateSpread ensures every location is listed once and at least one of
+// them may receive turtles.
+func (r *Bale) validateSpread() error {
+	if r.Spec.Spread == nil {
+		return nil
+	}
+
+	seen := map[string]bool{}
+	var total int32
+	for _, location := range r.Spec.Spread.Locations {
+		if seen[location.Name] {
+			return apierr.NewBadRequest(fmt.Sprintf("location %q is listed more than once", location.Name))
+		}
+		seen[location.Name] = true
+		total += location.Weight
+	}
+
+	if total == 0 {
+		return apierr.NewBadRequest("at least one location must have a positive weight")
+	}
+
+	return nil
+}
+
+// validateSelector ensures the bale will count the turtles it creates.
+func (r *Bale) validateSelector() error {
+	if r.Spec.Selector == nil {
+		return apierr.NewBadRequest("selector is required")
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(r.Spec.Selector)
+	if err != nil {
+		return apierr.NewBadRequest(fmt.Sprintf("invalid selector: %s", err))
+	}
+
+	if selector.Empty() {
+		return apierr.NewBadRequest("selector must not be empty")
+	}
+
+	if !selector.Matches(labels.Set(r.Spec.Template.Metadata.Labels)) {
+		return apierr.NewBadRequest(
+			fmt.Sprintf(
+				"selector %q does not match template labels %v",
+				selector.String(),
+				r.Spec.Template.Metadata.Labels,
+			),
+		)
+	}
+
+	return nil
+}
+
+// validateVersions ensures the control plane has a higher version than all
+// workers. Versions which aren't set are not compared.
+func (r *Bale) validateVersions() error {
+	controlPlaneVersion := r.Spec.Template.Spec.Version
+	var cpSemver semver.Version
+	if controlPlaneVersion != "" {
+		var err error
+		cpSemver, err = semver.ParseTolerant(controlPlaneVersion)
+		if err != nil {
+			return apierr.NewBadRequest(fmt.Sprintf("invalid control plane version %q: %s", controlPlaneVersion, err))
+		}
+	}
+
+	for i := range r.Spec.Template.Spec.Hatchlings {
+		hatchling := r.Spec.Template.Spec.Hatchlings[i]
+		if hatchling.Version != "" {
+			hatchlingSemver, err := semver.ParseTolerant(hatchling.Version)
 			if err != nil {
-				return apierr.NewInternalError(err)
+				return apierr.NewBadRequest(fmt.Sprintf("invalid version %q of hatchling %s: %s", hatchling.Version, hatchling.Name, err))
 			}
 
-			if cpSemver.LT(hatchlingSemver) {
+			if controlPlaneVersion != "" && cpSemver.LT(hatchlingSemver) {
 				return apierr.NewBadRequest(
 					fmt.Sprintf(
-						"control plane versin %s cannot be less than hatchling version %s",
+						"control plane version %s cannot be less than hatchling version %s",
 						controlPlaneVersion,
 						hatchling.Version,
 					),
@@ -101,18 +276,17 @@ func (r *Bale) ValidateCreate() error {
 	return nil
 }
 
-// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
-func (r *Bale) ValidateUpdate(old runtime.Object) error {
-	balelog.Info("validate update", "name", r.Name)
-
-	// TODO(user): fill in your validation logic upon object update.
-	return nil
-}
+// validateUpgrade ensures a changed template can be rolled out to existing
+// turtles, which upgrade from the previous version.
+func (r *Bale) validateUpgrade(old *Bale) error {
+	from, to := old.Spec.Template.Spec.Version, r.Spec.Template.Spec.Version
+	if from == to || from == "" {
+		return nil
+	}
 
-// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
-func (r *Bale) ValidateDelete() error {
-	balelog.Info("validate delete", "name", r.Name)
+	if err := ValidateUpgrade(from, to); err != nil {
+		return apierr.NewBadRequest(err.Error())
+	}
 
-	// TODO(user): fill in your validation logic upon object deletion.
 	return nil
 }
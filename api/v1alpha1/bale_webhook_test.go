@@ -0,0 +1,169 @@
+// Copyright 2020 Alexander Eldeib
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS
+// OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+// CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+// TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package v1alpha1
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestBaleDefault(t *testing.T) {
+	g := NewWithT(t)
+
+	bale := &Bale{
+		Spec: BaleSpec{
+			Template: TurtleTemplateSpec{
+				Spec: TurtleSpec{
+					Version: "1.18.3",
+					Hatchlings: []HatchlingSpec{
+						{Name: "default"},
+						{Name: "pinned", Version: "1.17.9"},
+					},
+				},
+			},
+		},
+	}
+	bale.Default()
+
+	g.Expect(bale.Spec.Template.Spec.Hatchlings[0].Version).To(BeEmpty(), "hatchlings without a version follow the control plane")
+	g.Expect(bale.Spec.Template.Spec.Hatchlings[1].Version).To(Equal("1.17.9"))
+}
+
+func TestBaleValidateVersions(t *testing.T) {
+	cases := []struct {
+		name         string
+		controlPlane string
+		hatchling    string
+		valid        bool
+	}{
+		{name: "unset", valid: true},
+		{name: "control plane only", controlPlane: "1.18.3", valid: true},
+		{name: "hatchling only", hatchling: "1.18.3", valid: true},
+		{name: "hatchling behind", controlPlane: "1.18.3", hatchling: "1.17.9", valid: true},
+		{name: "hatchling ahead", controlPlane: "1.17.9", hatchling: "1.18.3"},
+		{name: "invalid control plane", controlPlane: "latest"},
+		{name: "invalid hatchling", controlPlane: "1.18.3", hatchling: "latest"},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			bale := &Bale{
+				ObjectMeta: metav1.ObjectMeta{Name: "bale"},
+				Spec: BaleSpec{
+					Template: TurtleTemplateSpec{
+						Spec: TurtleSpec{
+							Version:    tc.controlPlane,
+							Hatchlings: []HatchlingSpec{{Name: "default", Version: tc.hatchling}},
+						},
+					},
+				},
+			}
+
+			err := bale.validateVersions()
+			if tc.valid {
+				g.Expect(err).NotTo(HaveOccurred())
+				return
+			}
+			g.Expect(err).To(HaveOccurred())
+		})
+	}
+}
+
+func TestBaleValidateReplicas(t *testing.T) {
+	g := NewWithT(t)
+
+	bale := &Bale{Spec: BaleSpec{Replicas: -1}}
+	g.Expect(bale.ValidateCreate()).To(MatchError(ContainSubstring("must not be negative")))
+}
+
+func TestBaleValidateNameLengths(t *testing.T) {
+	g := NewWithT(t)
+
+	bale := &Bale{
+		ObjectMeta: metav1.ObjectMeta{Name: strings.Repeat("b", 60)},
+		Spec: BaleSpec{
+			Template: TurtleTemplateSpec{
+				Spec: TurtleSpec{Hatchlings: []HatchlingSpec{{Name: "default"}}},
+			},
+		},
+	}
+	g.Expect(bale.validateNameLengths()).To(MatchError(ContainSubstring("turtle names")))
+
+	bale.Spec.Naming.Prefix = "short"
+	g.Expect(bale.validateNameLengths()).To(Succeed())
+
+	bale.Spec.Template.Spec.Hatchlings[0].Name = strings.Repeat("h", 55)
+	g.Expect(bale.validateNameLengths()).To(MatchError(ContainSubstring("hatchling")))
+}
+
+func TestBaleValidateNetwork(t *testing.T) {
+	cases := map[string]struct {
+		network NetworkSpec
+		wantErr string
+	}{
+		"unpinned":         {network: NetworkSpec{CNI: CNINone}},
+		"pinned":           {network: NetworkSpec{CNI: CNICilium, CNIVersion: "v1.8.2"}},
+		"defaulted plugin": {network: NetworkSpec{CNIVersion: "v3.12.1"}},
+		"no plugin":        {network: NetworkSpec{CNI: CNINone, CNIVersion: "v3.12.1"}, wantErr: "without a cni plugin"},
+		"unknown plugin":   {network: NetworkSpec{CNI: "weave", CNIVersion: "v2.6.5"}, wantErr: "unknown cni plugin"},
+		"missing v":        {network: NetworkSpec{CNI: CNICalico, CNIVersion: "3.12.1"}, wantErr: "must start with v"},
+		"invalid version":  {network: NetworkSpec{CNI: CNICalico, CNIVersion: "v3.12"}, wantErr: "invalid cniVersion"},
+	}
+
+	for name, tc := range cases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			g := NewWithT(t)
+			bale := &Bale{Spec: BaleSpec{Template: TurtleTemplateSpec{Spec: TurtleSpec{Network: tc.network}}}}
+			if tc.wantErr == "" {
+				g.Expect(bale.validateNetwork()).To(Succeed())
+				return
+			}
+			g.Expect(bale.validateNetwork()).To(MatchError(ContainSubstring(tc.wantErr)))
+		})
+	}
+}
+
+func TestBaleValidateOrdinalNameLengths(t *testing.T) {
+	g := NewWithT(t)
+
+	bale := &Bale{
+		ObjectMeta: metav1.ObjectMeta{Name: "bale"},
+		Spec: BaleSpec{
+			Replicas: 10,
+			Naming:   NamingSpec{Mode: OrdinalNamingMode, Prefix: strings.Repeat("p", 60)},
+		},
+	}
+	g.Expect(bale.validateNameLengths()).To(Succeed(), "ordinals up to 9 add two characters")
+
+	bale.Spec.Replicas = 11
+	g.Expect(bale.validateNameLengths()).To(Succeed(), "ordinals up to 10 add three characters")
+
+	bale.Spec.Replicas = 101
+	g.Expect(bale.validateNameLengths()).To(MatchError(ContainSubstring("turtle names would be up to 64 characters")))
+}
@@ -21,6 +21,10 @@
 
 package v1alpha1
 
+import (
+	corev1 "k8s.io/api/core/v1"
+)
+
 // HatchlingSpec defines the desired state of Hatchling
 type HatchlingSpec struct {
 	Name string `json:"name"`
@@ -31,10 +35,45 @@ type HatchlingSpec struct {
 	Version  string `json:"version,omitempty"`
 	// +kubebuilder:default=Standard_D8s_v3
 	VMSize string `json:"vmSize,omitempty"`
+	// NodeLabels are added to the nodes of the hatchling when they register.
+	// Labels under kubernetes.io and k8s.io can only be set under the
+	// kubelet.kubernetes.io and node.kubernetes.io prefixes.
+	NodeLabels map[string]string `json:"nodeLabels,omitempty"`
+	// Taints are added to the nodes of the hatchling when they register.
+	Taints []corev1.Taint `json:"taints,omitempty"`
+	// KubeletExtraArgs are passed to the kubelet of the nodes of the
+	// hatchling. Cloud provider flags are set by the controller, and node
+	// labels and taints through their own fields.
+	KubeletExtraArgs map[string]string `json:"kubeletExtraArgs,omitempty"`
+	// Sysctls are kernel parameters set on the nodes of the hatchling before
+	// they join the cluster.
+	Sysctls map[string]string `json:"sysctls,omitempty"`
+	// FailureDomain is the availability zone the nodes of the hatchling are
+	// placed in, e.g. "1". It must be one of the failure domains of the
+	// cluster. Nodes are placed without a zone if empty. A pool is spread
+	// across zones with one hatchling per zone.
+	FailureDomain string `json:"failureDomain,omitempty"`
 }
 
+// NodeConfigHashAnnotation on the machine template of a machine deployment
+// is a hash of the node configuration of its hatchling, so changing the
+// configuration rolls the nodes.
+const NodeConfigHashAnnotation = "infra.alexeldeib.xyz/node-config-hash"
+
 // HatchlingStatus defines the observed state of Hatchling
-type HatchlingStatus struct{}
+type HatchlingStatus struct {
+	Name string `json:"name"`
+	// Replicas is the desired number of nodes.
+	Replicas int32 `json:"replicas"`
+	// ReadyReplicas is the number of nodes which are ready.
+	ReadyReplicas int32 `json:"readyReplicas"`
+	// UpdatedReplicas is the number of nodes matching the current template.
+	UpdatedReplicas int32 `json:"updatedReplicas"`
+	// Phase of the MachineDeployment backing the hatchling.
+	Phase string `json:"phase,omitempty"`
+	// Version is the Kubernetes version the hatchling is rolled out to.
+	Version string `json:"version,omitempty"`
+}
 
 // // kubebuilder:object:root=true
 
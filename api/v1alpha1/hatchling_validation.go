@@ -0,0 +1,114 @@
+// Copyright 2020 Alexander Eldeib
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS
+// OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+// CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+// TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package v1alpha1
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// reservedKubeletArgs can't be passed through KubeletExtraArgs, mapped to
+// what sets them instead.
+var reservedKubeletArgs = map[string]string{
+	"cloud-config":         "the controller",
+	"cloud-provider":       "the controller",
+	"node-labels":          "nodeLabels",
+	"register-with-taints": "taints",
+}
+
+// sysctlPattern matches the names of kernel parameters.
+var sysctlPattern = regexp.MustCompile(`^([a-z0-9]([-_a-z0-9]*[a-z0-9])?[./])*[a-z0-9]([-_a-z0-9]*[a-z0-9])?$`)
+
+// ValidateNodeConfig returns an error if the node labels, taints, kubelet
+// arguments or sysctls of a hatchling can't be applied to its nodes.
+func (h *HatchlingSpec) ValidateNodeConfig() error {
+	for key, value := range h.NodeLabels {
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			return fmt.Errorf("invalid node label %q: %s", key, strings.Join(errs, "; "))
+		}
+		if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+			return fmt.Errorf("invalid value of node label %q: %s", key, strings.Join(errs, "; "))
+		}
+		if restrictedNodeLabel(key) {
+			return fmt.Errorf("node label %q can't be set by the kubelet", key)
+		}
+	}
+
+	for _, taint := range h.Taints {
+		if errs := validation.IsQualifiedName(taint.Key); len(errs) > 0 {
+			return fmt.Errorf("invalid taint key %q: %s", taint.Key, strings.Join(errs, "; "))
+		}
+		if errs := validation.IsValidLabelValue(taint.Value); len(errs) > 0 {
+			return fmt.Errorf("invalid value of taint %q: %s", taint.Key, strings.Join(errs, "; "))
+		}
+		switch taint.Effect {
+		case corev1.TaintEffectNoSchedule, corev1.TaintEffectPreferNoSchedule, corev1.TaintEffectNoExecute:
+		default:
+			return fmt.Errorf("invalid effect %q of taint %q", taint.Effect, taint.Key)
+		}
+	}
+
+	for key := range h.KubeletExtraArgs {
+		if setBy, ok := reservedKubeletArgs[key]; ok {
+			return fmt.Errorf("kubelet argument %q is set by %s", key, setBy)
+		}
+	}
+
+	for key, value := range h.Sysctls {
+		if !sysctlPattern.MatchString(key) {
+			return fmt.Errorf("invalid sysctl %q", key)
+		}
+		if strings.ContainsAny(value, "\n\r") {
+			return fmt.Errorf("value of sysctl %q must be a single line", key)
+		}
+	}
+
+	return nil
+}
+
+// restrictedNodeLabel reports whether the NodeRestriction admission plugin
+// stops kubelets from setting a label on their own node.
+func restrictedNodeLabel(key string) bool {
+	i := strings.Index(key, "/")
+	if i < 0 {
+		return false
+	}
+
+	prefix := key[:i]
+	switch {
+	case prefix == "kubelet.kubernetes.io" || strings.HasSuffix(prefix, ".kubelet.kubernetes.io"):
+		return false
+	case prefix == "node.kubernetes.io" || strings.HasSuffix(prefix, ".node.kubernetes.io"):
+		return false
+	}
+
+	for _, domain := range []string{"kubernetes.io", "k8s.io"} {
+		if prefix == domain || strings.HasSuffix(prefix, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
@@ -22,27 +22,223 @@
 package v1alpha1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 // TurtleSpec defines the desired state of Turtle
 type TurtleSpec struct {
 	// +kubebuilder:default=1
-	ControlPlaneReplicas int32           `json:"controlPlaneReplicas,omitempty"`
-	Location             string          `json:"location"`
-	ResourceGroup        string          `json:"resourceGroup,omitempty"`
-	Hatchlings           []HatchlingSpec `json:"hatchlings,omitempty"`
+	ControlPlaneReplicas int32 `json:"controlPlaneReplicas,omitempty"`
+	// ControlPlaneVMSize is the Azure VM size of control plane machines.
+	// +kubebuilder:default=Standard_D2s_v3
+	ControlPlaneVMSize string `json:"controlPlaneVMSize,omitempty"`
+	// ControlPlaneOSDiskSizeGB is the OS disk size of control plane machines.
+	// +kubebuilder:default=128
+	ControlPlaneOSDiskSizeGB int32           `json:"controlPlaneOSDiskSizeGB,omitempty"`
+	Location                 string          `json:"location"`
+	ResourceGroup            string          `json:"resourceGroup,omitempty"`
+	Hatchlings               []HatchlingSpec `json:"hatchlings,omitempty"`
 	// Version is the Kubernetes version of the control plane.
 	Version string `json:"version"`
+	// SubscriptionID is the Azure subscription the cluster is created in.
+	// Defaults to the subscription of the credentials.
+	SubscriptionID string `json:"subscriptionId,omitempty"`
+	// CredentialsRef names a Secret in the Turtle's namespace with the
+	// subscription-id, tenant-id, client-id and client-secret keys used by
+	// the cluster's cloud provider. Defaults to the manager's credentials.
+	// Azure resources are always created with the manager's identity, so
+	// the tenant-id and client-id must match the manager's, and only the
+	// subscription may differ.
+	CredentialsRef *corev1.LocalObjectReference `json:"credentialsRef,omitempty"`
+	// Paused stops the controller from changing the Cluster API objects and
+	// the remote cluster of the Turtle. It is propagated to the Cluster API
+	// controllers through the cluster.x-k8s.io/paused annotation.
+	Paused bool `json:"paused,omitempty"`
+	// Network configures pod networking in the cluster.
+	Network NetworkSpec `json:"network,omitempty"`
+}
+
+// CNIPlugin is a CNI plugin bundled with Bale.
+// +kubebuilder:validation:Enum=calico;cilium;flannel;none
+type CNIPlugin string
+
+const (
+	// CNICalico installs Project Calico.
+	CNICalico CNIPlugin = "calico"
+	// CNICilium installs Cilium.
+	CNICilium CNIPlugin = "cilium"
+	// CNIFlannel installs flannel. Pod CIDRs are allocated to nodes by the
+	// controller manager.
+	CNIFlannel CNIPlugin = "flannel"
+	// CNINone leaves installing a CNI plugin to the user.
+	CNINone CNIPlugin = "none"
+)
+
+// NetworkSpec configures pod networking of a Turtle.
+type NetworkSpec struct {
+	// CNI is the CNI plugin installed into the cluster, templated with the
+	// pod CIDR of the cluster. It is immutable: switching plugins requires
+	// replacing the cluster.
+	// +kubebuilder:default=calico
+	CNI CNIPlugin `json:"cni,omitempty"`
+	// CNIVersion pins the bundled version of the CNI plugin. The plugin is
+	// only upgraded when this changes; new clusters get the newest bundled
+	// version. Versions which aren't bundled with the controller are
+	// reported on the CNIInstalled condition.
+	// +optional
+	CNIVersion string `json:"cniVersion,omitempty"`
+}
+
+const (
+	// TurtleFinalizer holds a Turtle until its cluster has been torn down.
+	TurtleFinalizer = "infra.alexeldeib.xyz/turtle"
+	// TurtleNameLabel is set to the name of the Turtle on every object
+	// generated for it.
+	TurtleNameLabel = "infra.alexeldeib.xyz/turtle"
+	// HatchlingNameLabel is set to the name of the hatchling on objects
+	// generated for a single hatchling of a Turtle. Labelled objects of
+	// hatchlings removed from the spec are pruned.
+	HatchlingNameLabel = "infra.alexeldeib.xyz/hatchling"
+	// ForcePruneAnnotation on a Turtle prunes removed hatchlings even if
+	// their nodes still run workloads.
+	ForcePruneAnnotation = "infra.alexeldeib.xyz/force-prune"
+	// SkipRemoteCleanupAnnotation on a Turtle skips deleting load balancers
+	// and volumes in its cluster during teardown, e.g. when the cluster is
+	// unreachable. Their Azure resources may leak.
+	SkipRemoteCleanupAnnotation = "infra.alexeldeib.xyz/skip-remote-cleanup"
+	// PausedByTurtleAnnotation on a Cluster records that the Turtle
+	// controller paused it, so it only resumes Clusters it paused itself
+	// and leaves pauses of operators or clusterctl move alone.
+	PausedByTurtleAnnotation = "infra.alexeldeib.xyz/paused-by-turtle"
+)
+
+// TurtlePhase is a summary of the lifecycle of a Turtle.
+type TurtlePhase string
+
+const (
+	// TurtlePhasePending means the Cluster has not been created yet.
+	TurtlePhasePending TurtlePhase = "Pending"
+	// TurtlePhaseProvisioning means the infrastructure or control plane is
+	// being created.
+	TurtlePhaseProvisioning TurtlePhase = "Provisioning"
+	// TurtlePhaseProvisioned means the control plane is reachable through
+	// the kubeconfig, though hatchlings may still be scaling.
+	TurtlePhaseProvisioned TurtlePhase = "Provisioned"
+	// TurtlePhaseDeleting means the Turtle is being deleted.
+	TurtlePhaseDeleting TurtlePhase = "Deleting"
+	// TurtlePhaseFailed means Cluster API reported a terminal failure.
+	TurtlePhaseFailed TurtlePhase = "Failed"
+)
+
+// Conditions reported on a Turtle.
+const (
+	// TurtleInfrastructureReadyCondition is true once the AzureCluster is
+	// provisioned.
+	TurtleInfrastructureReadyCondition ConditionType = "InfrastructureReady"
+	// TurtleControlPlaneReadyCondition is true once every control plane
+	// replica is ready.
+	TurtleControlPlaneReadyCondition ConditionType = "ControlPlaneReady"
+	// TurtleKubeconfigAvailableCondition is true once the kubeconfig secret
+	// of the cluster exists.
+	TurtleKubeconfigAvailableCondition ConditionType = "KubeconfigAvailable"
+	// TurtleHatchlingsReadyCondition is true once every hatchling has its
+	// desired number of ready nodes.
+	TurtleHatchlingsReadyCondition ConditionType = "HatchlingsReady"
+	// TurtleReadyCondition is true when all of the above are.
+	TurtleReadyCondition ConditionType = "Ready"
+	// TurtleDeletingCondition is true while the cluster of a deleted Turtle
+	// is torn down. Its reason names the step being waited on.
+	TurtleDeletingCondition ConditionType = "Deleting"
+	// TurtleHatchlingsPrunedCondition is false while node pools of removed
+	// hatchlings are kept because they still run workloads.
+	TurtleHatchlingsPrunedCondition ConditionType = "HatchlingsPruned"
+	// TurtleReconciledCondition is false while a step of reconciling the
+	// Turtle waits on something or fails. Its message names the steps.
+	TurtleReconciledCondition ConditionType = "Reconciled"
+	// TurtleUpgradingCondition is true while the Kubernetes version of the
+	// control plane or a hatchling is being changed, or waits to be.
+	TurtleUpgradingCondition ConditionType = "Upgrading"
+	// TurtleUpgradeStalledCondition is true when an upgrade can't progress,
+	// either because the requested versions are not a supported upgrade or
+	// because a step took longer than expected.
+	TurtleUpgradeStalledCondition ConditionType = "UpgradeStalled"
+	// TurtleCNIInstalledCondition is true once the CNI plugin is applied to
+	// the cluster.
+	TurtleCNIInstalledCondition ConditionType = "CNIInstalled"
+)
+
+// ControlPlaneComponent names the control plane in an UpgradeStatus.
+const ControlPlaneComponent = "control-plane"
+
+// UpgradeStatus is the step of a Kubernetes version upgrade in progress.
+// Components are upgraded one at a time, the control plane first and then
+// each hatchling in the order of the spec.
+type UpgradeStatus struct {
+	// Component is ControlPlaneComponent or the name of a hatchling.
+	Component string `json:"component"`
+	// Version the component is upgraded to.
+	Version string `json:"version"`
+	// StartTime is when the component started upgrading.
+	StartTime metav1.Time `json:"startTime"`
+}
+
+// ControlPlaneStatus is the observed state of the control plane of a Turtle.
+type ControlPlaneStatus struct {
+	// Replicas is the number of control plane machines.
+	Replicas int32 `json:"replicas"`
+	// ReadyReplicas is the number of control plane machines which are ready.
+	ReadyReplicas int32 `json:"readyReplicas"`
+	// UpdatedReplicas is the number of control plane machines matching the
+	// current spec.
+	UpdatedReplicas int32 `json:"updatedReplicas"`
+	// Version is the Kubernetes version the control plane is rolled out to.
+	Version string `json:"version,omitempty"`
+	// FailureDomains are the availability zones of the control plane
+	// machines. The control plane is spread across the failure domains of
+	// the cluster, which Azure reports for locations with zones.
+	FailureDomains []string `json:"failureDomains,omitempty"`
+}
+
+// CNIStatus is the CNI plugin installed into the cluster of a Turtle.
+type CNIStatus struct {
+	// Plugin is the installed plugin.
+	Plugin CNIPlugin `json:"plugin"`
+	// Version is the installed version of the plugin.
+	Version string `json:"version"`
 }
 
 // TurtleStatus defines the observed state of Turtle
 type TurtleStatus struct {
-	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
+	// Phase summarizes the lifecycle of the Turtle.
+	Phase TurtlePhase `json:"phase,omitempty"`
+	// APIEndpoint is the host:port of the Kubernetes API server.
+	APIEndpoint string `json:"apiEndpoint,omitempty"`
+	// ControlPlane is the observed state of the KubeadmControlPlane.
+	ControlPlane ControlPlaneStatus `json:"controlPlane,omitempty"`
+	// Hatchlings is the observed state of each hatchling in the spec.
+	Hatchlings []HatchlingStatus `json:"hatchlings,omitempty"`
+	// Upgrade is the version upgrade in progress, if any.
+	Upgrade *UpgradeStatus `json:"upgrade,omitempty"`
+	// CNI is the CNI plugin installed into the cluster, if any.
+	CNI *CNIStatus `json:"cni,omitempty"`
+	// FailureReason is a terminal failure reported by Cluster API.
+	FailureReason string `json:"failureReason,omitempty"`
+	// FailureMessage describes the terminal failure.
+	FailureMessage string `json:"failureMessage,omitempty"`
+	// ObservedGeneration is the most recent generation observed by the controller.
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Conditions describe the current state of the Turtle.
+	Conditions Conditions `json:"conditions,omitempty"`
 }
 
 // +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Phase",type="string",JSONPath=".status.phase"
+// +kubebuilder:printcolumn:name="Location",type="string",JSONPath=".spec.location"
+// +kubebuilder:printcolumn:name="Version",type="string",JSONPath=".spec.version"
+// +kubebuilder:printcolumn:name="Endpoint",type="string",JSONPath=".status.apiEndpoint",priority=1
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
 
 // Turtle is the Schema for the turtles API
 type Turtle struct {
@@ -54,7 +250,6 @@ type Turtle struct {
 }
 
 // +kubebuilder:object:root=true
-// +kubebuilder:subresource:status
 
 // TurtleList contains a list of Turtle
 type TurtleList struct {
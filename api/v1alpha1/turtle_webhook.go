@@ -0,0 +1,111 @@
+// Copyright 2020 Alexander Eldeib
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS
+// OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+// CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+// TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package v1alpha1
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/blang/semver"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// turtlelog is for logging in this package.
+var turtlelog = logf.Log.WithName("turtle-resource")
+
+func (r *Turtle) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+// +kubebuilder:webhook:verbs=create;update,path=/validate-infra-alexeldeib-xyz-v1alpha1-turtle,mutating=false,failurePolicy=fail,groups=infra.alexeldeib.xyz,resources=turtles,versions=v1alpha1,name=vturtle.kb.io
+
+var _ webhook.Validator = &Turtle{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (r *Turtle) ValidateCreate() error {
+	turtlelog.Info("validate create", "name", r.Name)
+
+	return r.Spec.Network.validate()
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (r *Turtle) ValidateUpdate(old runtime.Object) error {
+	turtlelog.Info("validate update", "name", r.Name)
+
+	oldTurtle, ok := old.(*Turtle)
+	if !ok {
+		return apierr.NewInternalError(fmt.Errorf("expected a Turtle but got a %T", old))
+	}
+
+	// Switching the CNI plugin of a running cluster requires draining every
+	// node, and the controller manager flags flannel relies on can't be set
+	// on an existing control plane.
+	if oldTurtle.Spec.Network.plugin() != r.Spec.Network.plugin() {
+		return apierr.NewBadRequest(fmt.Sprintf("network.cni is immutable, it is %s", oldTurtle.Spec.Network.plugin()))
+	}
+
+	return r.Spec.Network.validate()
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (r *Turtle) ValidateDelete() error {
+	return nil
+}
+
+// plugin returns the CNI plugin, which defaults to calico.
+func (network NetworkSpec) plugin() CNIPlugin {
+	if network.CNI == "" {
+		return CNICalico
+	}
+	return network.CNI
+}
+
+// validate ensures a pinned CNI version is a version of a plugin Bale
+// installs. Whether the version is bundled is reported by the controller, as
+// bundled manifests ship with it rather than with the API.
+func (network NetworkSpec) validate() error {
+	if network.CNIVersion == "" {
+		return nil
+	}
+
+	switch network.plugin() {
+	case CNICalico, CNICilium, CNIFlannel:
+	case CNINone:
+		return apierr.NewBadRequest("cniVersion can't be set without a cni plugin")
+	default:
+		return apierr.NewBadRequest(fmt.Sprintf("unknown cni plugin %q", network.CNI))
+	}
+
+	if !strings.HasPrefix(network.CNIVersion, "v") {
+		return apierr.NewBadRequest(fmt.Sprintf("cniVersion %s must start with v", network.CNIVersion))
+	}
+	if _, err := semver.Parse(network.CNIVersion[1:]); err != nil {
+		return apierr.NewBadRequest(fmt.Sprintf("invalid cniVersion %s: %s", network.CNIVersion, err))
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+// Copyright 2020 Alexander Eldeib
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS
+// OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+// CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+// TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package v1alpha1
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestTurtleValidateUpdate(t *testing.T) {
+	cases := map[string]struct {
+		old, new NetworkSpec
+		wantErr  string
+	}{
+		"unchanged":           {old: NetworkSpec{CNI: CNIFlannel}, new: NetworkSpec{CNI: CNIFlannel}},
+		"version upgrade":     {old: NetworkSpec{CNI: CNICalico}, new: NetworkSpec{CNI: CNICalico, CNIVersion: "v3.12.1"}},
+		"defaulted plugin":    {old: NetworkSpec{}, new: NetworkSpec{CNI: CNICalico}},
+		"switch to flannel":   {old: NetworkSpec{CNI: CNICalico}, new: NetworkSpec{CNI: CNIFlannel}, wantErr: "network.cni is immutable"},
+		"switch from flannel": {old: NetworkSpec{CNI: CNIFlannel}, new: NetworkSpec{CNI: CNINone}, wantErr: "network.cni is immutable"},
+		"invalid version":     {old: NetworkSpec{CNI: CNICalico}, new: NetworkSpec{CNI: CNICalico, CNIVersion: "3.12.1"}, wantErr: "must start with v"},
+	}
+
+	for name, tc := range cases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			g := NewWithT(t)
+			old := &Turtle{Spec: TurtleSpec{Network: tc.old}}
+			turtle := &Turtle{Spec: TurtleSpec{Network: tc.new}}
+			if tc.wantErr == "" {
+				g.Expect(turtle.ValidateUpdate(old)).To(Succeed())
+				return
+			}
+			g.Expect(turtle.ValidateUpdate(old)).To(MatchError(ContainSubstring(tc.wantErr)))
+		})
+	}
+}
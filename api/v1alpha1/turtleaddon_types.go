@@ -0,0 +1,260 @@
+// Copyright 2020 Alexander Eldeib
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS
+// OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+// CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+// TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// AddonSourceKind is the kind of object manifests of an addon are read from.
+type AddonSourceKind string
+
+const (
+	// AddonSourceConfigMap reads manifests from a ConfigMap.
+	AddonSourceConfigMap AddonSourceKind = "ConfigMap"
+	// AddonSourceSecret reads manifests from a Secret.
+	AddonSourceSecret AddonSourceKind = "Secret"
+)
+
+// AddonSource references a ConfigMap or Secret in the namespace of the
+// TurtleAddon. Every value of it is a manifest of one or more objects.
+type AddonSource struct {
+	// Kind is ConfigMap or Secret.
+	// +kubebuilder:validation:Enum=ConfigMap;Secret
+	// +kubebuilder:default=ConfigMap
+	Kind AddonSourceKind `json:"kind,omitempty"`
+	// Name of the ConfigMap or Secret.
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+	// Keys are applied in the order listed. Defaults to every key, sorted
+	// by name.
+	// +optional
+	Keys []string `json:"keys,omitempty"`
+	// Path is the directory the keys of the source are placed in when the
+	// addon is built with kustomize, relative to the root of the build.
+	// Defaults to the root.
+	// +optional
+	Path string `json:"path,omitempty"`
+}
+
+// KustomizeSpec builds the manifests of an addon with kustomize, from the
+// files of its sources.
+type KustomizeSpec struct {
+	// Path is the directory of the kustomization to build, relative to the
+	// root of the build. It is a Go template evaluated against each selected
+	// Turtle, so Turtles of different classes can build different overlays,
+	// e.g. overlays/{{ index .Labels "class" }}.
+	// +kubebuilder:default="."
+	Path string `json:"path,omitempty"`
+}
+
+// HelmChartSource references a chart stored in a ConfigMap in the
+// namespace of the TurtleAddon, either as a packaged chart or as a chart
+// repository: an index.yaml key and the packaged charts it lists, keyed by
+// the file name of their URL.
+type HelmChartSource struct {
+	// ConfigMap holding the chart.
+	// +kubebuilder:validation:MinLength=1
+	ConfigMap string `json:"configMap"`
+	// Key of a packaged chart in the ConfigMap. Set either key, or name and
+	// optionally version.
+	// +optional
+	Key string `json:"key,omitempty"`
+	// Name of the chart in the repository index.
+	// +optional
+	Name string `json:"name,omitempty"`
+	// Version is a semver constraint on the version of the chart in the
+	// repository index, like ~1.2.0. Defaults to the newest stable version.
+	// Revisions record the exact version, so rollbacks restore it.
+	// +optional
+	Version string `json:"version,omitempty"`
+}
+
+// HelmSpec installs a Helm chart into the selected clusters.
+type HelmSpec struct {
+	// Chart is the chart of the release.
+	Chart HelmChartSource `json:"chart"`
+	// ReleaseName is the name of the release. Defaults to the name of the
+	// TurtleAddon.
+	// +optional
+	ReleaseName string `json:"releaseName,omitempty"`
+	// Namespace the release is installed to. It is created if it doesn't
+	// exist.
+	// +kubebuilder:default=default
+	Namespace string `json:"namespace,omitempty"`
+	// Values override the default values of the chart. They are a YAML
+	// document, and a Go template with the sprig functions evaluated against
+	// each selected Turtle, e.g. "location: {{ .Spec.Location }}".
+	// +optional
+	Values string `json:"values,omitempty"`
+}
+
+// TurtleAddonSpec defines the desired state of TurtleAddon
+type TurtleAddonSpec struct {
+	// Selector selects the Turtles in the namespace of the addon whose
+	// clusters it is applied to.
+	Selector *metav1.LabelSelector `json:"selector"`
+	// Sources hold the manifests of the addon, applied in order. Exactly
+	// one of sources and helm is set.
+	// +optional
+	Sources []AddonSource `json:"sources,omitempty"`
+	// Kustomize builds the manifests with kustomize instead of applying the
+	// sources as they are.
+	// +optional
+	Kustomize *KustomizeSpec `json:"kustomize,omitempty"`
+	// Helm installs a chart instead of applying sources. Every distinct
+	// release is recorded as a ControllerRevision, and the rollback-to
+	// annotation rolls the release back to one of them. Charts render as
+	// helm template renders them; dependencies must be vendored in the
+	// charts directory. Install and upgrade hooks are applied with the
+	// release, ignoring their weights and deletion policies, and other
+	// hooks are left out.
+	// +optional
+	Helm *HelmSpec `json:"helm,omitempty"`
+	// RevisionHistoryLimit is the number of previous helm releases kept as
+	// ControllerRevisions for rollback.
+	// +kubebuilder:default=10
+	// +kubebuilder:validation:Minimum=0
+	RevisionHistoryLimit *int32 `json:"revisionHistoryLimit,omitempty"`
+	// RenderOnly writes the manifests for each selected Turtle to the
+	// ConfigMap <name>-<turtle>-rendered instead of applying them, or to a
+	// Secret for helm releases and if any source is a Secret.
+	RenderOnly bool `json:"renderOnly,omitempty"`
+	// ResyncPeriod is how often the manifests are re-applied to repair
+	// drift in the workload clusters. Changes to the addon, its sources or
+	// the selected Turtles are applied right away.
+	// +kubebuilder:default="10m"
+	ResyncPeriod *metav1.Duration `json:"resyncPeriod,omitempty"`
+	// Paused stops the addon from being applied.
+	Paused bool `json:"paused,omitempty"`
+}
+
+// TurtleAddonNameLabel is set on the ControllerRevisions recording the helm
+// releases of a TurtleAddon and on its rendered manifests, and holds the
+// name of the TurtleAddon.
+const TurtleAddonNameLabel = "infra.alexeldeib.xyz/turtleaddon-name"
+
+// Conditions reported on a TurtleAddon.
+const (
+	// TurtleAddonAppliedCondition is true once the current manifests are
+	// applied to every selected cluster.
+	TurtleAddonAppliedCondition ConditionType = "Applied"
+)
+
+// AddonClusterStatus is the state of an addon in the cluster of a Turtle.
+type AddonClusterStatus struct {
+	// Turtle is the name of the selected Turtle.
+	Turtle string `json:"turtle"`
+	// Applied is true if the current manifests for the cluster are applied.
+	Applied bool `json:"applied"`
+	// ManifestHash is the hash of the manifests last applied successfully.
+	// Manifests built with kustomize may differ between clusters.
+	// +optional
+	ManifestHash string `json:"manifestHash,omitempty"`
+	// InputHash is the hash of what the manifests last applied were
+	// rendered from: the sources or helm revision, and the metadata and
+	// spec of the Turtle. Until it changes, the manifests are only rendered
+	// again once the resync period passes.
+	// +optional
+	InputHash string `json:"inputHash,omitempty"`
+	// LastAppliedTime is when the manifests were last applied successfully.
+	// +optional
+	LastAppliedTime *metav1.Time `json:"lastAppliedTime,omitempty"`
+	// Revision is the helm release revision applied to the cluster.
+	// +optional
+	Revision int64 `json:"revision,omitempty"`
+	// Chart is the name and version of the helm chart applied to the
+	// cluster.
+	// +optional
+	Chart string `json:"chart,omitempty"`
+	// Objects are the objects of the helm release applied to the cluster.
+	// Objects a later revision of the release no longer renders are deleted
+	// from the cluster once it is applied.
+	// +optional
+	Objects []AddonObjectReference `json:"objects,omitempty"`
+	// Message explains why the manifests are not applied.
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// AddonObjectReference identifies an object applied to a workload cluster.
+type AddonObjectReference struct {
+	// APIVersion of the object.
+	APIVersion string `json:"apiVersion"`
+	// Kind of the object.
+	Kind string `json:"kind"`
+	// Namespace of the object, empty for cluster-scoped objects.
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+	// Name of the object.
+	Name string `json:"name"`
+}
+
+// TurtleAddonStatus defines the observed state of TurtleAddon
+type TurtleAddonStatus struct {
+	// Revision is the number of the ControllerRevision matching the current
+	// helm release.
+	Revision int64 `json:"revision,omitempty"`
+	// Clusters is the state of the addon in each selected cluster.
+	Clusters []AddonClusterStatus `json:"clusters,omitempty"`
+	// AppliedClusters is the number of selected clusters the current
+	// manifests are applied to.
+	AppliedClusters int32 `json:"appliedClusters,omitempty"`
+	// SelectedClusters is the number of selected Turtles.
+	SelectedClusters int32 `json:"selectedClusters,omitempty"`
+	// ObservedGeneration is the most recent generation observed by the controller.
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Conditions describe the current state of the TurtleAddon.
+	Conditions Conditions `json:"conditions,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Selected",type="integer",JSONPath=".status.selectedClusters"
+// +kubebuilder:printcolumn:name="Applied",type="integer",JSONPath=".status.appliedClusters"
+// +kubebuilder:printcolumn:name="Revision",type="integer",JSONPath=".status.revision",priority=1
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// TurtleAddon is the Schema for the turtleaddons API. It applies manifests
+// to the workload clusters of the Turtles it selects. Deleting a TurtleAddon,
+// or a Turtle no longer being selected, stops the manifests being applied
+// but leaves the objects already applied in the workload clusters.
+type TurtleAddon struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   TurtleAddonSpec   `json:"spec,omitempty"`
+	Status TurtleAddonStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// TurtleAddonList contains a list of TurtleAddon
+type TurtleAddonList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []TurtleAddon `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&TurtleAddon{}, &TurtleAddonList{})
+}
@@ -0,0 +1,52 @@
+// Copyright 2020 Alexander Eldeib
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS
+// OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+// CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+// TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package v1alpha1
+
+import (
+	"fmt"
+
+	"github.com/blang/semver"
+)
+
+// ValidateUpgrade returns an error unless moving a cluster from one
+// Kubernetes version to another is supported: downgrades are not, and
+// neither is skipping a minor version, which kubeadm refuses.
+func ValidateUpgrade(from, to string) error {
+	fromVersion, err := semver.ParseTolerant(from)
+	if err != nil {
+		return fmt.Errorf("invalid version %q: %w", from, err)
+	}
+
+	toVersion, err := semver.ParseTolerant(to)
+	if err != nil {
+		return fmt.Errorf("invalid version %q: %w", to, err)
+	}
+
+	switch {
+	case toVersion.LT(fromVersion):
+		return fmt.Errorf("downgrading from %s to %s is not supported", from, to)
+	case toVersion.Major != fromVersion.Major || toVersion.Minor > fromVersion.Minor+1:
+		return fmt.Errorf("upgrading from %s to %s skips a minor version", from, to)
+	}
+
+	return nil
+}
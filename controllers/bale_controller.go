@@ -26,12 +26,19 @@ import (
 	"fmt"
 
 	"github.com/go-logr/logr"
-	"github.com/pkg/errors"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	infrav1alpha1 "github.com/alexeldeib/bale/api/v1alpha1"
 )
@@ -39,14 +46,24 @@ import (
 // BaleReconciler reconciles a Bale object
 type BaleReconciler struct {
 	client.Client
-	Log    logr.Logger
-	Scheme *runtime.Scheme
+	Log      logr.Logger
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+
+	expectations *expectations
 }
 
 func (r *BaleReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	r.expectations = newExpectations()
+
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&infrav1alpha1.Bale{}).
 		Owns(&infrav1alpha1.Turtle{}).
+		Owns(&appsv1.ControllerRevision{}).
+		Watches(
+			&source.Kind{Type: &infrav1alpha1.Turtle{}},
+			&handler.EnqueueRequestsFromMapFunc{ToRequests: handler.ToRequestsFunc(r.turtleToBales)},
+		).
 		Complete(r)
 }
 
@@ -54,13 +71,18 @@ func (r *BaleReconciler) SetupWithManager(mgr ctrl.Manager) error {
 // +kubebuilder:rbac:groups=infra.alexeldeib.xyz,resources=bales/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=infra.alexeldeib.xyz,resources=turtles,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=infra.alexeldeib.xyz,resources=turtles/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=apps,resources=controllerrevisions,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
 
-func (r *BaleReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+func (r *BaleReconciler) Reconcile(req ctrl.Request) (_ ctrl.Result, reterr error) {
 	ctx := context.Background()
 	log := r.Log.WithValues("bale", req.NamespacedName)
 
 	var bale infrav1alpha1.Bale
 	if err := r.Get(ctx, req.NamespacedName, &bale); err != nil {
+		if apierrors.IsNotFound(err) {
+			r.expectations.Delete(req.NamespacedName.String())
+		}
 		log.Error(err, "unable to fetch")
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
@@ -72,38 +94,302 @@ func (r *BaleReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 
 	}
 
-	var armada infrav1alpha1.TurtleList
-	if err := r.List(context.Background(), &armada, client.MatchingLabelsSelector{Selector: selector}); err != nil {
-		log.Error(err, "unable to fetch bale list")
+	if !bale.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, nil
+	}
+
+	if bale.Spec.Paused {
+		log.Info("bale is paused, only updating status")
+		return ctrl.Result{}, r.reconcilePaused(ctx, &bale, selector)
+	}
+
+	// A rollback rewrites the template, so act on it before anything else
+	// and let the resulting update trigger the next reconcile. A rollback
+	// which fails doesn't stop the rest of the reconcile.
+	if r.rollback(ctx, log, &bale) {
+		return ctrl.Result{}, nil
+	}
+
+	revision, err := r.reconcileRevisions(ctx, log, &bale)
+	if err != nil {
+		log.Error(err, "unable to record revision")
 		return ctrl.Result{}, err
 	}
+	bale.Status.Revision = revision
 
-	diff := bale.Spec.Replicas - int32(len(armada.Items))
-	if diff <= 0 {
-		log.Info(fmt.Sprintf("found %d replicas, required %d, diff of %d. returning early", len(armada.Items), bale.Spec.Replicas, diff))
+	var turtles infrav1alpha1.TurtleList
+	if err := r.List(ctx, &turtles, client.InNamespace(bale.Namespace)); err != nil {
+		log.Error(err, "unable to fetch turtle list")
+		return ctrl.Result{}, err
 	}
 
-	for i := 0; int32(i) < diff; i++ {
-		name := "acecap-" + RandomLowercaseString(6)
-		turtle := new(infrav1alpha1.Turtle)
-		turtle.Namespace = bale.Namespace
-		turtle.Name = name
-		turtle.Spec = *bale.Spec.Template.DeepCopy()
-		turtle.Spec.ResourceGroup = name
-		want := turtle.DeepCopy()
+	// The cache may not have caught up with turtles we created or deleted
+	// moments ago. Counting replicas before it does would double up.
+	satisfied := r.expectations.Satisfied(req.NamespacedName.String(), turtles.Items)
 
-		_, err := controllerutil.CreateOrUpdate(ctx, r.Client, turtle, func() error {
-			if err := controllerutil.SetControllerReference(turtle, want, r.Scheme); err != nil {
-				return err
-			}
-			turtle = want
-			return nil
-		})
+	armada, err := r.claimTurtles(ctx, log, &bale, selector, turtles.Items)
+	if err != nil {
+		log.Error(err, "unable to claim turtles")
+		return ctrl.Result{}, err
+	}
+
+	// Turtles which are already being deleted no longer count towards the
+	// desired replicas, otherwise we would scale down twice.
+	active := filterActiveTurtles(armada)
 
+	ready := getTurtleReadiness(active)
+
+	hash := computeTemplateHash(&bale.Spec.Template.Spec)
+
+	defer func() {
+		if err := r.updateStatus(ctx, &bale, selector, active, ready, hash, reterr); err != nil && reterr == nil {
+			log.Error(err, "failed to update bale status")
+			reterr = err
+		}
+	}()
+
+	if !satisfied {
+		log.Info("waiting for cache to observe previous turtle creations and deletions")
+		return ctrl.Result{RequeueAfter: expectationsRequeueInterval}, nil
+	}
+
+	if isOrdinalNaming(&bale) {
+		return r.reconcileOrdinal(ctx, log, &bale, armada, ready, hash)
+	}
+
+	switch bale.Spec.Strategy.Type {
+	case infrav1alpha1.InPlaceBaleStrategyType:
+		return r.reconcileInPlace(ctx, log, &bale, active, ready, hash)
+	default:
+		return r.reconcileRollingUpdate(ctx, log, &bale, active, ready, hash)
+	}
+}
+
+// reconcilePaused reports the status of a paused bale without claiming,
+// creating, updating or deleting any turtle.
+func (r *BaleReconciler) reconcilePaused(ctx context.Context, bale *infrav1alpha1.Bale, selector labels.Selector) error {
+	var turtles infrav1alpha1.TurtleList
+	if err := r.List(ctx, &turtles, client.InNamespace(bale.Namespace)); err != nil {
+		return fmt.Errorf("failed to list turtles: %w", err)
+	}
+
+	active := filterActiveTurtles(ownedTurtles(bale, turtles.Items))
+	ready := getTurtleReadiness(active)
+
+	hash := computeTemplateHash(&bale.Spec.Template.Spec)
+	return r.updateStatus(ctx, bale, selector, active, ready, hash, nil)
+}
+
+// reconcileReplicas creates or deletes turtles until the bale has the desired
+// number of replicas, without regard to whether they are up to date.
+func (r *BaleReconciler) reconcileReplicas(
+	ctx context.Context,
+	log logr.Logger,
+	bale *infrav1alpha1.Bale,
+	turtles []infrav1alpha1.Turtle,
+	ready map[string]bool,
+	hash string,
+) error {
+	diff := bale.Spec.Replicas - int32(len(turtles))
+	switch {
+	case diff > 0:
+		log.Info(fmt.Sprintf("found %d replicas, required %d, creating %d", len(turtles), bale.Spec.Replicas, diff))
+		return r.scaleUp(ctx, bale, turtles, int(diff), hash)
+	case diff < 0:
+		log.Info(fmt.Sprintf("found %d replicas, required %d, deleting %d", len(turtles), bale.Spec.Replicas, -diff))
+		victims := getSpreadTurtlesToDelete(bale, turtles, int(-diff), ready)
+		return r.deleteTurtles(ctx, log, bale, victims)
+	default:
+		log.Info(fmt.Sprintf("found %d replicas, required %d. returning early", len(turtles), bale.Spec.Replicas))
+		return nil
+	}
+}
+
+// scaleUp creates count turtles, placing each in the location furthest below
+// its share given the turtles which already exist.
+func (r *BaleReconciler) scaleUp(
+	ctx context.Context,
+	bale *infrav1alpha1.Bale,
+	turtles []infrav1alpha1.Turtle,
+	count int,
+	hash string,
+) error {
+	counts := countByLocation(turtles)
+	for i := 0; i < count; i++ {
+		location := nextLocation(bale, counts)
+		counts[location]++
+
+		var err error
+		for attempt := 0; attempt < maxNameAttempts; attempt++ {
+			err = r.createTurtle(ctx, bale, randomTurtleName(bale), location, hash)
+			if !apierrors.IsAlreadyExists(err) {
+				break
+			}
+		}
 		if err != nil {
-			return ctrl.Result{}, errors.Wrap(err, "failed to create/update turtle: %w")
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *BaleReconciler) createTurtle(ctx context.Context, bale *infrav1alpha1.Bale, name, location, hash string) error {
+	if err := checkNameLength(bale, name); err != nil {
+		return err
+	}
+
+	turtle := new(infrav1alpha1.Turtle)
+	turtle.Namespace = bale.Namespace
+	turtle.Name = name
+	turtle.Labels = map[string]string{}
+	for key, value := range bale.Spec.Template.Metadata.Labels {
+		turtle.Labels[key] = value
+	}
+	turtle.Labels[infrav1alpha1.TemplateHashLabel] = hash
+	turtle.Annotations = bale.Spec.Template.Metadata.Annotations
+	turtle.Spec = *bale.Spec.Template.Spec.DeepCopy()
+	turtle.Spec.ResourceGroup = turtleResourceGroup(bale, name)
+	turtle.Spec.Location = location
+	setTurtleIdentity(bale, &turtle.Spec)
+
+	if err := controllerutil.SetControllerReference(bale, turtle, r.Scheme); err != nil {
+		return err
+	}
+
+	key := baleKey(bale)
+	r.expectations.ExpectCreations(key, name)
+	if err := r.Create(ctx, turtle); err != nil {
+		r.expectations.CreationObserved(key, name)
+		return fmt.Errorf("failed to create turtle %s: %w", name, err)
+	}
+
+	return nil
+}
+
+// setTurtleIdentity defaults the Azure subscription and credentials of a
+// turtle spec to the ones of its bale.
+func setTurtleIdentity(bale *infrav1alpha1.Bale, spec *infrav1alpha1.TurtleSpec) {
+	if spec.SubscriptionID == "" {
+		spec.SubscriptionID = bale.Spec.SubscriptionID
+	}
+	if spec.CredentialsRef == nil && bale.Spec.CredentialsRef != nil {
+		spec.CredentialsRef = bale.Spec.CredentialsRef.DeepCopy()
+	}
+}
+
+func (r *BaleReconciler) deleteTurtles(
+	ctx context.Context,
+	log logr.Logger,
+	bale *infrav1alpha1.Bale,
+	turtles []infrav1alpha1.Turtle,
+) error {
+	key := baleKey(bale)
+	for _, turtle := range turtles {
+		turtle := turtle
+		log.Info("deleting turtle", "turtle", turtle.Name)
+		r.expectations.ExpectDeletions(key, turtle.Name)
+		if err := r.Delete(ctx, &turtle); err != nil {
+			r.expectations.DeletionObserved(key, turtle.Name)
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return fmt.Errorf("failed to delete turtle %s: %w", turtle.Name, err)
 		}
 	}
+	return nil
+}
+
+// getTurtleReadiness reports whether each turtle is ready, keyed by name.
+func getTurtleReadiness(turtles []infrav1alpha1.Turtle) map[string]bool {
+	ready := make(map[string]bool, len(turtles))
+	for i := range turtles {
+		ready[turtles[i].Name] = isConditionTrue(turtles[i].Status.Conditions, infrav1alpha1.TurtleReadyCondition)
+	}
+	return ready
+}
 
-	return ctrl.Result{}, nil
+// updateStatus records the observed turtles on the bale. reconcileErr is the
+// error encountered while acting on those turtles, if any.
+func (r *BaleReconciler) updateStatus(
+	ctx context.Context,
+	bale *infrav1alpha1.Bale,
+	selector labels.Selector,
+	turtles []infrav1alpha1.Turtle,
+	ready map[string]bool,
+	hash string,
+	reconcileErr error,
+) error {
+	current, _ := splitByTemplateHash(turtles, hash)
+
+	status := &bale.Status
+	status.Replicas = int32(len(turtles))
+	status.ReadyReplicas = int32(countReady(turtles, ready))
+	status.UpdatedReplicas = int32(len(current))
+	status.Selector = selector.String()
+	status.ObservedGeneration = bale.Generation
+	status.Locations = locationReplicas(bale, turtles)
+
+	if _, maxUnavailable, err := resolveFenceposts(bale); err == nil {
+		if status.ReadyReplicas >= bale.Spec.Replicas-int32(maxUnavailable) {
+			setCondition(&status.Conditions, infrav1alpha1.BaleAvailableCondition, corev1.ConditionTrue, "MinimumReplicasAvailable", "")
+		} else {
+			setCondition(&status.Conditions, infrav1alpha1.BaleAvailableCondition, corev1.ConditionFalse, "MinimumReplicasUnavailable",
+				fmt.Sprintf("%d of %d turtles ready, %d may be unavailable", status.ReadyReplicas, bale.Spec.Replicas, maxUnavailable))
+		}
+	}
+
+	switch {
+	case bale.Spec.Paused:
+		setCondition(&status.Conditions, infrav1alpha1.BaleProgressingCondition, corev1.ConditionUnknown, "Paused", "")
+	case status.UpdatedReplicas < status.Replicas:
+		setCondition(&status.Conditions, infrav1alpha1.BaleProgressingCondition, corev1.ConditionTrue, "Updating",
+			fmt.Sprintf("%d of %d turtles up to date", status.UpdatedReplicas, status.Replicas))
+	case rebalances(bale) && len(misplacedTurtles(bale, current, ready)) > 0:
+		setCondition(&status.Conditions, infrav1alpha1.BaleProgressingCondition, corev1.ConditionTrue, "Rebalancing",
+			"moving turtles to locations below their share")
+	case status.Replicas != bale.Spec.Replicas:
+		setCondition(&status.Conditions, infrav1alpha1.BaleProgressingCondition, corev1.ConditionTrue, "Scaling",
+			fmt.Sprintf("scaling from %d to %d turtles", status.Replicas, bale.Spec.Replicas))
+	case status.ReadyReplicas < status.Replicas:
+		setCondition(&status.Conditions, infrav1alpha1.BaleProgressingCondition, corev1.ConditionTrue, "WaitingForReady",
+			fmt.Sprintf("%d of %d turtles ready", status.ReadyReplicas, status.Replicas))
+	default:
+		setCondition(&status.Conditions, infrav1alpha1.BaleProgressingCondition, corev1.ConditionFalse, "RolloutComplete", "")
+	}
+
+	if bale.Spec.Paused {
+		setCondition(&status.Conditions, infrav1alpha1.PausedCondition, corev1.ConditionTrue, "Paused", "turtles are not created, updated or deleted")
+	} else {
+		setCondition(&status.Conditions, infrav1alpha1.PausedCondition, corev1.ConditionFalse, "", "")
+	}
+
+	if reconcileErr != nil {
+		setCondition(&status.Conditions, infrav1alpha1.BaleReplicaFailureCondition, corev1.ConditionTrue, "ReconcileError", reconcileErr.Error())
+	} else {
+		setCondition(&status.Conditions, infrav1alpha1.BaleReplicaFailureCondition, corev1.ConditionFalse, "", "")
+	}
+
+	return r.Status().Update(ctx, bale)
+}
+
+func baleKey(bale *infrav1alpha1.Bale) string {
+	return types.NamespacedName{Namespace: bale.Namespace, Name: bale.Name}.String()
+}
+
+func deletionPolicy(bale *infrav1alpha1.Bale) infrav1alpha1.DeletionPolicy {
+	if bale.Spec.DeletionPolicy == "" {
+		return infrav1alpha1.DeletionPolicyUnhealthyFirst
+	}
+	return bale.Spec.DeletionPolicy
+}
+
+func filterActiveTurtles(turtles []infrav1alpha1.Turtle) []infrav1alpha1.Turtle {
+	active := make([]infrav1alpha1.Turtle, 0, len(turtles))
+	for i := range turtles {
+		if turtles[i].DeletionTimestamp.IsZero() {
+			active = append(active, turtles[i])
+		}
+	}
+	return active
 }
@@ -0,0 +1,129 @@
+// Copyright 2020 Alexander Eldeib
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS
+// OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+// CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+// TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	infrav1alpha1 "github.com/alexeldeib/bale/api/v1alpha1"
+)
+
+// computeRevisionHash returns a stable hash of an entire bale template. It
+// differs from the template hash on turtles in that it includes metadata, so
+// a rollback restores labels and annotations too.
+func computeRevisionHash(template *infrav1alpha1.TurtleTemplateSpec) string {
+	return computeJSONHash(template)
+}
+
+// history returns the revision history of a bale.
+func (r *BaleReconciler) history(bale *infrav1alpha1.Bale) *revisionHistory {
+	return &revisionHistory{client: r.Client, scheme: r.Scheme, owner: bale, label: infrav1alpha1.BaleNameLabel}
+}
+
+// reconcileRevisions records the current template of a bale as a
+// ControllerRevision and prunes revisions beyond the history limit. It
+// returns the revision number of the current template.
+func (r *BaleReconciler) reconcileRevisions(ctx context.Context, log logr.Logger, bale *infrav1alpha1.Bale) (int64, error) {
+	limit := defaultRevisionHistoryLimit
+	if bale.Spec.RevisionHistoryLimit != nil {
+		limit = int(*bale.Spec.RevisionHistoryLimit)
+	}
+
+	name := fmt.Sprintf("%s-%s", bale.Name, computeRevisionHash(&bale.Spec.Template))
+	current, err := r.history(bale).record(ctx, log, name, bale.Spec.Template, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	return current.Revision, nil
+}
+
+// rollback replaces the template of a bale with a previous revision as
+// requested by RollbackToAnnotation. It reports whether the bale was
+// updated, in which case the update triggers the next reconcile. A rollback
+// which can't be applied is reported in an event, and the annotation is
+// cleared on its own so the bale isn't stuck retrying it.
+func (r *BaleReconciler) rollback(ctx context.Context, log logr.Logger, bale *infrav1alpha1.Bale) bool {
+	value, ok := bale.Annotations[infrav1alpha1.RollbackToAnnotation]
+	if !ok {
+		return false
+	}
+
+	target, template, err := r.rollbackTarget(ctx, bale, value)
+	if err == nil {
+		updated := bale.DeepCopy()
+		updated.Spec.Template = *template
+		delete(updated.Annotations, infrav1alpha1.RollbackToAnnotation)
+		if err = r.Update(ctx, updated); err == nil {
+			*bale = *updated
+			log.Info("rolled back bale", "revision", target.Revision)
+			r.Recorder.Eventf(bale, corev1.EventTypeNormal, "RolledBack", "Rolled back to revision %d", target.Revision)
+			return true
+		}
+		err = fmt.Errorf("failed to update bale: %w", err)
+	}
+
+	log.Error(err, "unable to roll back", "rollbackTo", value)
+	r.Recorder.Eventf(bale, corev1.EventTypeWarning, "RollbackFailed", "Unable to roll back to revision %q: %s", value, err)
+
+	patch := client.MergeFrom(bale.DeepCopy())
+	delete(bale.Annotations, infrav1alpha1.RollbackToAnnotation)
+	if err := r.Patch(ctx, bale, patch); err != nil {
+		log.Error(err, "unable to clear rollback annotation")
+	}
+	return false
+}
+
+// rollbackTarget returns the revision a bale is rolled back to, and its
+// template. Turtles can't be downgraded, so rolling back to a revision is
+// held to the same version rules as any other change to the template.
+func (r *BaleReconciler) rollbackTarget(
+	ctx context.Context,
+	bale *infrav1alpha1.Bale,
+	value string,
+) (*appsv1.ControllerRevision, *infrav1alpha1.TurtleTemplateSpec, error) {
+	target, err := r.history(bale).find(ctx, value)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var template infrav1alpha1.TurtleTemplateSpec
+	if err := json.Unmarshal(target.Data.Raw, &template); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode revision %s: %w", target.Name, err)
+	}
+
+	from, to := bale.Spec.Template.Spec.Version, template.Spec.Version
+	if from != to && from != "" {
+		if err := infrav1alpha1.ValidateUpgrade(from, to); err != nil {
+			return nil, nil, fmt.Errorf("revision %d can't be rolled back to: %w", target.Revision, err)
+		}
+	}
+
+	return target, &template, nil
+}
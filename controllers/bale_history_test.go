@@ -0,0 +1,142 @@
+// Copyright 2020 Alexander Eldeib
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS
+// OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+// CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+// TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	infrav1alpha1 "github.com/alexeldeib/bale/api/v1alpha1"
+)
+
+func TestBaleRollback(t *testing.T) {
+	westus := infrav1alpha1.TurtleSpec{Location: "westus2", Version: "1.18.3"}
+	eastus := infrav1alpha1.TurtleSpec{Location: "eastus", Version: "1.18.3"}
+	upgraded := infrav1alpha1.TurtleSpec{Location: "eastus", Version: "1.19.1"}
+
+	cases := []struct {
+		name     string
+		history  []infrav1alpha1.TurtleSpec
+		value    string
+		conflict bool
+		want     infrav1alpha1.TurtleSpec
+		event    string
+	}{
+		{
+			name:    "previous revision",
+			history: []infrav1alpha1.TurtleSpec{westus, eastus},
+			value:   "0",
+			want:    westus,
+			event:   "RolledBack",
+		},
+		{
+			name:    "missing revision",
+			history: []infrav1alpha1.TurtleSpec{westus, eastus},
+			value:   "7",
+			want:    eastus,
+			event:   "RollbackFailed",
+		},
+		{
+			name:    "across a version bump",
+			history: []infrav1alpha1.TurtleSpec{eastus, upgraded},
+			value:   "0",
+			want:    upgraded,
+			event:   "RollbackFailed",
+		},
+		{
+			name:    "to a newer version",
+			history: []infrav1alpha1.TurtleSpec{upgraded, westus},
+			value:   "1",
+			want:    upgraded,
+			event:   "RolledBack",
+		},
+		{
+			name:     "conflicting update",
+			history:  []infrav1alpha1.TurtleSpec{westus, eastus},
+			value:    "1",
+			conflict: true,
+			want:     eastus,
+			event:    "RollbackFailed",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+			ctx := context.Background()
+
+			scheme := runtime.NewScheme()
+			g.Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+			g.Expect(infrav1alpha1.AddToScheme(scheme)).To(Succeed())
+
+			bale := &infrav1alpha1.Bale{
+				ObjectMeta: metav1.ObjectMeta{Name: "bale", Namespace: "default", UID: "bale-uid"},
+			}
+			recorder := record.NewFakeRecorder(10)
+			r := &BaleReconciler{
+				Client:   fake.NewFakeClientWithScheme(scheme, bale),
+				Log:      ctrl.Log,
+				Scheme:   scheme,
+				Recorder: recorder,
+			}
+
+			key := types.NamespacedName{Namespace: bale.Namespace, Name: bale.Name}
+			g.Expect(r.Get(ctx, key, bale)).To(Succeed())
+			for _, spec := range tc.history {
+				bale.Spec.Template.Spec = spec
+				_, err := r.reconcileRevisions(ctx, r.Log, bale)
+				g.Expect(err).NotTo(HaveOccurred())
+			}
+			bale.Annotations = map[string]string{infrav1alpha1.RollbackToAnnotation: tc.value}
+			g.Expect(r.Update(ctx, bale)).To(Succeed())
+
+			if tc.conflict {
+				stored := &infrav1alpha1.Bale{}
+				g.Expect(r.Get(ctx, key, stored)).To(Succeed())
+				stored.Labels = map[string]string{"changed": "true"}
+				g.Expect(r.Update(ctx, stored)).To(Succeed())
+			}
+
+			g.Expect(r.rollback(ctx, r.Log, bale)).To(Equal(tc.event == "RolledBack"))
+
+			stored := &infrav1alpha1.Bale{}
+			g.Expect(r.Get(ctx, key, stored)).To(Succeed())
+			g.Expect(stored.Annotations).NotTo(HaveKey(infrav1alpha1.RollbackToAnnotation), "the annotation is cleared")
+			g.Expect(stored.Spec.Template.Spec).To(Equal(tc.want))
+
+			g.Expect(recorder.Events).To(Receive(WithTransform(func(event string) string {
+				return strings.Fields(event)[1]
+			}, Equal(tc.event))))
+		})
+	}
+}
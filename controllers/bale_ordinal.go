@@ -0,0 +1,115 @@
+// Copyright 2020 Alexander Eldeib
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS
+// OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+// CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+// TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package controllers
+
+import (
+	"context"
+	"sort"
+
+	"github.com/go-logr/logr"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	infrav1alpha1 "github.com/alexeldeib/bale/api/v1alpha1"
+)
+
+// reconcileOrdinal manages turtles named <prefix>-<ordinal>, like a
+// StatefulSet with ordered pod management. Turtles are created in ascending
+// order, each only once its predecessors are ready, and deleted in
+// descending order one at a time. Out of date turtles are replaced from the
+// highest ordinal down, or patched when the strategy is InPlace.
+//
+// turtles must include claimed turtles which are being deleted, since their
+// names can't be reused until they are gone.
+func (r *BaleReconciler) reconcileOrdinal(
+	ctx context.Context,
+	log logr.Logger,
+	bale *infrav1alpha1.Bale,
+	turtles []infrav1alpha1.Turtle,
+	ready map[string]bool,
+	hash string,
+) (ctrl.Result, error) {
+	requeue := ctrl.Result{RequeueAfter: rolloutRequeueInterval}
+	replicas := int(bale.Spec.Replicas)
+
+	byOrdinal := map[int]*infrav1alpha1.Turtle{}
+	var condemned []infrav1alpha1.Turtle
+	for i := range turtles {
+		turtle := &turtles[i]
+		if !turtle.DeletionTimestamp.IsZero() {
+			log.Info("waiting for turtle to be deleted", "turtle", turtle.Name)
+			return requeue, nil
+		}
+		if ordinal, ok := parseOrdinal(bale, turtle.Name); ok && ordinal < replicas {
+			byOrdinal[ordinal] = turtle
+		} else {
+			condemned = append(condemned, *turtle)
+		}
+	}
+
+	for ordinal := 0; ordinal < replicas; ordinal++ {
+		turtle, ok := byOrdinal[ordinal]
+		if !ok {
+			placed := make([]infrav1alpha1.Turtle, 0, len(byOrdinal))
+			for _, turtle := range byOrdinal {
+				placed = append(placed, *turtle)
+			}
+			name := ordinalTurtleName(bale, ordinal)
+			log.Info("creating turtle", "turtle", name)
+			return requeue, r.createTurtle(ctx, bale, name, nextLocation(bale, countByLocation(placed)), hash)
+		}
+		if !ready[turtle.Name] {
+			log.Info("waiting for turtle to become ready", "turtle", turtle.Name)
+			return requeue, nil
+		}
+	}
+
+	if len(condemned) > 0 {
+		// Turtles without an ordinal go first, then the highest ordinals.
+		sort.SliceStable(condemned, func(i, j int) bool {
+			oi, iok := parseOrdinal(bale, condemned[i].Name)
+			oj, jok := parseOrdinal(bale, condemned[j].Name)
+			if iok != jok {
+				return !iok
+			}
+			return oi > oj
+		})
+		return requeue, r.deleteTurtles(ctx, log, bale, condemned[:1])
+	}
+
+	if bale.Spec.Strategy.Type == infrav1alpha1.InPlaceBaleStrategyType {
+		active := make([]infrav1alpha1.Turtle, 0, replicas)
+		for ordinal := 0; ordinal < replicas; ordinal++ {
+			active = append(active, *byOrdinal[ordinal])
+		}
+		return r.reconcileInPlace(ctx, log, bale, active, ready, hash)
+	}
+
+	for ordinal := replicas - 1; ordinal >= 0; ordinal-- {
+		turtle := byOrdinal[ordinal]
+		if turtle.Labels[infrav1alpha1.TemplateHashLabel] != hash {
+			log.Info("replacing out of date turtle", "turtle", turtle.Name)
+			return requeue, r.deleteTurtles(ctx, log, bale, []infrav1alpha1.Turtle{*turtle})
+		}
+	}
+
+	return ctrl.Result{}, nil
+}
@@ -0,0 +1,342 @@
+// Copyright 2020 Alexander Eldeib
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS
+// OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+// CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+// TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"time"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/utils/integer"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	infrav1alpha1 "github.com/alexeldeib/bale/api/v1alpha1"
+)
+
+// rolloutRequeueInterval is how often a bale is revisited while turtles are
+// being updated. Turtle status changes trigger a reconcile on their own, so
+// this is only a safety net.
+const rolloutRequeueInterval = 30 * time.Second
+
+// computeTemplateHash returns a stable, label safe hash of a bale template
+// spec. Template metadata is deliberately excluded so that changing labels
+// or annotations doesn't replace every turtle, and so is paused, which only
+// applies to turtles as they are created. The hash covers the JSON of the
+// spec rather than the Go struct, so fields added to TurtleSpec don't
+// change the hash of turtles which don't set them and an upgrade of the
+// controller doesn't replace every turtle.
+func computeTemplateHash(template *infrav1alpha1.TurtleSpec) string {
+	spec := *template
+	spec.Paused = false
+	return computeJSONHash(spec)
+}
+
+// computeJSONHash returns a stable, label and name safe hash of the JSON of
+// an object, leaving out empty values.
+func computeJSONHash(obj interface{}) string {
+	// API types always marshal.
+	data, _ := json.Marshal(obj)
+	var value interface{}
+	_ = json.Unmarshal(data, &value)
+	data, _ = json.Marshal(dropEmpty(value))
+
+	hasher := fnv.New32a()
+	_, _ = hasher.Write(data)
+	return rand.SafeEncodeString(fmt.Sprint(hasher.Sum32()))
+}
+
+// dropEmpty returns a decoded JSON value without empty strings, zeros,
+// false, nulls and empty objects or arrays, which are how unset fields
+// encode when they lack omitempty. Elements of arrays are kept in place.
+func dropEmpty(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		out := map[string]interface{}{}
+		for key, field := range v {
+			if field = dropEmpty(field); field != nil {
+				out[key] = field
+			}
+		}
+		if len(out) == 0 {
+			return nil
+		}
+		return out
+	case []interface{}:
+		if len(v) == 0 {
+			return nil
+		}
+		out := make([]interface{}, len(v))
+		for i := range v {
+			out[i] = dropEmpty(v[i])
+		}
+		return out
+	case string:
+		if v == "" {
+			return nil
+		}
+	case float64:
+		if v == 0 {
+			return nil
+		}
+	case bool:
+		if !v {
+			return nil
+		}
+	}
+	return value
+}
+
+// reconcileRollingUpdate replaces out of date turtles with new ones. New
+// turtles are surged up to maxSurge above the desired replicas, and old ones
+// are removed as long as at least replicas - maxUnavailable remain ready.
+// Turtles in locations too far above their share of a spread are replaced
+// the same way.
+func (r *BaleReconciler) reconcileRollingUpdate(
+	ctx context.Context,
+	log logr.Logger,
+	bale *infrav1alpha1.Bale,
+	turtles []infrav1alpha1.Turtle,
+	ready map[string]bool,
+	hash string,
+) (ctrl.Result, error) {
+	current, old := splitOutOfDate(bale, turtles, ready, hash)
+	if len(old) == 0 {
+		return ctrl.Result{}, r.reconcileReplicas(ctx, log, bale, turtles, ready, hash)
+	}
+
+	replicas := int(bale.Spec.Replicas)
+	maxSurge, maxUnavailable, err := resolveFenceposts(bale)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	log.Info(fmt.Sprintf("rolling update in progress, %d up to date and %d out of date turtles", len(current), len(old)))
+
+	toCreate := integer.IntMin(replicas+maxSurge-len(turtles), replicas-len(current))
+	if toCreate > 0 {
+		if err := r.scaleUp(ctx, bale, current, toCreate, hash); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	var healthyOld, unhealthyOld []infrav1alpha1.Turtle
+	for i := range old {
+		if ready[old[i].Name] {
+			healthyOld = append(healthyOld, old[i])
+		} else {
+			unhealthyOld = append(unhealthyOld, old[i])
+		}
+	}
+
+	minAvailable := replicas - maxUnavailable
+	available := countReady(turtles, ready)
+	unavailableCurrent := len(current) - countReady(current, ready)
+
+	// Unready turtles don't count towards availability, so they can be
+	// cleaned up first as long as enough turtles remain to reach minAvailable
+	// once the up to date ones become ready.
+	cleanup := integer.IntMin(len(unhealthyOld), len(turtles)-minAvailable-unavailableCurrent)
+	scaleDown := integer.IntMin(len(healthyOld), available-minAvailable)
+
+	policy := deletionPolicy(bale)
+	victims := getTurtlesToDelete(unhealthyOld, cleanup, policy, ready)
+	victims = append(victims, getTurtlesToDelete(healthyOld, scaleDown, policy, ready)...)
+	if err := r.deleteTurtles(ctx, log, bale, victims); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	return ctrl.Result{RequeueAfter: rolloutRequeueInterval}, nil
+}
+
+// reconcileInPlace patches out of date turtles with the current template,
+// updating at most maxUnavailable ready turtles at a time.
+func (r *BaleReconciler) reconcileInPlace(
+	ctx context.Context,
+	log logr.Logger,
+	bale *infrav1alpha1.Bale,
+	turtles []infrav1alpha1.Turtle,
+	ready map[string]bool,
+	hash string,
+) (ctrl.Result, error) {
+	if int(bale.Spec.Replicas) != len(turtles) {
+		if err := r.reconcileReplicas(ctx, log, bale, turtles, ready, hash); err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{RequeueAfter: rolloutRequeueInterval}, nil
+	}
+
+	_, old := splitByTemplateHash(turtles, hash)
+	if len(old) == 0 {
+		return ctrl.Result{}, nil
+	}
+
+	_, maxUnavailable, err := resolveFenceposts(bale)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	log.Info(fmt.Sprintf("in place update in progress, %d out of date turtles", len(old)))
+
+	budget := maxUnavailable - (len(turtles) - countReady(turtles, ready))
+	for i := range old {
+		turtle := &old[i]
+		if ready[turtle.Name] {
+			if budget <= 0 {
+				continue
+			}
+			budget--
+		}
+		// The next reconcile creates the replacement from the new template.
+		if needsReplacement(bale, turtle) {
+			log.Info("replacing turtle", "turtle", turtle.Name, "location", turtle.Spec.Location, "cni", cniPlugin(turtle))
+			if err := r.deleteTurtles(ctx, log, bale, []infrav1alpha1.Turtle{*turtle}); err != nil {
+				return ctrl.Result{}, err
+			}
+			continue
+		}
+		log.Info("updating turtle in place", "turtle", turtle.Name)
+		if err := r.updateTurtle(ctx, bale, turtle, hash); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	return ctrl.Result{RequeueAfter: rolloutRequeueInterval}, nil
+}
+
+// needsReplacement reports whether a turtle can't be updated in place to
+// match the bale template, because its cluster is in another location or
+// runs another CNI plugin. Spread turtles keep the location they were
+// placed in.
+func needsReplacement(bale *infrav1alpha1.Bale, turtle *infrav1alpha1.Turtle) bool {
+	if cniPlugin(turtle) != templateCNIPlugin(bale) {
+		return true
+	}
+	return bale.Spec.Spread == nil && turtle.Spec.Location != bale.Spec.Template.Spec.Location
+}
+
+// templateCNIPlugin returns the CNI plugin of the turtles of a bale.
+func templateCNIPlugin(bale *infrav1alpha1.Bale) infrav1alpha1.CNIPlugin {
+	return cniPlugin(&infrav1alpha1.Turtle{Spec: bale.Spec.Template.Spec})
+}
+
+// updateTurtle patches the spec of an existing turtle to match the bale template.
+func (r *BaleReconciler) updateTurtle(ctx context.Context, bale *infrav1alpha1.Bale, turtle *infrav1alpha1.Turtle, hash string) error {
+	patch := client.MergeFrom(turtle.DeepCopy())
+
+	// The resource group, subscription and location locate existing Azure
+	// resources, so they never change in place. Whether a turtle is paused
+	// is left to whoever paused it.
+	resourceGroup := turtle.Spec.ResourceGroup
+	subscriptionID := turtle.Spec.SubscriptionID
+	location := turtle.Spec.Location
+	paused := turtle.Spec.Paused
+	turtle.Spec = *bale.Spec.Template.Spec.DeepCopy()
+	turtle.Spec.ResourceGroup = resourceGroup
+	turtle.Spec.SubscriptionID = subscriptionID
+	turtle.Spec.Paused = paused
+	turtle.Spec.Location = location
+	setTurtleIdentity(bale, &turtle.Spec)
+
+	if turtle.Labels == nil {
+		turtle.Labels = map[string]string{}
+	}
+	for key, value := range bale.Spec.Template.Metadata.Labels {
+		turtle.Labels[key] = value
+	}
+	turtle.Labels[infrav1alpha1.TemplateHashLabel] = hash
+
+	if len(bale.Spec.Template.Metadata.Annotations) > 0 && turtle.Annotations == nil {
+		turtle.Annotations = map[string]string{}
+	}
+	for key, value := range bale.Spec.Template.Metadata.Annotations {
+		turtle.Annotations[key] = value
+	}
+
+	if err := r.Patch(ctx, turtle, patch); err != nil {
+		return fmt.Errorf("failed to patch turtle %s: %w", turtle.Name, err)
+	}
+	return nil
+}
+
+// resolveFenceposts returns the absolute values of maxSurge and maxUnavailable
+// for a bale. Like a Deployment, both may not be zero at once.
+func resolveFenceposts(bale *infrav1alpha1.Bale) (maxSurge, maxUnavailable int, err error) {
+	surge := intstr.FromInt(1)
+	unavailable := intstr.FromInt(0)
+	if rollingUpdate := bale.Spec.Strategy.RollingUpdate; rollingUpdate != nil {
+		if rollingUpdate.MaxSurge != nil {
+			surge = *rollingUpdate.MaxSurge
+		}
+		if rollingUpdate.MaxUnavailable != nil {
+			unavailable = *rollingUpdate.MaxUnavailable
+		}
+	}
+
+	replicas := int(bale.Spec.Replicas)
+	maxSurge, err = intstr.GetValueFromIntOrPercent(&surge, replicas, true)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid maxSurge: %w", err)
+	}
+	maxUnavailable, err = intstr.GetValueFromIntOrPercent(&unavailable, replicas, false)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid maxUnavailable: %w", err)
+	}
+
+	if bale.Spec.Strategy.Type == infrav1alpha1.InPlaceBaleStrategyType {
+		maxSurge = 0
+	}
+
+	if maxSurge == 0 && maxUnavailable == 0 {
+		maxUnavailable = 1
+	}
+
+	return maxSurge, maxUnavailable, nil
+}
+
+// splitByTemplateHash partitions turtles into those created from the current
+// template and those which are out of date.
+func splitByTemplateHash(turtles []infrav1alpha1.Turtle, hash string) (current, old []infrav1alpha1.Turtle) {
+	for i := range turtles {
+		if turtles[i].Labels[infrav1alpha1.TemplateHashLabel] == hash {
+			current = append(current, turtles[i])
+		} else {
+			old = append(old, turtles[i])
+		}
+	}
+	return current, old
+}
+
+func countReady(turtles []infrav1alpha1.Turtle, ready map[string]bool) int {
+	count := 0
+	for i := range turtles {
+		if ready[turtles[i].Name] {
+			count++
+		}
+	}
+	return count
+}
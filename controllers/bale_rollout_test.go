@@ -0,0 +1,86 @@
+// Copyright 2020 Alexander Eldeib
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS
+// OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+// CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+// TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package controllers
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	infrav1alpha1 "github.com/alexeldeib/bale/api/v1alpha1"
+)
+
+func TestComputeTemplateHash(t *testing.T) {
+	g := NewWithT(t)
+
+	spec := infrav1alpha1.TurtleSpec{
+		Location:   "westus2",
+		Version:    "1.18.3",
+		Hatchlings: []infrav1alpha1.HatchlingSpec{{Name: "default", VMSize: "Standard_D2s_v3"}},
+	}
+	hash := computeTemplateHash(&spec)
+
+	paused := spec
+	paused.Paused = true
+	g.Expect(computeTemplateHash(&paused)).To(Equal(hash), "paused only applies to new turtles")
+
+	upgraded := spec
+	upgraded.Version = "1.18.4"
+	g.Expect(computeTemplateHash(&upgraded)).NotTo(Equal(hash))
+
+	// A newer TurtleSpec with fields the template doesn't set, with and
+	// without omitempty, must hash the same.
+	type nested struct {
+		Mode string `json:"mode"`
+	}
+	type newerSpec struct {
+		infrav1alpha1.TurtleSpec `json:",inline"`
+		Added                    string   `json:"added"`
+		AddedStruct              nested   `json:"addedStruct"`
+		AddedList                []string `json:"addedList"`
+		AddedFlag                bool     `json:"addedFlag,omitempty"`
+	}
+	g.Expect(computeJSONHash(newerSpec{TurtleSpec: spec})).To(Equal(hash))
+	g.Expect(computeJSONHash(newerSpec{TurtleSpec: spec, Added: "set"})).NotTo(Equal(hash))
+}
+
+func TestNeedsReplacement(t *testing.T) {
+	g := NewWithT(t)
+
+	bale := &infrav1alpha1.Bale{}
+	bale.Spec.Template.Spec.Location = "eastus"
+	turtle := &infrav1alpha1.Turtle{Spec: infrav1alpha1.TurtleSpec{Location: "westus2"}}
+	g.Expect(needsReplacement(bale, turtle)).To(BeTrue(), "clusters can't move between regions")
+
+	turtle.Spec.Location = "eastus"
+	g.Expect(needsReplacement(bale, turtle)).To(BeFalse())
+
+	bale.Spec.Spread = &infrav1alpha1.LocationSpread{Locations: []infrav1alpha1.WeightedLocation{{Name: "westus2"}}}
+	turtle.Spec.Location = "westus2"
+	g.Expect(needsReplacement(bale, turtle)).To(BeFalse(), "spread turtles keep their location")
+
+	bale.Spec.Template.Spec.Network.CNI = infrav1alpha1.CNICalico
+	g.Expect(needsReplacement(bale, turtle)).To(BeFalse(), "calico is the default plugin")
+
+	bale.Spec.Template.Spec.Network.CNI = infrav1alpha1.CNIFlannel
+	g.Expect(needsReplacement(bale, turtle)).To(BeTrue(), "plugins can't be switched in place")
+}
@@ -0,0 +1,285 @@
+// Copyright 2020 Alexander Eldeib
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS
+// OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+// CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+// TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package controllers
+
+import (
+	"sort"
+
+	infrav1alpha1 "github.com/alexeldeib/bale/api/v1alpha1"
+)
+
+// locationTargets returns the share of replicas of each location in a
+// spread, proportional to its weight. Remainders go to the locations with the
+// largest fractional share, earlier locations first on ties. Negative
+// replicas count as none.
+func locationTargets(spread *infrav1alpha1.LocationSpread, replicas int) map[string]int {
+	targets := make(map[string]int, len(spread.Locations))
+	if replicas < 0 {
+		replicas = 0
+	}
+
+	var total int
+	for _, location := range spread.Locations {
+		total += int(location.Weight)
+	}
+	if total == 0 {
+		return targets
+	}
+
+	remainders := make([]int, len(spread.Locations))
+	assigned := 0
+	for i, location := range spread.Locations {
+		share := replicas * int(location.Weight)
+		targets[location.Name] = share / total
+		remainders[i] = share % total
+		assigned += share / total
+	}
+
+	order := make([]int, len(spread.Locations))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return remainders[order[i]] > remainders[order[j]]
+	})
+	for _, i := range order[:replicas-assigned] {
+		targets[spread.Locations[i].Name]++
+	}
+
+	return targets
+}
+
+// countByLocation returns the number of turtles in each location.
+func countByLocation(turtles []infrav1alpha1.Turtle) map[string]int {
+	counts := map[string]int{}
+	for i := range turtles {
+		counts[turtles[i].Spec.Location]++
+	}
+	return counts
+}
+
+// nextLocation returns the location of a new turtle given the number of
+// turtles already in each location: the one furthest below its share, or
+// the template location if the bale isn't spread.
+func nextLocation(bale *infrav1alpha1.Bale, counts map[string]int) string {
+	spread := bale.Spec.Spread
+	if spread == nil {
+		return bale.Spec.Template.Spec.Location
+	}
+
+	targets := locationTargets(spread, int(bale.Spec.Replicas))
+	best, bestDeficit := "", 0
+	for _, location := range spread.Locations {
+		if location.Weight == 0 {
+			continue
+		}
+		deficit := targets[location.Name] - counts[location.Name]
+		if best == "" || deficit > bestDeficit {
+			best, bestDeficit = location.Name, deficit
+		}
+	}
+
+	if best == "" {
+		return bale.Spec.Template.Spec.Location
+	}
+	return best
+}
+
+// getSpreadTurtlesToDelete returns n turtles to remove from a bale. Each one
+// is taken from the location furthest above its share, picked within that
+// location by the deletion policy. Turtles in unlisted or drained locations
+// go first.
+func getSpreadTurtlesToDelete(
+	bale *infrav1alpha1.Bale,
+	turtles []infrav1alpha1.Turtle,
+	n int,
+	ready map[string]bool,
+) []infrav1alpha1.Turtle {
+	policy := deletionPolicy(bale)
+	if bale.Spec.Spread == nil {
+		return getTurtlesToDelete(turtles, n, policy, ready)
+	}
+
+	targets := locationTargets(bale.Spec.Spread, int(bale.Spec.Replicas))
+	wanted := map[string]bool{}
+	for _, location := range bale.Spec.Spread.Locations {
+		wanted[location.Name] = location.Weight > 0
+	}
+
+	byLocation := map[string][]infrav1alpha1.Turtle{}
+	var locations []string
+	for i := range turtles {
+		location := turtles[i].Spec.Location
+		if _, ok := byLocation[location]; !ok {
+			locations = append(locations, location)
+		}
+		byLocation[location] = append(byLocation[location], turtles[i])
+	}
+	sort.Strings(locations)
+
+	var victims []infrav1alpha1.Turtle
+	for len(victims) < n {
+		worst, worstSurplus := "", 0
+		for _, location := range locations {
+			if len(byLocation[location]) == 0 {
+				continue
+			}
+			// Locations which shouldn't have turtles at all are emptied first.
+			surplus := len(byLocation[location]) - targets[location]
+			if !wanted[location] {
+				surplus += len(turtles)
+			}
+			if worst == "" || surplus > worstSurplus {
+				worst, worstSurplus = location, surplus
+			}
+		}
+		if worst == "" {
+			break
+		}
+
+		victim := getTurtlesToDelete(byLocation[worst], 1, policy, ready)[0]
+		victims = append(victims, victim)
+
+		remaining := byLocation[worst][:0]
+		for _, turtle := range byLocation[worst] {
+			if turtle.Name != victim.Name {
+				remaining = append(remaining, turtle)
+			}
+		}
+		byLocation[worst] = remaining
+	}
+
+	return victims
+}
+
+// misplacedTurtles returns the names of turtles which should move to another
+// location: those in locations which are unlisted or have no weight, and the
+// surplus of locations more than maxSkew above their share.
+func misplacedTurtles(
+	bale *infrav1alpha1.Bale,
+	turtles []infrav1alpha1.Turtle,
+	ready map[string]bool,
+) map[string]bool {
+	misplaced := map[string]bool{}
+	spread := bale.Spec.Spread
+	if spread == nil {
+		return misplaced
+	}
+
+	maxSkew := int(spread.MaxSkew)
+	if maxSkew < 1 {
+		maxSkew = 1
+	}
+
+	weights := make(map[string]int32, len(spread.Locations))
+	for _, location := range spread.Locations {
+		weights[location.Name] = location.Weight
+	}
+	targets := locationTargets(spread, int(bale.Spec.Replicas))
+
+	byLocation := map[string][]infrav1alpha1.Turtle{}
+	for i := range turtles {
+		byLocation[turtles[i].Spec.Location] = append(byLocation[turtles[i].Spec.Location], turtles[i])
+	}
+
+	for location, inLocation := range byLocation {
+		surplus := len(inLocation) - targets[location]
+		if weights[location] > 0 && surplus <= maxSkew {
+			continue
+		}
+		for _, turtle := range getTurtlesToDelete(inLocation, surplus, deletionPolicy(bale), ready) {
+			misplaced[turtle.Name] = true
+		}
+	}
+
+	return misplaced
+}
+
+// rebalances reports whether a bale moves turtles between locations. Only
+// surging rolling updates of randomly named turtles can do so.
+func rebalances(bale *infrav1alpha1.Bale) bool {
+	return bale.Spec.Spread != nil &&
+		bale.Spec.Strategy.Type != infrav1alpha1.InPlaceBaleStrategyType &&
+		!isOrdinalNaming(bale)
+}
+
+// splitOutOfDate partitions turtles into those which are up to date and
+// correctly placed, and those which must be replaced.
+func splitOutOfDate(
+	bale *infrav1alpha1.Bale,
+	turtles []infrav1alpha1.Turtle,
+	ready map[string]bool,
+	hash string,
+) (current, old []infrav1alpha1.Turtle) {
+	current, old = splitByTemplateHash(turtles, hash)
+	misplaced := misplacedTurtles(bale, current, ready)
+	if len(misplaced) == 0 {
+		return current, old
+	}
+
+	placed := current[:0]
+	for i := range current {
+		if misplaced[current[i].Name] {
+			old = append(old, current[i])
+		} else {
+			placed = append(placed, current[i])
+		}
+	}
+	return placed, old
+}
+
+// locationReplicas summarizes the placement of turtles for the bale status,
+// listing the desired locations first.
+func locationReplicas(bale *infrav1alpha1.Bale, turtles []infrav1alpha1.Turtle) []infrav1alpha1.LocationReplicas {
+	counts := countByLocation(turtles)
+
+	var names []string
+	var targets map[string]int
+	if spread := bale.Spec.Spread; spread != nil {
+		targets = locationTargets(spread, int(bale.Spec.Replicas))
+		for _, location := range spread.Locations {
+			names = append(names, location.Name)
+		}
+	} else {
+		location := bale.Spec.Template.Spec.Location
+		targets = map[string]int{location: int(bale.Spec.Replicas)}
+		names = append(names, location)
+	}
+
+	var unlisted []string
+	for name := range counts {
+		if _, ok := targets[name]; !ok {
+			unlisted = append(unlisted, name)
+		}
+	}
+	sort.Strings(unlisted)
+
+	var summary []infrav1alpha1.LocationReplicas
+	for _, name := range append(names, unlisted...) {
+		summary = append(summary, infrav1alpha1.LocationReplicas{
+			Name:            name,
+			Replicas:        int32(counts[name]),
+			DesiredReplicas: int32(targets[name]),
+		})
+	}
+	return summary
+}
@@ -0,0 +1,113 @@
+// Copyright 2020 Alexander Eldeib
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS
+// OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+// CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+// TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package controllers
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	infrav1alpha1 "github.com/alexeldeib/bale/api/v1alpha1"
+)
+
+func TestLocationTargets(t *testing.T) {
+	spread := &infrav1alpha1.LocationSpread{
+		Locations: []infrav1alpha1.WeightedLocation{
+			{Name: "westus2", Weight: 2},
+			{Name: "eastus", Weight: 1},
+			{Name: "northeurope", Weight: 1},
+			{Name: "drained", Weight: 0},
+		},
+	}
+
+	cases := map[string]struct {
+		replicas int
+		want     map[string]int
+	}{
+		"even": {
+			replicas: 4,
+			want:     map[string]int{"westus2": 2, "eastus": 1, "northeurope": 1, "drained": 0},
+		},
+		"remainder to largest fraction": {
+			replicas: 5,
+			want:     map[string]int{"westus2": 3, "eastus": 1, "northeurope": 1, "drained": 0},
+		},
+		"remainder to earlier locations on ties": {
+			replicas: 2,
+			want:     map[string]int{"westus2": 1, "eastus": 1, "northeurope": 0, "drained": 0},
+		},
+		"negative": {
+			replicas: -1,
+			want:     map[string]int{"westus2": 0, "eastus": 0, "northeurope": 0, "drained": 0},
+		},
+		"none": {
+			replicas: 0,
+			want:     map[string]int{"westus2": 0, "eastus": 0, "northeurope": 0, "drained": 0},
+		},
+	}
+
+	for name, tc := range cases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			g := NewWithT(t)
+			g.Expect(locationTargets(spread, tc.replicas)).To(Equal(tc.want))
+		})
+	}
+}
+
+func TestSpreadPlacement(t *testing.T) {
+	g := NewWithT(t)
+
+	bale := &infrav1alpha1.Bale{}
+	bale.Spec.Replicas = 3
+	bale.Spec.Spread = &infrav1alpha1.LocationSpread{
+		Locations: []infrav1alpha1.WeightedLocation{
+			{Name: "westus2", Weight: 1},
+			{Name: "eastus", Weight: 1},
+		},
+		MaxSkew: 1,
+	}
+
+	newTurtle := func(name, location string) infrav1alpha1.Turtle {
+		turtle := infrav1alpha1.Turtle{}
+		turtle.Name = name
+		turtle.Spec.Location = location
+		return turtle
+	}
+
+	turtles := []infrav1alpha1.Turtle{
+		newTurtle("a", "westus2"),
+		newTurtle("b", "westus2"),
+		newTurtle("c", "westus2"),
+		newTurtle("d", "centralus"),
+	}
+
+	g.Expect(nextLocation(bale, countByLocation(turtles))).To(Equal("eastus"))
+
+	victims := getSpreadTurtlesToDelete(bale, turtles, 2, nil)
+	g.Expect(victims).To(HaveLen(2))
+	g.Expect(victims[0].Spec.Location).To(Equal("centralus"))
+	g.Expect(victims[1].Spec.Location).To(Equal("westus2"))
+
+	// westus2 is only one above its share, within maxSkew.
+	g.Expect(misplacedTurtles(bale, turtles, nil)).To(Equal(map[string]bool{"d": true}))
+}
@@ -0,0 +1,72 @@
+// Copyright 2020 Alexander Eldeib
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS
+// OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+// CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+// TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package controllers
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	infrav1alpha1 "github.com/alexeldeib/bale/api/v1alpha1"
+)
+
+// getCondition returns the condition of the given type, or nil if it is not set.
+func getCondition(conditions infrav1alpha1.Conditions, conditionType infrav1alpha1.ConditionType) *infrav1alpha1.Condition {
+	for i := range conditions {
+		if conditions[i].Type == conditionType {
+			return &conditions[i]
+		}
+	}
+	return nil
+}
+
+// setCondition adds or replaces the condition of the given type. The
+// transition time only moves when the status changes.
+func setCondition(
+	conditions *infrav1alpha1.Conditions,
+	conditionType infrav1alpha1.ConditionType,
+	status corev1.ConditionStatus,
+	reason, message string,
+) {
+	if existing := getCondition(*conditions, conditionType); existing != nil {
+		if existing.Status != status {
+			existing.LastTransitionTime = metav1.Now()
+		}
+		existing.Status = status
+		existing.Reason = reason
+		existing.Message = message
+		return
+	}
+
+	*conditions = append(*conditions, infrav1alpha1.Condition{
+		Type:               conditionType,
+		Status:             status,
+		LastTransitionTime: metav1.Now(),
+		Reason:             reason,
+		Message:            message,
+	})
+}
+
+// isConditionTrue reports whether the condition of the given type is set and true.
+func isConditionTrue(conditions infrav1alpha1.Conditions, conditionType infrav1alpha1.ConditionType) bool {
+	condition := getCondition(conditions, conditionType)
+	return condition != nil && condition.Status == corev1.ConditionTrue
+}
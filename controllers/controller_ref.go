@@ -0,0 +1,148 @@
+// Copyright 2020 Alexander Eldeib
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS
+// OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+// CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+// TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-logr/logr"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	infrav1alpha1 "github.com/alexeldeib/bale/api/v1alpha1"
+)
+
+// claimTurtles returns the turtles owned by a bale out of every turtle in its
+// namespace, after reconciling controller references in the same way a
+// ReplicaSet claims pods. Orphans
+// matching the selector are adopted, and owned turtles which no longer match
+// are released. Turtles controlled by something else are never touched.
+func (r *BaleReconciler) claimTurtles(
+	ctx context.Context,
+	log logr.Logger,
+	bale *infrav1alpha1.Bale,
+	selector labels.Selector,
+	turtles []infrav1alpha1.Turtle,
+) ([]infrav1alpha1.Turtle, error) {
+	var claimed []infrav1alpha1.Turtle
+	for i := range turtles {
+		turtle := turtles[i].DeepCopy()
+		matches := !selector.Empty() && selector.Matches(labels.Set(turtle.Labels))
+		owner := metav1.GetControllerOf(turtle)
+
+		switch {
+		case owner != nil && owner.UID != bale.UID:
+			continue
+		case owner != nil && matches:
+			claimed = append(claimed, *turtle)
+		case owner != nil:
+			log.Info("releasing turtle which no longer matches selector", "turtle", turtle.Name)
+			if err := r.releaseTurtle(ctx, bale, turtle); err != nil {
+				return nil, err
+			}
+		case matches && turtle.DeletionTimestamp.IsZero():
+			log.Info("adopting orphaned turtle", "turtle", turtle.Name)
+			if err := r.adoptTurtle(ctx, bale, turtle); err != nil {
+				return nil, err
+			}
+			claimed = append(claimed, *turtle)
+		}
+	}
+
+	return claimed, nil
+}
+
+// ownedTurtles returns the turtles controlled by a bale without adopting or
+// releasing any.
+func ownedTurtles(bale *infrav1alpha1.Bale, turtles []infrav1alpha1.Turtle) []infrav1alpha1.Turtle {
+	var owned []infrav1alpha1.Turtle
+	for i := range turtles {
+		if owner := metav1.GetControllerOf(&turtles[i]); owner != nil && owner.UID == bale.UID {
+			owned = append(owned, turtles[i])
+		}
+	}
+	return owned
+}
+
+func (r *BaleReconciler) adoptTurtle(ctx context.Context, bale *infrav1alpha1.Bale, turtle *infrav1alpha1.Turtle) error {
+	if err := controllerutil.SetControllerReference(bale, turtle, r.Scheme); err != nil {
+		return fmt.Errorf("failed to set controller reference on turtle %s: %w", turtle.Name, err)
+	}
+	// Update rather than patch, so a concurrent change to the turtle's labels
+	// fails on the resource version instead of adopting a turtle which no
+	// longer matches.
+	if err := r.Update(ctx, turtle); err != nil {
+		return fmt.Errorf("failed to adopt turtle %s: %w", turtle.Name, err)
+	}
+	return nil
+}
+
+func (r *BaleReconciler) releaseTurtle(ctx context.Context, bale *infrav1alpha1.Bale, turtle *infrav1alpha1.Turtle) error {
+	refs := turtle.OwnerReferences[:0]
+	for _, ref := range turtle.OwnerReferences {
+		if ref.UID != bale.UID {
+			refs = append(refs, ref)
+		}
+	}
+	turtle.OwnerReferences = refs
+	if err := r.Update(ctx, turtle); client.IgnoreNotFound(err) != nil {
+		return fmt.Errorf("failed to release turtle %s: %w", turtle.Name, err)
+	}
+	return nil
+}
+
+// turtleToBales maps an orphaned turtle to every bale in its namespace whose
+// selector matches it, so it is adopted promptly. Owned turtles are handled
+// by the owner reference watch.
+func (r *BaleReconciler) turtleToBales(obj handler.MapObject) []reconcile.Request {
+	if metav1.GetControllerOf(obj.Meta) != nil {
+		return nil
+	}
+
+	var bales infrav1alpha1.BaleList
+	if err := r.List(context.Background(), &bales, client.InNamespace(obj.Meta.GetNamespace())); err != nil {
+		r.Log.Error(err, "failed to list bales for turtle", "turtle", obj.Meta.GetName())
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for i := range bales.Items {
+		bale := &bales.Items[i]
+		selector, err := metav1.LabelSelectorAsSelector(bale.Spec.Selector)
+		if err != nil || selector.Empty() {
+			continue
+		}
+		if selector.Matches(labels.Set(obj.Meta.GetLabels())) {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Namespace: bale.Namespace, Name: bale.Name},
+			})
+		}
+	}
+
+	return requests
+}
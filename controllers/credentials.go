@@ -0,0 +1,146 @@
+// Copyright 2020 Alexander Eldeib
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS
+// OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+// CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+// TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/Azure/go-autorest/autorest/azure/auth"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	infrav1alpha1 "github.com/alexeldeib/bale/api/v1alpha1"
+)
+
+// Keys of an Azure credentials secret, matching config/manager/credentials.yaml.
+const (
+	subscriptionIDKey = "subscription-id"
+	tenantIDKey       = "tenant-id"
+	clientIDKey       = "client-id"
+	clientSecretKey   = "client-secret"
+	environmentKey    = "environment"
+)
+
+// defaultAzureEnvironment is used when credentials don't name a cloud.
+const defaultAzureEnvironment = "AzurePublicCloud"
+
+// defaultCredentials is the secret holding the manager's own Azure
+// credentials. It is also the name of the secret pushed to every turtle.
+var defaultCredentials = types.NamespacedName{
+	Name:      "bale-manager-credentials",
+	Namespace: "bale-system",
+}
+
+// getCredentials returns the secret holding the Azure credentials of a
+// turtle: the secret named by spec.credentialsRef in the turtle's namespace,
+// or the manager's own credentials.
+//
+// Cluster API Provider Azure creates the Azure resources of every turtle
+// with the manager's identity, so credentials of another identity would
+// only reach the cloud provider of the cluster. They are refused rather
+// than silently ignored; a credentialsRef may only change the subscription.
+func (r *TurtleReconciler) getCredentials(ctx context.Context, turtle *infrav1alpha1.Turtle) (*corev1.Secret, error) {
+	key := r.DefaultCredentials
+	if key.Name == "" {
+		key = defaultCredentials
+	}
+
+	manager, err := r.getSecret(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if turtle.Spec.CredentialsRef == nil {
+		return manager, nil
+	}
+
+	credentials, err := r.getSecret(ctx, types.NamespacedName{Namespace: turtle.Namespace, Name: turtle.Spec.CredentialsRef.Name})
+	if err != nil {
+		return nil, err
+	}
+	if err := checkIdentity(manager, credentials); err != nil {
+		return nil, err
+	}
+	return credentials, nil
+}
+
+func (r *TurtleReconciler) getSecret(ctx context.Context, key types.NamespacedName) (*corev1.Secret, error) {
+	credentials := &corev1.Secret{}
+	if err := r.Get(ctx, key, credentials); err != nil {
+		return nil, fmt.Errorf("failed to get azure credentials %s: %w", key, err)
+	}
+	return credentials, nil
+}
+
+// checkIdentity returns an error unless credentials belong to the same
+// tenant and client as the manager's.
+func checkIdentity(manager, credentials *corev1.Secret) error {
+	for _, key := range []string{tenantIDKey, clientIDKey} {
+		if !bytes.Equal(manager.Data[key], credentials.Data[key]) {
+			return fmt.Errorf("azure credentials %s/%s have a different %s than the manager's, "+
+				"which cluster api provider azure doesn't support", credentials.Namespace, credentials.Name, key)
+		}
+	}
+	return nil
+}
+
+// getAzureSettings returns the Azure settings of a turtle keyed like the
+// environment variables understood by go-autorest. An explicit subscription
+// on the turtle takes precedence over the one in its credentials.
+func (r *TurtleReconciler) getAzureSettings(ctx context.Context, turtle *infrav1alpha1.Turtle) (map[string]string, error) {
+	credentials, err := r.getCredentials(ctx, turtle)
+	if err != nil {
+		return nil, err
+	}
+	return azureSettings(turtle, credentials), nil
+}
+
+func azureSettings(turtle *infrav1alpha1.Turtle, credentials *corev1.Secret) map[string]string {
+	settings := map[string]string{
+		auth.SubscriptionID:  string(credentials.Data[subscriptionIDKey]),
+		auth.TenantID:        string(credentials.Data[tenantIDKey]),
+		auth.ClientID:        string(credentials.Data[clientIDKey]),
+		auth.ClientSecret:    string(credentials.Data[clientSecretKey]),
+		auth.EnvironmentName: string(credentials.Data[environmentKey]),
+	}
+	if turtle.Spec.SubscriptionID != "" {
+		settings[auth.SubscriptionID] = turtle.Spec.SubscriptionID
+	}
+	if settings[auth.EnvironmentName] == "" {
+		settings[auth.EnvironmentName] = defaultAzureEnvironment
+	}
+	return settings
+}
+
+// credentialsData returns the contents of the credentials secret pushed to
+// a turtle's cluster, with the turtle's subscription applied.
+func credentialsData(turtle *infrav1alpha1.Turtle, credentials *corev1.Secret) map[string][]byte {
+	data := make(map[string][]byte, len(credentials.Data))
+	for key, value := range credentials.Data {
+		data[key] = value
+	}
+	if turtle.Spec.SubscriptionID != "" {
+		data[subscriptionIDKey] = []byte(turtle.Spec.SubscriptionID)
+	}
+	return data
+}
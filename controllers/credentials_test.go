@@ -0,0 +1,46 @@
+// Copyright 2020 Alexander Eldeib
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS
+// OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+// CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+// TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package controllers
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestCheckIdentity(t *testing.T) {
+	g := NewWithT(t)
+
+	secret := func(tenant, client, subscription string) *corev1.Secret {
+		return &corev1.Secret{Data: map[string][]byte{
+			tenantIDKey:       []byte(tenant),
+			clientIDKey:       []byte(client),
+			subscriptionIDKey: []byte(subscription),
+		}}
+	}
+	manager := secret("tenant", "client", "subscription")
+
+	g.Expect(checkIdentity(manager, secret("tenant", "client", "other"))).To(Succeed(), "subscriptions may differ")
+	g.Expect(checkIdentity(manager, secret("other", "client", "subscription"))).NotTo(Succeed())
+	g.Expect(checkIdentity(manager, secret("tenant", "other", "subscription"))).NotTo(Succeed())
+}
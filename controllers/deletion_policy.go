@@ -0,0 +1,92 @@
+// Copyright 2020 Alexander Eldeib
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS
+// OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+// CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+// TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package controllers
+
+import (
+	"sort"
+	"strconv"
+
+	infrav1alpha1 "github.com/alexeldeib/bale/api/v1alpha1"
+)
+
+// getTurtlesToDelete returns the first n turtles in the order they should be
+// removed according to policy. ready reports which turtles are healthy and is
+// only consulted by the UnhealthyFirst policy.
+func getTurtlesToDelete(
+	turtles []infrav1alpha1.Turtle,
+	n int,
+	policy infrav1alpha1.DeletionPolicy,
+	ready map[string]bool,
+) []infrav1alpha1.Turtle {
+	if n <= 0 {
+		return nil
+	}
+
+	candidates := make([]infrav1alpha1.Turtle, len(turtles))
+	copy(candidates, turtles)
+
+	newerFirst := func(a, b *infrav1alpha1.Turtle) bool {
+		if a.CreationTimestamp.Equal(&b.CreationTimestamp) {
+			return a.Name > b.Name
+		}
+		return b.CreationTimestamp.Before(&a.CreationTimestamp)
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := &candidates[i], &candidates[j]
+		switch policy {
+		case infrav1alpha1.DeletionPolicyOldest:
+			return newerFirst(b, a)
+		case infrav1alpha1.DeletionPolicyNewest:
+			return newerFirst(a, b)
+		case infrav1alpha1.DeletionPolicyPriority:
+			if pa, pb := deletePriority(a), deletePriority(b); pa != pb {
+				return pa > pb
+			}
+			return newerFirst(a, b)
+		default:
+			if ready[a.Name] != ready[b.Name] {
+				return !ready[a.Name]
+			}
+			return newerFirst(a, b)
+		}
+	})
+
+	if n > len(candidates) {
+		n = len(candidates)
+	}
+	return candidates[:n]
+}
+
+// deletePriority parses the delete priority annotation of a turtle,
+// treating missing or malformed values as zero.
+func deletePriority(turtle *infrav1alpha1.Turtle) int {
+	value, ok := turtle.Annotations[infrav1alpha1.DeletePriorityAnnotation]
+	if !ok {
+		return 0
+	}
+	priority, err := strconv.Atoi(value)
+	if err != nil {
+		return 0
+	}
+	return priority
+}
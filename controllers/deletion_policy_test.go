@@ -0,0 +1,107 @@
+// Copyright 2020 Alexander Eldeib
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS
+// OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+// CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+// TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	infrav1alpha1 "github.com/alexeldeib/bale/api/v1alpha1"
+)
+
+func TestGetTurtlesToDelete(t *testing.T) {
+	now := time.Now()
+	newTurtle := func(name string, age time.Duration, priority string) infrav1alpha1.Turtle {
+		turtle := infrav1alpha1.Turtle{}
+		turtle.Name = name
+		turtle.CreationTimestamp = metav1.NewTime(now.Add(-age))
+		if priority != "" {
+			turtle.Annotations = map[string]string{infrav1alpha1.DeletePriorityAnnotation: priority}
+		}
+		return turtle
+	}
+
+	turtles := []infrav1alpha1.Turtle{
+		newTurtle("middle", 2*time.Hour, ""),
+		newTurtle("oldest", 3*time.Hour, "5"),
+		newTurtle("newest", 1*time.Hour, "garbage"),
+	}
+
+	names := func(turtles []infrav1alpha1.Turtle) []string {
+		out := make([]string, 0, len(turtles))
+		for i := range turtles {
+			out = append(out, turtles[i].Name)
+		}
+		return out
+	}
+
+	cases := map[string]struct {
+		policy infrav1alpha1.DeletionPolicy
+		ready  map[string]bool
+		count  int
+		want   []string
+	}{
+		"oldest": {
+			policy: infrav1alpha1.DeletionPolicyOldest,
+			count:  2,
+			want:   []string{"oldest", "middle"},
+		},
+		"newest": {
+			policy: infrav1alpha1.DeletionPolicyNewest,
+			count:  2,
+			want:   []string{"newest", "middle"},
+		},
+		"unhealthy first": {
+			policy: infrav1alpha1.DeletionPolicyUnhealthyFirst,
+			ready:  map[string]bool{"newest": true, "middle": true},
+			count:  2,
+			want:   []string{"oldest", "newest"},
+		},
+		"priority": {
+			policy: infrav1alpha1.DeletionPolicyPriority,
+			count:  3,
+			want:   []string{"oldest", "newest", "middle"},
+		},
+		"more than available": {
+			policy: infrav1alpha1.DeletionPolicyNewest,
+			count:  5,
+			want:   []string{"newest", "middle", "oldest"},
+		},
+		"none": {
+			policy: infrav1alpha1.DeletionPolicyNewest,
+			count:  0,
+			want:   []string{},
+		},
+	}
+
+	for name, tc := range cases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			g := NewWithT(t)
+			got := getTurtlesToDelete(turtles, tc.count, tc.policy, tc.ready)
+			g.Expect(names(got)).To(Equal(tc.want))
+		})
+	}
+}
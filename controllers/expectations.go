@@ -0,0 +1,160 @@
+// Copyright 2020 Alexander Eldeib
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS
+// OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+// CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+// TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package controllers
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	infrav1alpha1 "github.com/alexeldeib/bale/api/v1alpha1"
+)
+
+// expectationsTimeout bounds how long a bale waits for the cache to observe
+// its own creates and deletes before acting on the cache regardless.
+const expectationsTimeout = 5 * time.Minute
+
+// expectationsRequeueInterval is how soon a bale with unsatisfied
+// expectations is revisited, in case the events satisfying them were missed.
+const expectationsRequeueInterval = 10 * time.Second
+
+// expectations tracks turtles a bale has created or deleted which the
+// informer cache has not observed yet, in the spirit of the ReplicaSet
+// controller's UIDTrackingControllerExpectations. Until they are observed
+// the cached count of turtles is stale, and acting on it could create more
+// clusters than requested.
+type expectations struct {
+	mu    sync.Mutex
+	store map[string]*expectation
+}
+
+type expectation struct {
+	creations sets.String
+	deletions sets.String
+	timestamp time.Time
+}
+
+func newExpectations() *expectations {
+	return &expectations{store: map[string]*expectation{}}
+}
+
+// ExpectCreations records turtles about to be created by the bale identified by key.
+func (e *expectations) ExpectCreations(key string, names ...string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	exp := e.get(key)
+	exp.creations.Insert(names...)
+	exp.timestamp = time.Now()
+}
+
+// ExpectDeletions records turtles about to be deleted by the bale identified by key.
+func (e *expectations) ExpectDeletions(key string, names ...string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	exp := e.get(key)
+	exp.deletions.Insert(names...)
+	exp.timestamp = time.Now()
+}
+
+// CreationObserved lowers the expectation for a turtle which was created, or
+// whose creation failed and will therefore never be observed.
+func (e *expectations) CreationObserved(key, name string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if exp, ok := e.store[key]; ok {
+		exp.creations.Delete(name)
+	}
+}
+
+// DeletionObserved lowers the expectation for a turtle which was deleted, or
+// whose deletion failed and will therefore never be observed.
+func (e *expectations) DeletionObserved(key, name string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if exp, ok := e.store[key]; ok {
+		exp.deletions.Delete(name)
+	}
+}
+
+// Satisfied lowers expectations using the turtles currently in the cache and
+// reports whether the cache reflects every create and delete issued by the
+// bale identified by key. Expectations older than expectationsTimeout are
+// dropped, so a lost watch event can't wedge a bale forever.
+func (e *expectations) Satisfied(key string, turtles []infrav1alpha1.Turtle) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	exp, ok := e.store[key]
+	if !ok {
+		return true
+	}
+
+	for i := range turtles {
+		name := turtles[i].Name
+		exp.creations.Delete(name)
+		if turtles[i].DeletionTimestamp.IsZero() {
+			continue
+		}
+		exp.deletions.Delete(name)
+	}
+
+	if exp.deletions.Len() > 0 {
+		present := sets.NewString()
+		for i := range turtles {
+			present.Insert(turtles[i].Name)
+		}
+		for _, name := range exp.deletions.UnsortedList() {
+			if !present.Has(name) {
+				exp.deletions.Delete(name)
+			}
+		}
+	}
+
+	if exp.creations.Len() == 0 && exp.deletions.Len() == 0 {
+		delete(e.store, key)
+		return true
+	}
+
+	if time.Since(exp.timestamp) > expectationsTimeout {
+		delete(e.store, key)
+		return true
+	}
+
+	return false
+}
+
+// Delete forgets all expectations for the bale identified by key.
+func (e *expectations) Delete(key string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.store, key)
+}
+
+func (e *expectations) get(key string) *expectation {
+	exp, ok := e.store[key]
+	if !ok {
+		exp = &expectation{creations: sets.NewString(), deletions: sets.NewString()}
+		e.store[key] = exp
+	}
+	return exp
+}
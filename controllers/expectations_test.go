@@ -0,0 +1,68 @@
+// Copyright 2020 Alexander Eldeib
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS
+// OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+// CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+// TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	infrav1alpha1 "github.com/alexeldeib/bale/api/v1alpha1"
+)
+
+func TestExpectations(t *testing.T) {
+	g := NewWithT(t)
+	const key = "default/bale"
+
+	turtle := func(name string, deleting bool) infrav1alpha1.Turtle {
+		out := infrav1alpha1.Turtle{}
+		out.Name = name
+		if deleting {
+			now := metav1.Now()
+			out.DeletionTimestamp = &now
+		}
+		return out
+	}
+
+	exp := newExpectations()
+	g.Expect(exp.Satisfied(key, nil)).To(BeTrue())
+
+	exp.ExpectCreations(key, "a", "b")
+	exp.ExpectDeletions(key, "c")
+	g.Expect(exp.Satisfied(key, []infrav1alpha1.Turtle{turtle("c", false)})).To(BeFalse())
+
+	// creations are observed once present, deletions once gone or terminating
+	g.Expect(exp.Satisfied(key, []infrav1alpha1.Turtle{turtle("a", false), turtle("c", true)})).To(BeFalse())
+	g.Expect(exp.Satisfied(key, []infrav1alpha1.Turtle{turtle("a", false), turtle("b", false)})).To(BeTrue())
+
+	// failed writes are lowered explicitly
+	exp.ExpectCreations(key, "d")
+	exp.CreationObserved(key, "d")
+	g.Expect(exp.Satisfied(key, nil)).To(BeTrue())
+
+	// expectations never outlive the timeout
+	exp.ExpectCreations(key, "e")
+	exp.store[key].timestamp = time.Now().Add(-2 * expectationsTimeout)
+	g.Expect(exp.Satisfied(key, nil)).To(BeTrue())
+}
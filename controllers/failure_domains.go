@@ -0,0 +1,85 @@
+// Copyright 2020 Alexander Eldeib
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS
+// OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+// CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+// TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/types"
+	capiv1alpha3 "sigs.k8s.io/cluster-api/api/v1alpha3"
+
+	infrav1alpha1 "github.com/alexeldeib/bale/api/v1alpha1"
+)
+
+// checkFailureDomain returns an error unless the failure domain of a
+// hatchling is one of the failure domains of its cluster. Those are only
+// known once the infrastructure is provisioned, so until then it waits.
+func (r *TurtleReconciler) checkFailureDomain(ctx context.Context, turtle *infrav1alpha1.Turtle, hatchling *infrav1alpha1.HatchlingSpec) error {
+	if hatchling.FailureDomain == "" {
+		return nil
+	}
+
+	cluster := &capiv1alpha3.Cluster{}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: turtle.Namespace, Name: turtle.Name}, cluster); err != nil {
+		return fmt.Errorf("failed to get cluster: %w", err)
+	}
+
+	if !cluster.Status.InfrastructureReady {
+		return waitFor("WaitingForFailureDomains", "waiting on the failure domains of the cluster", statusRequeueInterval)
+	}
+
+	if _, ok := cluster.Status.FailureDomains[hatchling.FailureDomain]; !ok {
+		return fmt.Errorf("failure domain %q of hatchling %s is not one of the failure domains of %s: [%s]",
+			hatchling.FailureDomain, hatchling.Name, turtle.Spec.Location, strings.Join(failureDomainIDs(cluster.Status.FailureDomains), ", "))
+	}
+
+	return nil
+}
+
+// failureDomainIDs returns the sorted IDs of failure domains.
+func failureDomainIDs(failureDomains capiv1alpha3.FailureDomains) []string {
+	ids := make([]string, 0, len(failureDomains))
+	for id := range failureDomains {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// machineFailureDomains returns the sorted failure domains machines are in.
+func machineFailureDomains(machines []capiv1alpha3.Machine) []string {
+	seen := map[string]bool{}
+	var ids []string
+	for i := range machines {
+		failureDomain := machines[i].Spec.FailureDomain
+		if failureDomain == nil || seen[*failureDomain] {
+			continue
+		}
+		seen[*failureDomain] = true
+		ids = append(ids, *failureDomain)
+	}
+	sort.Strings(ids)
+	return ids
+}
@@ -0,0 +1,123 @@
+// Copyright 2020 Alexander Eldeib
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS
+// OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+// CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+// TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	capzv1alpha3 "sigs.k8s.io/cluster-api-provider-azure/api/v1alpha3"
+	capiv1alpha3 "sigs.k8s.io/cluster-api/api/v1alpha3"
+	kcpv1alpha3 "sigs.k8s.io/cluster-api/controlplane/kubeadm/api/v1alpha3"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	infrav1alpha1 "github.com/alexeldeib/bale/api/v1alpha1"
+)
+
+// AzureMachineTemplates can't be changed once created, so every distinct
+// spec gets its own template named after a hash of its contents. Pointing a
+// machine deployment or control plane at a new template rolls its machines,
+// and templates nothing points at anymore are garbage collected.
+
+// controlPlaneMachineTemplate returns the machine template of the control
+// plane of a turtle.
+func controlPlaneMachineTemplate(turtle *infrav1alpha1.Turtle) *capzv1alpha3.AzureMachineTemplate {
+	return hashMachineTemplate(getMachineTemplate(
+		turtle.Namespace,
+		controlPlaneMachineTemplateName(turtle),
+		turtle.Spec.Location,
+		turtle.Spec.ControlPlaneVMSize,
+		turtle.Spec.ControlPlaneOSDiskSizeGB,
+	))
+}
+
+// hatchlingMachineTemplate returns the machine template of a hatchling.
+func hatchlingMachineTemplate(turtle *infrav1alpha1.Turtle, hatchling *infrav1alpha1.HatchlingSpec) *capzv1alpha3.AzureMachineTemplate {
+	return hashMachineTemplate(getMachineTemplate(
+		turtle.Namespace,
+		hatchlingResourceName(turtle, hatchling),
+		turtle.Spec.Location,
+		hatchling.VMSize,
+		hatchling.OSDiskSizeGB,
+	))
+}
+
+// hashMachineTemplate suffixes the name of a machine template with a hash
+// of its spec.
+func hashMachineTemplate(template *capzv1alpha3.AzureMachineTemplate) *capzv1alpha3.AzureMachineTemplate {
+	template.Name = fmt.Sprintf("%s-%s", template.Name, computeJSONHash(template.Spec))
+	return template
+}
+
+// garbageCollectMachineTemplates deletes the machine templates of a turtle
+// which are no longer referenced by its control plane, its machine
+// deployments, or machine sets which still have machines.
+func (r *TurtleReconciler) garbageCollectMachineTemplates(ctx context.Context, turtle *infrav1alpha1.Turtle) error {
+	referenced := map[string]bool{}
+
+	controlPlane := &kcpv1alpha3.KubeadmControlPlane{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: turtle.Namespace, Name: turtle.Name}, controlPlane); client.IgnoreNotFound(err) != nil {
+		return fmt.Errorf("failed to get kubeadm control plane: %w", err)
+	}
+	referenced[controlPlane.Spec.InfrastructureTemplate.Name] = true
+
+	clusterLabels := client.MatchingLabels{capiv1alpha3.ClusterLabelName: turtle.Name}
+
+	var mds capiv1alpha3.MachineDeploymentList
+	if err := r.List(ctx, &mds, client.InNamespace(turtle.Namespace), clusterLabels); err != nil {
+		return fmt.Errorf("failed to list machine deployments: %w", err)
+	}
+	for i := range mds.Items {
+		referenced[mds.Items[i].Spec.Template.Spec.InfrastructureRef.Name] = true
+	}
+
+	// Machine sets of previous revisions keep their template while they
+	// scale down, in case the rollout is reverted.
+	var machineSets capiv1alpha3.MachineSetList
+	if err := r.List(ctx, &machineSets, client.InNamespace(turtle.Namespace), clusterLabels); err != nil {
+		return fmt.Errorf("failed to list machine sets: %w", err)
+	}
+	for i := range machineSets.Items {
+		machineSet := &machineSets.Items[i]
+		if machineSet.Status.Replicas > 0 || (machineSet.Spec.Replicas != nil && *machineSet.Spec.Replicas > 0) {
+			referenced[machineSet.Spec.Template.Spec.InfrastructureRef.Name] = true
+		}
+	}
+
+	var templates capzv1alpha3.AzureMachineTemplateList
+	if err := r.List(ctx, &templates, client.InNamespace(turtle.Namespace), client.MatchingLabels{infrav1alpha1.TurtleNameLabel: turtle.Name}); err != nil {
+		return fmt.Errorf("failed to list machine templates: %w", err)
+	}
+	for i := range templates.Items {
+		template := &templates.Items[i]
+		if referenced[template.Name] || !metav1.IsControlledBy(template, turtle) {
+			continue
+		}
+		r.Log.Info("deleting unreferenced machine template", "turtle", turtle.Name, "template", template.Name)
+		if err := deleteIfPresent(ctx, r.Client, template); err != nil {
+			return fmt.Errorf("failed to delete machine template %s: %w", template.Name, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,301 @@
+// Copyright 2020 Alexander Eldeib
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS
+// OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+// CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+// TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	capzv1alpha3 "sigs.k8s.io/cluster-api-provider-azure/api/v1alpha3"
+	capiv1alpha3 "sigs.k8s.io/cluster-api/api/v1alpha3"
+	kcpv1alpha3 "sigs.k8s.io/cluster-api/controlplane/kubeadm/api/v1alpha3"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	infrav1alpha1 "github.com/alexeldeib/bale/api/v1alpha1"
+)
+
+func newMachineTemplateScheme(g *WithT) *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	g.Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+	g.Expect(infrav1alpha1.AddToScheme(scheme)).To(Succeed())
+	g.Expect(capiv1alpha3.AddToScheme(scheme)).To(Succeed())
+	g.Expect(kcpv1alpha3.AddToScheme(scheme)).To(Succeed())
+	g.Expect(capzv1alpha3.AddToScheme(scheme)).To(Succeed())
+	return scheme
+}
+
+func TestHatchlingMachineTemplate(t *testing.T) {
+	turtle := &infrav1alpha1.Turtle{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "turtle"},
+		Spec:       infrav1alpha1.TurtleSpec{Location: "westus2"},
+	}
+	base := infrav1alpha1.HatchlingSpec{Name: "default", Replicas: 3, VMSize: "Standard_D2s_v3", OSDiskSizeGB: 30}
+	current := hatchlingMachineTemplate(turtle, &base).Name
+
+	cases := map[string]struct {
+		mutate      func(hatchling *infrav1alpha1.HatchlingSpec)
+		wantRotated bool
+	}{
+		"unchanged": {
+			mutate: func(*infrav1alpha1.HatchlingSpec) {},
+		},
+		"scaled": {
+			mutate: func(hatchling *infrav1alpha1.HatchlingSpec) { hatchling.Replicas = 5 },
+		},
+		"resized vms": {
+			mutate:      func(hatchling *infrav1alpha1.HatchlingSpec) { hatchling.VMSize = "Standard_D4s_v3" },
+			wantRotated: true,
+		},
+		"resized disks": {
+			mutate:      func(hatchling *infrav1alpha1.HatchlingSpec) { hatchling.OSDiskSizeGB = 60 },
+			wantRotated: true,
+		},
+	}
+
+	for name, tc := range cases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			g := NewWithT(t)
+			hatchling := base
+			tc.mutate(&hatchling)
+			got := hatchlingMachineTemplate(turtle, &hatchling).Name
+			g.Expect(got).To(HavePrefix("turtle-default-"))
+			if tc.wantRotated {
+				g.Expect(got).NotTo(Equal(current))
+			} else {
+				g.Expect(got).To(Equal(current))
+			}
+		})
+	}
+}
+
+func TestReconcileMachineTemplate(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	turtle := &infrav1alpha1.Turtle{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "turtle", UID: "uid"},
+		Spec: infrav1alpha1.TurtleSpec{
+			Location:   "westus2",
+			Hatchlings: []infrav1alpha1.HatchlingSpec{{Name: "default", VMSize: "Standard_D2s_v3"}},
+		},
+	}
+	hatchling := &turtle.Spec.Hatchlings[0]
+	want := hatchlingMachineTemplate(turtle, hatchling)
+
+	// A template which exists under the name of a spec keeps its spec, since
+	// AzureMachineTemplates can't be changed.
+	existing := want.DeepCopy()
+	existing.CreationTimestamp = metav1.Now()
+	existing.Spec.Template.Spec.VMSize = "Standard_D4s_v3"
+
+	scheme := newMachineTemplateScheme(g)
+	r := &TurtleReconciler{Client: fake.NewFakeClientWithScheme(scheme, existing), Scheme: scheme}
+	g.Expect(r.reconcileMachineTemplate(ctx, turtle, want, hatchling)).To(Succeed())
+
+	template := &capzv1alpha3.AzureMachineTemplate{}
+	g.Expect(r.Get(ctx, types.NamespacedName{Namespace: "default", Name: want.Name}, template)).To(Succeed())
+	g.Expect(template.Spec).To(Equal(existing.Spec))
+	g.Expect(template.Labels).To(HaveKeyWithValue(infrav1alpha1.HatchlingNameLabel, "default"))
+	g.Expect(template.Labels).To(HaveKeyWithValue(infrav1alpha1.TurtleNameLabel, "turtle"))
+	g.Expect(metav1.IsControlledBy(template, turtle)).To(BeTrue())
+
+	controlPlane := controlPlaneMachineTemplate(turtle)
+	g.Expect(r.reconcileMachineTemplate(ctx, turtle, controlPlane, nil)).To(Succeed())
+	template = &capzv1alpha3.AzureMachineTemplate{}
+	g.Expect(r.Get(ctx, types.NamespacedName{Namespace: "default", Name: controlPlane.Name}, template)).To(Succeed())
+	g.Expect(template.Spec).To(Equal(controlPlane.Spec))
+	g.Expect(template.Labels).NotTo(HaveKey(infrav1alpha1.HatchlingNameLabel))
+}
+
+func TestHatchlingMachineTemplateSteps(t *testing.T) {
+	turtle := &infrav1alpha1.Turtle{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "turtle", UID: "uid"},
+		Spec: infrav1alpha1.TurtleSpec{
+			Location:   "westus2",
+			Version:    "v1.18.3",
+			Hatchlings: []infrav1alpha1.HatchlingSpec{{Name: "a", VMSize: "Standard_D2s_v3"}, {Name: "b", VMSize: "Standard_D2s_v3"}},
+		},
+	}
+	replicas := int32(1)
+	upgrading := &kcpv1alpha3.KubeadmControlPlane{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "turtle"},
+		Spec:       kcpv1alpha3.KubeadmControlPlaneSpec{Replicas: &replicas, Version: "v1.17.7"},
+	}
+	version := "v1.17.7"
+	a := &capiv1alpha3.MachineDeployment{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "turtle-a"},
+		Spec: capiv1alpha3.MachineDeploymentSpec{
+			Template: capiv1alpha3.MachineTemplateSpec{Spec: capiv1alpha3.MachineSpec{Version: &version}},
+		},
+	}
+
+	cases := map[string]struct {
+		objects []runtime.Object
+		want    []string
+	}{
+		"creates templates of every hatchling of a new cluster": {
+			want: []string{"a", "b"},
+		},
+		"holds back templates of hatchlings waiting for the control plane": {
+			objects: []runtime.Object{upgrading, a},
+			want:    []string{"a"},
+		},
+	}
+
+	for name, tc := range cases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			g := NewWithT(t)
+			ctx := context.Background()
+
+			turtle := turtle.DeepCopy()
+			scheme := newMachineTemplateScheme(g)
+			r := &TurtleReconciler{Client: fake.NewFakeClientWithScheme(scheme, tc.objects...), Log: ctrl.Log, Scheme: scheme}
+
+			steps := map[string]reconcileStep{}
+			for _, step := range r.reconcileSteps(turtle) {
+				steps[step.name] = step
+			}
+			g.Expect(steps["upgrade-plan"].fn(ctx, turtle)).To(Succeed())
+			for i := range turtle.Spec.Hatchlings {
+				step := fmt.Sprintf("hatchling/%s/machine-template", turtle.Spec.Hatchlings[i].Name)
+				g.Expect(steps[step].fn(ctx, turtle)).To(Succeed())
+			}
+
+			var templates capzv1alpha3.AzureMachineTemplateList
+			g.Expect(r.List(ctx, &templates)).To(Succeed())
+			var got []string
+			for _, template := range templates.Items {
+				got = append(got, template.Labels[infrav1alpha1.HatchlingNameLabel])
+			}
+			g.Expect(got).To(ConsistOf(tc.want))
+		})
+	}
+}
+
+func TestGarbageCollectMachineTemplates(t *testing.T) {
+	turtle := &infrav1alpha1.Turtle{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "turtle", UID: "uid"}}
+	template := func(name string) *capzv1alpha3.AzureMachineTemplate {
+		return &capzv1alpha3.AzureMachineTemplate{ObjectMeta: metav1.ObjectMeta{
+			Namespace:       "default",
+			Name:            name,
+			Labels:          map[string]string{infrav1alpha1.TurtleNameLabel: "turtle"},
+			OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(turtle, infrav1alpha1.GroupVersion.WithKind("Turtle"))},
+		}}
+	}
+	clusterLabels := map[string]string{capiv1alpha3.ClusterLabelName: "turtle"}
+	controlPlane := &kcpv1alpha3.KubeadmControlPlane{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "turtle"},
+		Spec: kcpv1alpha3.KubeadmControlPlaneSpec{
+			InfrastructureTemplate: corev1.ObjectReference{Name: "turtle-control-plane-new"},
+		},
+	}
+	md := &capiv1alpha3.MachineDeployment{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "turtle-default", Labels: clusterLabels},
+		Spec: capiv1alpha3.MachineDeploymentSpec{Template: capiv1alpha3.MachineTemplateSpec{Spec: capiv1alpha3.MachineSpec{
+			InfrastructureRef: corev1.ObjectReference{Name: "turtle-default-new"},
+		}}},
+	}
+	machineSet := func(name, template string, replicas int32) *capiv1alpha3.MachineSet {
+		return &capiv1alpha3.MachineSet{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, Labels: clusterLabels},
+			Spec: capiv1alpha3.MachineSetSpec{
+				Replicas: &replicas,
+				Template: capiv1alpha3.MachineTemplateSpec{Spec: capiv1alpha3.MachineSpec{InfrastructureRef: corev1.ObjectReference{Name: template}}},
+			},
+			Status: capiv1alpha3.MachineSetStatus{Replicas: replicas},
+		}
+	}
+	unowned := template("turtle-default-unowned")
+	unowned.OwnerReferences = nil
+	failTemplates := func(obj runtime.Object) bool {
+		_, ok := obj.(*capzv1alpha3.AzureMachineTemplate)
+		return ok
+	}
+
+	objects := []runtime.Object{
+		controlPlane, md,
+		machineSet("turtle-default-scaling-down", "turtle-default-previous", 1),
+		machineSet("turtle-default-scaled-down", "turtle-default-old", 0),
+		template("turtle-control-plane-new"), template("turtle-control-plane-old"),
+		template("turtle-default-new"), template("turtle-default-previous"), template("turtle-default-old"),
+		unowned,
+	}
+
+	cases := map[string]struct {
+		fail      func(obj runtime.Object) bool
+		wantError string
+		wantGone  []string
+		wantKept  []string
+	}{
+		"deletes templates nothing references": {
+			wantGone: []string{"turtle-control-plane-old", "turtle-default-old"},
+			wantKept: []string{"turtle-control-plane-new", "turtle-default-new", "turtle-default-previous", "turtle-default-unowned"},
+		},
+		"keeps templates when deleting fails": {
+			fail:      failTemplates,
+			wantError: "failed to delete machine template",
+			wantKept:  []string{"turtle-control-plane-new", "turtle-control-plane-old", "turtle-default-new", "turtle-default-previous", "turtle-default-old"},
+		},
+	}
+
+	for name, tc := range cases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			g := NewWithT(t)
+			ctx := context.Background()
+
+			scheme := newMachineTemplateScheme(g)
+			var c client.Client = fake.NewFakeClientWithScheme(scheme, objects...)
+			if tc.fail != nil {
+				c = &failingDeleteClient{Client: c, fail: tc.fail}
+			}
+			r := &TurtleReconciler{Client: c, Log: ctrl.Log, Scheme: scheme}
+
+			err := r.garbageCollectMachineTemplates(ctx, turtle)
+			if tc.wantError != "" {
+				g.Expect(err).To(MatchError(ContainSubstring(tc.wantError)))
+			} else {
+				g.Expect(err).NotTo(HaveOccurred())
+			}
+
+			for _, name := range tc.wantGone {
+				err := r.Get(ctx, types.NamespacedName{Namespace: "default", Name: name}, &capzv1alpha3.AzureMachineTemplate{})
+				g.Expect(apierrors.IsNotFound(err)).To(BeTrue(), "%s should be deleted", name)
+			}
+			for _, name := range tc.wantKept {
+				g.Expect(r.Get(ctx, types.NamespacedName{Namespace: "default", Name: name}, &capzv1alpha3.AzureMachineTemplate{})).To(Succeed(), "%s should be kept", name)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright 2020 Alexander Eldeib
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS
+// OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+// CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+// TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package controllers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	infrav1alpha1 "github.com/alexeldeib/bale/api/v1alpha1"
+)
+
+// maxNameAttempts is how many random names are tried before giving up on
+// creating a turtle.
+const maxNameAttempts = 5
+
+// randomSuffixLength is the number of random characters appended to the
+// prefix of a turtle in Random naming mode. The Bale webhook bounds the
+// length of turtle names with it; ordinal names are bounded by replicas.
+const randomSuffixLength = 6
+
+func turtleNamePrefix(bale *infrav1alpha1.Bale) string {
+	if bale.Spec.Naming.Prefix != "" {
+		return bale.Spec.Naming.Prefix
+	}
+	return bale.Name
+}
+
+func isOrdinalNaming(bale *infrav1alpha1.Bale) bool {
+	return bale.Spec.Naming.Mode == infrav1alpha1.OrdinalNamingMode
+}
+
+func randomTurtleName(bale *infrav1alpha1.Bale) string {
+	return fmt.Sprintf("%s-%s", turtleNamePrefix(bale), RandomLowercaseString(randomSuffixLength))
+}
+
+func ordinalTurtleName(bale *infrav1alpha1.Bale, ordinal int) string {
+	return fmt.Sprintf("%s-%d", turtleNamePrefix(bale), ordinal)
+}
+
+// checkNameLength returns an error if the name of a new turtle of a bale,
+// or the name of a machine deployment of one of its hatchlings, doesn't fit
+// in a label value. The webhook rejects bales whose names would be too long,
+// but doesn't see replicas changed through the scale subresource.
+func checkNameLength(bale *infrav1alpha1.Bale, name string) error {
+	if len(name) > validation.LabelValueMaxLength {
+		return fmt.Errorf("turtle name %s is longer than %d characters", name, validation.LabelValueMaxLength)
+	}
+	turtle := &infrav1alpha1.Turtle{ObjectMeta: metav1.ObjectMeta{Name: name}}
+	for i := range bale.Spec.Template.Spec.Hatchlings {
+		hatchling := &bale.Spec.Template.Spec.Hatchlings[i]
+		if resource := hatchlingResourceName(turtle, hatchling); len(resource) > validation.LabelValueMaxLength {
+			return fmt.Errorf("hatchling name %s is longer than %d characters", resource, validation.LabelValueMaxLength)
+		}
+	}
+	return nil
+}
+
+// parseOrdinal returns the ordinal of a turtle named <prefix>-<ordinal>.
+func parseOrdinal(bale *infrav1alpha1.Bale, name string) (int, bool) {
+	prefix := turtleNamePrefix(bale) + "-"
+	if !strings.HasPrefix(name, prefix) {
+		return 0, false
+	}
+	suffix := strings.TrimPrefix(name, prefix)
+	ordinal, err := strconv.Atoi(suffix)
+	if err != nil || ordinal < 0 || strconv.Itoa(ordinal) != suffix {
+		return 0, false
+	}
+	return ordinal, true
+}
+
+// turtleResourceGroup returns the Azure resource group for a new turtle.
+// Ordinal names are predictable and reused, so they are qualified with the
+// namespace to keep bales with the same name in different namespaces from
+// sharing a resource group.
+func turtleResourceGroup(bale *infrav1alpha1.Bale, name string) string {
+	if isOrdinalNaming(bale) {
+		return fmt.Sprintf("%s-%s", bale.Namespace, name)
+	}
+	return name
+}
+
+// hatchlingResourceName returns the name of the machine deployment and
+// bootstrap template of a hatchling. Names are qualified with the turtle so
+// several turtles can share a namespace.
+func hatchlingResourceName(turtle *infrav1alpha1.Turtle, hatchling *infrav1alpha1.HatchlingSpec) string {
+	return fmt.Sprintf("%s-%s", turtle.Name, hatchling.Name)
+}
+
+// controlPlaneMachineTemplateName returns the name of the machine template
+// of the control plane of a turtle.
+func controlPlaneMachineTemplateName(turtle *infrav1alpha1.Turtle) string {
+	return fmt.Sprintf("%s-control-plane", turtle.Name)
+}
@@ -0,0 +1,57 @@
+// Copyright 2020 Alexander Eldeib
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS
+// OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+// CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+// TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package controllers
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	infrav1alpha1 "github.com/alexeldeib/bale/api/v1alpha1"
+)
+
+func TestCheckNameLength(t *testing.T) {
+	bale := &infrav1alpha1.Bale{}
+	bale.Spec.Naming = infrav1alpha1.NamingSpec{Mode: infrav1alpha1.OrdinalNamingMode, Prefix: strings.Repeat("p", 60)}
+	bale.Spec.Template.Spec.Hatchlings = []infrav1alpha1.HatchlingSpec{{Name: "gpu"}}
+
+	cases := map[string]struct {
+		ordinal int
+		wantErr string
+	}{
+		"hatchling name too long": {ordinal: 9, wantErr: "hatchling name"},
+		"turtle name too long":    {ordinal: 100, wantErr: "turtle name"},
+	}
+
+	for name, tc := range cases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			g := NewWithT(t)
+			g.Expect(checkNameLength(bale, ordinalTurtleName(bale, tc.ordinal))).To(MatchError(ContainSubstring(tc.wantErr)))
+		})
+	}
+
+	g := NewWithT(t)
+	bale.Spec.Template.Spec.Hatchlings = nil
+	g.Expect(checkNameLength(bale, ordinalTurtleName(bale, 99))).To(Succeed())
+}
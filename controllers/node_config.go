@@ -0,0 +1,107 @@
+// Copyright 2020 Alexander Eldeib
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS
+// OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+// CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+// TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package controllers
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	capbkv1alpha3 "sigs.k8s.io/cluster-api/bootstrap/kubeadm/api/v1alpha3"
+
+	infrav1alpha1 "github.com/alexeldeib/bale/api/v1alpha1"
+)
+
+// sysctlPath is where the sysctls of a hatchling are written on its nodes.
+const sysctlPath = "/etc/sysctl.d/90-bale.conf"
+
+// setNodeConfig renders the node labels, taints, kubelet arguments and
+// sysctls of a hatchling into the bootstrap config of its nodes. The cloud
+// provider arguments already in the config take precedence.
+func setNodeConfig(config *capbkv1alpha3.KubeadmConfigSpec, hatchling *infrav1alpha1.HatchlingSpec) error {
+	if err := hatchling.ValidateNodeConfig(); err != nil {
+		return fmt.Errorf("invalid node config of hatchling %s: %w", hatchling.Name, err)
+	}
+
+	registration := &config.JoinConfiguration.NodeRegistration
+	args := map[string]string{}
+	for key, value := range hatchling.KubeletExtraArgs {
+		args[key] = value
+	}
+	for key, value := range registration.KubeletExtraArgs {
+		args[key] = value
+	}
+	if len(hatchling.NodeLabels) > 0 {
+		args["node-labels"] = joinSorted(hatchling.NodeLabels, "=", ",")
+	}
+	registration.KubeletExtraArgs = args
+	registration.Taints = hatchling.Taints
+
+	if len(hatchling.Sysctls) > 0 {
+		config.Files = append(config.Files, capbkv1alpha3.File{
+			Owner:       "root:root",
+			Path:        sysctlPath,
+			Permissions: "0644",
+			Content:     joinSorted(hatchling.Sysctls, " = ", "\n") + "\n",
+		})
+		config.PreKubeadmCommands = append(config.PreKubeadmCommands, "sysctl --system")
+	}
+
+	return nil
+}
+
+// nodeConfigHash returns a hash of the node configuration of a hatchling,
+// or an empty string if it has none.
+func nodeConfigHash(hatchling *infrav1alpha1.HatchlingSpec) string {
+	if len(hatchling.NodeLabels) == 0 && len(hatchling.Taints) == 0 &&
+		len(hatchling.KubeletExtraArgs) == 0 && len(hatchling.Sysctls) == 0 {
+		return ""
+	}
+
+	return computeJSONHash(struct {
+		NodeLabels       map[string]string
+		Taints           []corev1.Taint
+		KubeletExtraArgs map[string]string
+		Sysctls          map[string]string
+	}{
+		hatchling.NodeLabels,
+		hatchling.Taints,
+		hatchling.KubeletExtraArgs,
+		hatchling.Sysctls,
+	})
+}
+
+// joinSorted joins the entries of a map in the order of their keys.
+func joinSorted(entries map[string]string, separator, delimiter string) string {
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, key+separator+entries[key])
+	}
+	return strings.Join(pairs, delimiter)
+}
@@ -0,0 +1,111 @@
+// Copyright 2020 Alexander Eldeib
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS
+// OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+// CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+// TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package controllers
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	capbkv1alpha3 "sigs.k8s.io/cluster-api/bootstrap/kubeadm/api/v1alpha3"
+	kubeadmv1beta1 "sigs.k8s.io/cluster-api/bootstrap/kubeadm/types/v1beta1"
+
+	infrav1alpha1 "github.com/alexeldeib/bale/api/v1alpha1"
+)
+
+func TestSetNodeConfig(t *testing.T) {
+	taints := []corev1.Taint{{Key: "dedicated", Value: "batch", Effect: corev1.TaintEffectNoSchedule}}
+
+	cases := map[string]struct {
+		hatchling infrav1alpha1.HatchlingSpec
+		wantErr   bool
+		wantArgs  map[string]string
+		wantFiles int
+	}{
+		"none": {
+			hatchling: infrav1alpha1.HatchlingSpec{Name: "default"},
+			wantArgs:  map[string]string{"cloud-provider": "azure"},
+		},
+		"labels taints and args": {
+			hatchling: infrav1alpha1.HatchlingSpec{
+				Name:             "batch",
+				NodeLabels:       map[string]string{"pool": "batch", "node.kubernetes.io/tier": "spot"},
+				Taints:           taints,
+				KubeletExtraArgs: map[string]string{"max-pods": "50"},
+			},
+			wantArgs: map[string]string{
+				"cloud-provider": "azure",
+				"max-pods":       "50",
+				"node-labels":    "node.kubernetes.io/tier=spot,pool=batch",
+			},
+		},
+		"sysctls": {
+			hatchling: infrav1alpha1.HatchlingSpec{
+				Name:    "tuned",
+				Sysctls: map[string]string{"vm.max_map_count": "262144", "net.core.somaxconn": "1024"},
+			},
+			wantArgs:  map[string]string{"cloud-provider": "azure"},
+			wantFiles: 1,
+		},
+		"reserved kubelet argument": {
+			hatchling: infrav1alpha1.HatchlingSpec{Name: "bad", KubeletExtraArgs: map[string]string{"cloud-provider": "external"}},
+			wantErr:   true,
+		},
+		"restricted node label": {
+			hatchling: infrav1alpha1.HatchlingSpec{Name: "bad", NodeLabels: map[string]string{"node-role.kubernetes.io/worker": ""}},
+			wantErr:   true,
+		},
+		"multi-line sysctl": {
+			hatchling: infrav1alpha1.HatchlingSpec{Name: "bad", Sysctls: map[string]string{"vm.swappiness": "1\nkernel.panic = 1"}},
+			wantErr:   true,
+		},
+	}
+
+	for name, tc := range cases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			config := &capbkv1alpha3.KubeadmConfigSpec{
+				JoinConfiguration: &kubeadmv1beta1.JoinConfiguration{
+					NodeRegistration: kubeadmv1beta1.NodeRegistrationOptions{
+						KubeletExtraArgs: map[string]string{"cloud-provider": "azure"},
+					},
+				},
+			}
+
+			err := setNodeConfig(config, &tc.hatchling)
+			if tc.wantErr {
+				g.Expect(err).To(HaveOccurred())
+				return
+			}
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(config.JoinConfiguration.NodeRegistration.KubeletExtraArgs).To(Equal(tc.wantArgs))
+			g.Expect(config.JoinConfiguration.NodeRegistration.Taints).To(Equal(tc.hatchling.Taints))
+			g.Expect(config.Files).To(HaveLen(tc.wantFiles))
+			if tc.wantFiles > 0 {
+				g.Expect(config.Files[0].Content).To(Equal("net.core.somaxconn = 1024\nvm.max_map_count = 262144\n"))
+				g.Expect(config.PreKubeadmCommands).To(ContainElement("sysctl --system"))
+			}
+		})
+	}
+}
@@ -25,7 +25,6 @@ import (
 	"crypto/rand"
 	"encoding/base64"
 	"fmt"
-	mrand "math/rand"
 )
 
 const numeric = "0123456789"
@@ -47,25 +46,35 @@ func GenerateRandomString(n int) string {
 }
 
 func RandomLowercaseString(n int) string {
-	b := make([]byte, n)
-	for i := range b {
-		b[i] = safeLowerBytes[mrand.Intn(len(safeLowerBytes))]
-	}
-	return string(b)
+	return randomString(n, safeLowerBytes)
 }
 
 func GenerateSafeRandomString(n int) string {
-	b := make([]byte, n)
-	for i := range b {
-		b[i] = safeBytes[mrand.Intn(len(safeBytes))]
-	}
-	return string(b)
+	return randomString(n, safeBytes)
 }
 
 func RandomLowerAlpha(n int) string {
-	b := make([]byte, n)
-	for i := range b {
-		b[i] = lower[mrand.Intn(len(lower))]
+	return randomString(n, lower)
+}
+
+// randomString returns a string of length n drawn uniformly from charset
+// using crypto/rand, panicking on failure. Unlike an unseeded math/rand, the
+// sequence differs on every start of the manager.
+func randomString(n int, charset string) string {
+	// Reject bytes beyond the largest multiple of len(charset) to avoid
+	// biasing towards the start of charset.
+	limit := 256 - 256%len(charset)
+	out := make([]byte, 0, n)
+	buf := make([]byte, n)
+	for len(out) < n {
+		if _, err := rand.Read(buf); err != nil {
+			panic(fmt.Sprintf("failed to read random bytes: %v", err))
+		}
+		for _, b := range buf {
+			if int(b) < limit && len(out) < n {
+				out = append(out, charset[int(b)%len(charset)])
+			}
+		}
 	}
-	return string(b)
+	return string(out)
 }
@@ -0,0 +1,248 @@
+// Copyright 2020 Alexander Eldeib
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS
+// OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+// CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+// TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// defaultRevisionHistoryLimit is used when an object doesn't set revisionHistoryLimit.
+const defaultRevisionHistoryLimit = 10
+
+// revisionOwner is an object whose history is recorded as ControllerRevisions.
+type revisionOwner interface {
+	metav1.Object
+	runtime.Object
+}
+
+// revisionHistory reads and records the ControllerRevisions of an object.
+// They are controlled by the object and carry a label holding its name.
+type revisionHistory struct {
+	client client.Client
+	scheme *runtime.Scheme
+	owner  revisionOwner
+	label  string
+}
+
+// record records data as the current revision under the given name and
+// prunes revisions beyond the history limit. Names are expected to be
+// derived from a hash of the data. Should the name hold different data,
+// like a Deployment's collision count the name is suffixed with a counter
+// until it holds the same data or is free.
+func (h *revisionHistory) record(
+	ctx context.Context,
+	log logr.Logger,
+	name string,
+	data interface{},
+	limit int,
+) (*appsv1.ControllerRevision, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode revision: %w", err)
+	}
+
+	revisions, err := h.list(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var latest int64
+	if len(revisions) > 0 {
+		latest = revisions[len(revisions)-1].Revision
+	}
+
+	byName := make(map[string]*appsv1.ControllerRevision, len(revisions))
+	for i := range revisions {
+		byName[revisions[i].Name] = &revisions[i]
+	}
+
+	var current *appsv1.ControllerRevision
+	created := false
+	for collisions := 0; current == nil; collisions++ {
+		candidate := name
+		if collisions > 0 {
+			candidate = fmt.Sprintf("%s-%d", name, collisions)
+		}
+
+		if existing, ok := byName[candidate]; ok {
+			if !equalRevisionData(existing.Data.Raw, raw) {
+				log.Info("revision name collision", "revision", candidate)
+				continue
+			}
+			current = existing
+			break
+		}
+
+		current, err = h.create(ctx, candidate, latest+1, raw)
+		if errors.Is(err, errRevisionCollision) {
+			log.Info("revision name collision", "revision", candidate)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		created = true
+	}
+
+	switch {
+	case created:
+		log.Info("recorded new revision", "revision", current.Revision)
+	case current.Revision != latest:
+		// Data equal to an older revision is live again, so it becomes
+		// the newest revision rather than creating a duplicate.
+		patch := client.MergeFrom(current.DeepCopy())
+		current.Revision = latest + 1
+		if err := h.client.Patch(ctx, current, patch); err != nil {
+			return nil, fmt.Errorf("failed to update revision %s: %w", current.Name, err)
+		}
+		log.Info("reverted to previous revision", "revision", current.Revision)
+	}
+
+	history := make([]appsv1.ControllerRevision, 0, len(revisions))
+	for i := range revisions {
+		if revisions[i].Name != current.Name {
+			history = append(history, revisions[i])
+		}
+	}
+
+	for i := 0; i < len(history)-limit; i++ {
+		if err := h.client.Delete(ctx, &history[i]); client.IgnoreNotFound(err) != nil {
+			return nil, fmt.Errorf("failed to prune revision %s: %w", history[i].Name, err)
+		}
+	}
+
+	return current, nil
+}
+
+// find returns the revision with the given number, or the one preceding
+// the current revision if the number is zero.
+func (h *revisionHistory) find(ctx context.Context, value string) (*appsv1.ControllerRevision, error) {
+	number, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || number < 0 {
+		return nil, fmt.Errorf("invalid revision %q", value)
+	}
+
+	revisions, err := h.list(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if number == 0 {
+		if len(revisions) < 2 {
+			return nil, fmt.Errorf("no previous revision")
+		}
+		return &revisions[len(revisions)-2], nil
+	}
+
+	for i := range revisions {
+		if revisions[i].Revision == number {
+			return &revisions[i], nil
+		}
+	}
+	return nil, fmt.Errorf("revision %d not found", number)
+}
+
+// list returns the revisions controlled by the owner, oldest first.
+func (h *revisionHistory) list(ctx context.Context) ([]appsv1.ControllerRevision, error) {
+	var list appsv1.ControllerRevisionList
+	if err := h.client.List(ctx, &list, client.InNamespace(h.owner.GetNamespace()), client.MatchingLabels{h.label: h.owner.GetName()}); err != nil {
+		return nil, fmt.Errorf("failed to list revisions: %w", err)
+	}
+
+	revisions := make([]appsv1.ControllerRevision, 0, len(list.Items))
+	for i := range list.Items {
+		if owner := metav1.GetControllerOf(&list.Items[i]); owner != nil && owner.UID == h.owner.GetUID() {
+			revisions = append(revisions, list.Items[i])
+		}
+	}
+
+	sort.SliceStable(revisions, func(i, j int) bool {
+		return revisions[i].Revision < revisions[j].Revision
+	})
+	return revisions, nil
+}
+
+// errRevisionCollision means a revision exists under a name with different
+// data, or another owner.
+var errRevisionCollision = errors.New("revision exists with different data")
+
+// equalRevisionData reports whether two encodings of revisions hold the
+// same data.
+func equalRevisionData(a, b []byte) bool {
+	var aValue, bValue interface{}
+	if json.Unmarshal(a, &aValue) != nil || json.Unmarshal(b, &bValue) != nil {
+		return bytes.Equal(a, b)
+	}
+	return reflect.DeepEqual(aValue, bValue)
+}
+
+func (h *revisionHistory) create(ctx context.Context, name string, number int64, raw []byte) (*appsv1.ControllerRevision, error) {
+	revision := &appsv1.ControllerRevision{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: h.owner.GetNamespace(),
+			Labels: map[string]string{
+				h.label: h.owner.GetName(),
+			},
+		},
+		Data:     runtime.RawExtension{Raw: raw},
+		Revision: number,
+	}
+
+	if err := controllerutil.SetControllerReference(h.owner, revision, h.scheme); err != nil {
+		return nil, err
+	}
+
+	if err := h.client.Create(ctx, revision); err != nil {
+		if !apierrors.IsAlreadyExists(err) {
+			return nil, fmt.Errorf("failed to create revision %s: %w", name, err)
+		}
+		// The cache may lag behind a revision we created earlier.
+		if err := h.client.Get(ctx, types.NamespacedName{Namespace: h.owner.GetNamespace(), Name: name}, revision); err != nil {
+			return nil, fmt.Errorf("failed to get revision %s: %w", name, err)
+		}
+		// Revisions left behind by a previous owner of the same name
+		// collide too.
+		owner := metav1.GetControllerOf(revision)
+		if owner == nil || owner.UID != h.owner.GetUID() || !equalRevisionData(revision.Data.Raw, raw) {
+			return nil, errRevisionCollision
+		}
+	}
+
+	return revision, nil
+}
@@ -0,0 +1,75 @@
+// Copyright 2020 Alexander Eldeib
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS
+// OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+// CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+// TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	infrav1alpha1 "github.com/alexeldeib/bale/api/v1alpha1"
+)
+
+func TestRevisionHistoryCollision(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	scheme := runtime.NewScheme()
+	g.Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+	g.Expect(infrav1alpha1.AddToScheme(scheme)).To(Succeed())
+
+	bale := &infrav1alpha1.Bale{
+		ObjectMeta: metav1.ObjectMeta{Name: "bale", Namespace: "default", UID: "bale-uid"},
+	}
+	history := &revisionHistory{
+		client: fake.NewFakeClientWithScheme(scheme, bale),
+		scheme: scheme,
+		owner:  bale,
+		label:  infrav1alpha1.BaleNameLabel,
+	}
+
+	first, err := history.record(ctx, ctrl.Log, "bale-hash", "first", defaultRevisionHistoryLimit)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(first.Name).To(Equal("bale-hash"))
+
+	// Different data hashing to the same name is recorded under another.
+	second, err := history.record(ctx, ctrl.Log, "bale-hash", "second", defaultRevisionHistoryLimit)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(second.Name).To(Equal("bale-hash-1"))
+	g.Expect(second.Revision).To(Equal(int64(2)))
+
+	again, err := history.record(ctx, ctrl.Log, "bale-hash", "first", defaultRevisionHistoryLimit)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(again.Name).To(Equal("bale-hash"))
+	g.Expect(again.Revision).To(Equal(int64(3)))
+
+	again, err = history.record(ctx, ctrl.Log, "bale-hash", "second", defaultRevisionHistoryLimit)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(again.Name).To(Equal("bale-hash-1"))
+	g.Expect(again.Revision).To(Equal(int64(4)))
+}
@@ -34,18 +34,20 @@ func getCluster(namespace, name, location string) *capiv1alpha3.Cluster {
 				APIVersion: "controlplane.cluster.x-k8s.io/v1alpha3",
 				Kind:       "KubeadmControlPlane",
 				Name:       name,
+				Namespace:  namespace,
 			},
 			InfrastructureRef: &corev1.ObjectReference{
 				APIVersion: "infrastructure.cluster.x-k8s.io/v1alpha3",
 				Kind:       "AzureCluster",
 				Name:       name,
+				Namespace:  namespace,
 			},
 		},
 	}
 }
 
-func getKubeadmConfigTemplate(namespace, name, location string, settings map[string]string) (*capbkv1alpha3.KubeadmConfigTemplate, error) {
-	data, err := getCloudProviderConfig(name, location, settings)
+func getKubeadmConfigTemplate(namespace, name, cluster, location, resourceGroup string, settings map[string]string) (*capbkv1alpha3.KubeadmConfigTemplate, error) {
+	data, err := getCloudProviderConfig(cluster, location, resourceGroup, settings)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate cloud provider config")
 	}
@@ -127,8 +129,12 @@ func getKubeadmConfigTemplate(namespace, name, location string, settings map[str
 	}, nil
 }
 
-func getKubeadmControlPlane(namespace, name, location, version string, replicas int32, settings map[string]string) (*kcpv1alpha3.KubeadmControlPlane, error) {
-	kubeadmConfigTemplate, err := getKubeadmConfigTemplate(namespace, name, location, settings)
+func getKubeadmControlPlane(
+	namespace, name, machineTemplate, location, resourceGroup, version string,
+	replicas int32,
+	settings map[string]string,
+) (*kcpv1alpha3.KubeadmControlPlane, error) {
+	kubeadmConfigTemplate, err := getKubeadmConfigTemplate(namespace, name, name, location, resourceGroup, settings)
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed to generate kubeadm config template for kubeadm control plane")
 	}
@@ -144,7 +150,8 @@ func getKubeadmControlPlane(namespace, name, location, version string, replicas
 			InfrastructureTemplate: corev1.ObjectReference{
 				APIVersion: "infrastructure.cluster.x-k8s.io/v1alpha3",
 				Kind:       "AzureMachineTemplate",
-				Name:       name,
+				Name:       machineTemplate,
+				Namespace:  namespace,
 			},
 			KubeadmConfigSpec: kubeadmConfigTemplate.Spec.Template.Spec,
 		},
@@ -152,29 +159,41 @@ func getKubeadmControlPlane(namespace, name, location, version string, replicas
 	return controlplane, nil
 }
 
-func getMachineDeployment(namespace, name, version string, replicas int32) *capiv1alpha3.MachineDeployment {
+func getMachineDeployment(
+	namespace, name, cluster, bootstrapTemplate, machineTemplate, version string,
+	replicas int32,
+	labels map[string]string,
+) *capiv1alpha3.MachineDeployment {
 	return &capiv1alpha3.MachineDeployment{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      name,
 			Namespace: namespace,
+			Labels:    labels,
 		},
 		Spec: capiv1alpha3.MachineDeploymentSpec{
-			ClusterName: name,
+			ClusterName: cluster,
 			Replicas:    to.Int32Ptr(replicas),
-			Selector:    metav1.LabelSelector{},
+			Selector: metav1.LabelSelector{
+				MatchLabels: labels,
+			},
 			Template: capiv1alpha3.MachineTemplateSpec{
+				ObjectMeta: capiv1alpha3.ObjectMeta{
+					Labels: labels,
+				},
 				Spec: capiv1alpha3.MachineSpec{
-					ClusterName: name,
+					ClusterName: cluster,
 					Bootstrap: capiv1alpha3.Bootstrap{
 						ConfigRef: &v1.ObjectReference{
 							APIVersion: "bootstrap.cluster.x-k8s.io/v1alpha3",
-							Name:       name,
+							Name:       bootstrapTemplate,
+							Namespace:  namespace,
 							Kind:       "KubeadmConfigTemplate",
 						},
 					},
 					InfrastructureRef: v1.ObjectReference{
 						APIVersion: "infrastructure.cluster.x-k8s.io/v1alpha3",
-						Name:       name,
+						Name:       machineTemplate,
+						Namespace:  namespace,
 						Kind:       "AzureMachineTemplate",
 					},
 					Version: to.StringPtr(version),
@@ -208,7 +227,7 @@ func getMachineTemplate(namespace, name, location, vmSize string, osDiskSizeGB i
 	}
 }
 
-func getAzureCluster(namespace, name, location string) *capzv1alpha3.AzureCluster {
+func getAzureCluster(namespace, name, location, resourceGroup, subscriptionID string) *capzv1alpha3.AzureCluster {
 	return &capzv1alpha3.AzureCluster{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      name,
@@ -221,7 +240,8 @@ func getAzureCluster(namespace, name, location string) *capzv1alpha3.AzureCluste
 					Name: fmt.Sprintf("%s-vnet", name),
 				},
 			},
-			ResourceGroup: name,
+			ResourceGroup:  resourceGroup,
+			SubscriptionID: subscriptionID,
 		},
 	}
 }
@@ -247,19 +267,19 @@ type CloudProviderConfig struct {
 	UseInstanceMetadata          bool   `json:"useInstanceMetadata"`
 }
 
-func getCloudProviderConfig(cluster, location string, settings map[string]string) (string, error) {
+func getCloudProviderConfig(cluster, location, resourceGroup string, settings map[string]string) (string, error) {
 	config := &CloudProviderConfig{
 		Cloud:                        settings[auth.EnvironmentName],
 		TenantID:                     settings[auth.TenantID],
 		SubscriptionID:               settings[auth.SubscriptionID],
 		AadClientID:                  settings[auth.ClientID],
 		AadClientSecret:              settings[auth.ClientSecret],
-		ResourceGroup:                cluster,
+		ResourceGroup:                resourceGroup,
 		SecurityGroupName:            fmt.Sprintf("%s-node-nsg", cluster),
 		Location:                     location,
 		VMType:                       "standard",
 		VnetName:                     fmt.Sprintf("%s-vnet", cluster),
-		VnetResourceGroup:            cluster,
+		VnetResourceGroup:            resourceGroup,
 		SubnetName:                   fmt.Sprintf("%s-node-subnet", cluster),
 		RouteTableName:               fmt.Sprintf("%s-node-routetable", cluster),
 		LoadBalancerSku:              "standard",
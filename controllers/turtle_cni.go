@@ -0,0 +1,140 @@
+// Copyright 2020 Alexander Eldeib
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS
+// OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+// CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+// TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	capiv1alpha3 "sigs.k8s.io/cluster-api/api/v1alpha3"
+	kcpv1alpha3 "sigs.k8s.io/cluster-api/controlplane/kubeadm/api/v1alpha3"
+
+	infrav1alpha1 "github.com/alexeldeib/bale/api/v1alpha1"
+	"github.com/alexeldeib/bale/pkg/cni"
+)
+
+// cniPlugin returns the CNI plugin of a turtle, defaulting to calico for
+// turtles created before the field existed.
+func cniPlugin(turtle *infrav1alpha1.Turtle) infrav1alpha1.CNIPlugin {
+	if turtle.Spec.Network.CNI == "" {
+		return infrav1alpha1.CNICalico
+	}
+	return turtle.Spec.Network.CNI
+}
+
+// cniVersion returns the version of the CNI plugin to install. Installed
+// plugins stay at their version until the spec pins another one, so
+// bundling a newer manifest never upgrades existing clusters by itself.
+func cniVersion(turtle *infrav1alpha1.Turtle) string {
+	switch {
+	case turtle.Spec.Network.CNIVersion != "":
+		return turtle.Spec.Network.CNIVersion
+	case turtle.Status.CNI != nil && turtle.Status.CNI.Plugin == cniPlugin(turtle):
+		return turtle.Status.CNI.Version
+	default:
+		return cni.Latest(cniPlugin(turtle))
+	}
+}
+
+// podCIDR returns the CIDR pod addresses of a cluster are allocated from.
+func podCIDR(cluster *capiv1alpha3.Cluster) (string, error) {
+	network := cluster.Spec.ClusterNetwork
+	if network == nil || network.Pods == nil || len(network.Pods.CIDRBlocks) == 0 {
+		return "", fmt.Errorf("cluster %s/%s has no pod cidr", cluster.Namespace, cluster.Name)
+	}
+	return network.Pods.CIDRBlocks[0], nil
+}
+
+// setNodeCIDRAllocation makes the controller manager allocate pod CIDRs to
+// nodes, for plugins which don't manage addresses themselves. Routes are
+// left to the plugin.
+func setNodeCIDRAllocation(controlPlane *kcpv1alpha3.KubeadmControlPlane, cidr string) {
+	controllerManager := &controlPlane.Spec.KubeadmConfigSpec.ClusterConfiguration.ControllerManager
+	if controllerManager.ExtraArgs == nil {
+		controllerManager.ExtraArgs = map[string]string{}
+	}
+	controllerManager.ExtraArgs["allocate-node-cidrs"] = "true"
+	controllerManager.ExtraArgs["cluster-cidr"] = cidr
+	controllerManager.ExtraArgs["configure-cloud-routes"] = "false"
+}
+
+// reconcileCNI applies the bundled manifest of the CNI plugin to the remote
+// cluster. The plugin can't be switched once installed, as that requires
+// draining every node; upgrades happen when the spec pins a new version.
+func (r *TurtleReconciler) reconcileCNI(ctx context.Context, turtle *infrav1alpha1.Turtle) error {
+	plugin := cniPlugin(turtle)
+	installed := turtle.Status.CNI
+	conditions := &turtle.Status.Conditions
+
+	if plugin == infrav1alpha1.CNINone {
+		setCondition(conditions, infrav1alpha1.TurtleCNIInstalledCondition, corev1.ConditionFalse, "NotManaged",
+			"no cni plugin is installed by bale")
+		return nil
+	}
+
+	if installed != nil && installed.Plugin != plugin {
+		setCondition(conditions, infrav1alpha1.TurtleCNIInstalledCondition, corev1.ConditionFalse, "PluginChangeUnsupported",
+			fmt.Sprintf("cni plugin %s is installed, switching to %s is not supported", installed.Plugin, plugin))
+		return nil
+	}
+
+	cluster := &capiv1alpha3.Cluster{}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: turtle.Namespace, Name: turtle.Name}, cluster); err != nil {
+		return fmt.Errorf("failed to get cluster: %w", err)
+	}
+
+	cidr, err := podCIDR(cluster)
+	if err != nil {
+		return err
+	}
+
+	version := cniVersion(turtle)
+	manifest, err := cni.Render(plugin, version, cni.Values{PodCIDR: cidr})
+	if err != nil {
+		setCondition(conditions, infrav1alpha1.TurtleCNIInstalledCondition, corev1.ConditionFalse, "RenderFailed", err.Error())
+		return err
+	}
+
+	remoteClient, err := newRemoteClient(ctx, r.Client, r.Tracker, turtle)
+	if err != nil {
+		return err
+	}
+
+	if installed != nil && installed.Version != version {
+		r.Log.Info("upgrading cni plugin", "turtle", turtle.Name, "plugin", plugin, "from", installed.Version, "to", version)
+	}
+
+	results, err := remoteClient.Apply(ctx, manifest)
+	if err == nil {
+		err = results.Err()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to apply %s %s: %w", plugin, version, err)
+	}
+
+	turtle.Status.CNI = &infrav1alpha1.CNIStatus{Plugin: plugin, Version: version}
+	setCondition(conditions, infrav1alpha1.TurtleCNIInstalledCondition, corev1.ConditionTrue, "Applied",
+		fmt.Sprintf("%s %s is applied", plugin, version))
+	return nil
+}
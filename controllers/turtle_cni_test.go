@@ -0,0 +1,88 @@
+// Copyright 2020 Alexander Eldeib
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS
+// OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+// CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+// TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package controllers
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	infrav1alpha1 "github.com/alexeldeib/bale/api/v1alpha1"
+	"github.com/alexeldeib/bale/pkg/cni"
+	"github.com/alexeldeib/bale/pkg/remote"
+)
+
+func TestCNIVersion(t *testing.T) {
+	cases := map[string]struct {
+		network infrav1alpha1.NetworkSpec
+		status  *infrav1alpha1.CNIStatus
+		want    string
+	}{
+		"new cluster gets the newest version": {
+			want: cni.Latest(cni.Calico),
+		},
+		"installed version is kept": {
+			status: &infrav1alpha1.CNIStatus{Plugin: infrav1alpha1.CNICalico, Version: "v3.0.0"},
+			want:   "v3.0.0",
+		},
+		"pinned version upgrades": {
+			network: infrav1alpha1.NetworkSpec{CNIVersion: "v3.12.1"},
+			status:  &infrav1alpha1.CNIStatus{Plugin: infrav1alpha1.CNICalico, Version: "v3.0.0"},
+			want:    "v3.12.1",
+		},
+		"version of another plugin is ignored": {
+			network: infrav1alpha1.NetworkSpec{CNI: infrav1alpha1.CNIFlannel},
+			status:  &infrav1alpha1.CNIStatus{Plugin: infrav1alpha1.CNICalico, Version: "v3.12.1"},
+			want:    cni.Latest(cni.Flannel),
+		},
+	}
+
+	for name, tc := range cases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			g := NewWithT(t)
+			turtle := &infrav1alpha1.Turtle{
+				Spec:   infrav1alpha1.TurtleSpec{Network: tc.network},
+				Status: infrav1alpha1.TurtleStatus{CNI: tc.status},
+			}
+			g.Expect(cniVersion(turtle)).To(Equal(tc.want))
+		})
+	}
+}
+
+func TestRenderBundledCNI(t *testing.T) {
+	for _, plugin := range []cni.Plugin{cni.Calico, cni.Cilium, cni.Flannel} {
+		for _, version := range cni.Versions(plugin) {
+			plugin, version := plugin, version
+			t.Run(string(plugin)+"/"+version, func(t *testing.T) {
+				g := NewWithT(t)
+				manifest, err := cni.Render(plugin, version, cni.Values{PodCIDR: "10.244.0.0/16"})
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(string(manifest)).To(ContainSubstring("10.244.0.0/16"))
+
+				objects, err := remote.Decode(manifest)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(objects).NotTo(BeEmpty())
+			})
+		}
+	}
+}
@@ -25,8 +25,10 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/Azure/go-autorest/autorest/azure/auth"
 	"github.com/go-logr/logr"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
@@ -37,18 +39,27 @@ import (
 	"sigs.k8s.io/cluster-api/util/secret"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 
 	infrav1alpha1 "github.com/alexeldeib/bale/api/v1alpha1"
+	"github.com/alexeldeib/bale/pkg/cni"
 	"github.com/alexeldeib/bale/pkg/remote"
 )
 
 // TurtleReconciler reconciles a Turtle object
 type TurtleReconciler struct {
 	client.Client
-	Log           logr.Logger
-	Scheme        *runtime.Scheme
-	AzureSettings map[string]string
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+	// DefaultCredentials is the Azure credentials secret used by turtles
+	// without a credentialsRef. Defaults to bale-system/bale-manager-credentials.
+	DefaultCredentials types.NamespacedName
+	// Tracker watches the workload clusters of turtles, so changes to the
+	// objects the controller manages there are repaired. Optional.
+	Tracker *remote.ClusterCacheTracker
+
+	controller controller.Controller
 }
 
 // +kubebuilder:rbac:groups=infra.alexeldeib.xyz,resources=turtles,verbs=get;list;watch;create;update;patch;delete
@@ -59,12 +70,13 @@ type TurtleReconciler struct {
 // +kubebuilder:rbac:groups=infrastructure.cluster.x-k8s.io,resources=azureclusters/status;azuremachinetemplates/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=bootstrap.cluster.x-k8s.io,resources=kubeadmconfigs;kubeadmconfigs/status;kubeadmconfigtemplates;kubeadmconfigtemplates/status,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=cluster.x-k8s.io,resources=machinedeployments;machinedeployments/status,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=cluster.x-k8s.io,resources=machines;machinesets,verbs=get;list;watch
 // +kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;patch
 
 // kubebuilder:rbac:groups=infrastructure.cluster.x-k8s.io;bootstrap.cluster.x-k8s.io;controlplane.cluster.x-k8s.io,resources=*,verbs=get;list;watch;create;update;patch;delete
 
 func (r *TurtleReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
+	c, err := ctrl.NewControllerManagedBy(mgr).
 		For(&infrav1alpha1.Turtle{}).
 		Owns(&capiv1alpha3.Cluster{}).
 		Owns(&kcpv1alpha3.KubeadmControlPlane{}).
@@ -72,7 +84,13 @@ func (r *TurtleReconciler) SetupWithManager(mgr ctrl.Manager) error {
 		Owns(&capbkv1alpha3.KubeadmConfigTemplate{}).
 		Owns(&capiv1alpha3.MachineDeployment{}).
 		Owns(&capzv1alpha3.AzureMachineTemplate{}).
-		Complete(r)
+		Build(r)
+	if err != nil {
+		return err
+	}
+
+	r.controller = c
+	return nil
 }
 
 func (r *TurtleReconciler) Reconcile(req ctrl.Request) (_ ctrl.Result, reterr error) {
@@ -81,51 +99,149 @@ func (r *TurtleReconciler) Reconcile(req ctrl.Request) (_ ctrl.Result, reterr er
 
 	var turtle infrav1alpha1.Turtle
 	if err := r.Get(ctx, req.NamespacedName, &turtle); err != nil {
+		if apierrors.IsNotFound(err) && r.Tracker != nil {
+			r.Tracker.Stop(req.NamespacedName)
+		}
 		log.Error(err, "unable to fetch")
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 
-	reconcilers := []func(context.Context, *infrav1alpha1.Turtle) error{
-		r.reconcileCluster,
-		r.reconcileKubeadmConfigTemplate,
-		r.reconcileKubeadmControlPlane,
-		r.reconcileMachineTemplates,
-		r.reconcileMachineDeployments,
-		r.reconcileAzureCluster,
-		r.reconcileExternal,
+	defer func() {
+		// The turtle is gone once teardown removes the finalizer.
+		if err := client.IgnoreNotFound(r.updateStatus(ctx, &turtle)); err != nil && reterr == nil {
+			log.Error(err, "failed to update turtle status")
+			reterr = err
+		}
+	}()
+
+	// Deleting a turtle overrides pausing it, or it would never lose its
+	// finalizer.
+	if !turtle.DeletionTimestamp.IsZero() {
+		return r.reconcileDelete(ctx, log, &turtle)
+	}
+
+	if turtle.Spec.Paused {
+		log.Info("turtle is paused, only propagating pause to cluster")
+		setCondition(&turtle.Status.Conditions, infrav1alpha1.PausedCondition, corev1.ConditionTrue, "Paused",
+			"cluster api objects and the remote cluster are not changed")
+		return ctrl.Result{}, r.pauseCluster(ctx, &turtle)
 	}
+	setCondition(&turtle.Status.Conditions, infrav1alpha1.PausedCondition, corev1.ConditionFalse, "", "")
 
-	for _, reconcileFn := range reconcilers {
-		reconcileFn := reconcileFn
-		if err := reconcileFn(ctx, &turtle); err != nil {
-			return ctrl.Result{}, fmt.Errorf("failed to execute reconcile function: %w", err)
+	if !hasFinalizer(&turtle) {
+		controllerutil.AddFinalizer(&turtle, infrav1alpha1.TurtleFinalizer)
+		if err := r.Update(ctx, &turtle); err != nil {
+			return ctrl.Result{}, fmt.Errorf("failed to add finalizer: %w", err)
 		}
 	}
 
-	defer func() {
-		if err := r.Status().Update(ctx, &turtle); err != nil && reterr == nil {
-			log.Error(err, "failed to update turtle status")
-			reterr = err
+	result, err := runSteps(ctx, log, &turtle, r.reconcileSteps(&turtle))
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to reconcile turtle: %w", err)
+	}
+
+	// Readiness is evaluated by updateStatus on the way out, so poll until
+	// the last observation was ready and no upgrade is left to roll out.
+	if !isConditionTrue(turtle.Status.Conditions, infrav1alpha1.TurtleReadyCondition) ||
+		isConditionTrue(turtle.Status.Conditions, infrav1alpha1.TurtleUpgradingCondition) {
+		if result.RequeueAfter == 0 || result.RequeueAfter > statusRequeueInterval {
+			result.RequeueAfter = statusRequeueInterval
 		}
-	}()
+	}
 
-	return ctrl.Result{}, nil
+	return result, nil
 }
 
-func (r *TurtleReconciler) reconcileCluster(ctx context.Context, turtle *infrav1alpha1.Turtle) error {
-	template := getCluster(turtle.Namespace, turtle.Name, turtle.Spec.Location)
+// reconcileSteps returns the steps reconciling a turtle. Objects for
+// separate hatchlings are independent of each other, and only wait on the
+// objects they reference and on the upgrade plan.
+func (r *TurtleReconciler) reconcileSteps(turtle *infrav1alpha1.Turtle) []reconcileStep {
+	var plan *upgradePlan
+	steps := []reconcileStep{
+		{
+			name:      "upgrade-plan",
+			exclusive: true,
+			fn: func(ctx context.Context, turtle *infrav1alpha1.Turtle) (err error) {
+				plan, err = r.planUpgrade(ctx, turtle)
+				return err
+			},
+		},
+		{name: "cluster", fn: r.reconcileCluster},
+		{name: "azure-cluster", fn: r.reconcileAzureCluster},
+		{
+			name: "control-plane-machine-template",
+			fn: func(ctx context.Context, turtle *infrav1alpha1.Turtle) error {
+				return r.reconcileMachineTemplate(ctx, turtle, controlPlaneMachineTemplate(turtle), nil)
+			},
+		},
+		{
+			name:  "control-plane",
+			after: []string{"upgrade-plan", "control-plane-machine-template"},
+			fn: func(ctx context.Context, turtle *infrav1alpha1.Turtle) error {
+				return r.reconcileKubeadmControlPlane(ctx, turtle, plan)
+			},
+		},
+	}
 
-	// TODO(ace): Verify -- I believe this is necessary because CreateOrUpdate does a get
-	// into the object it receives, so we need to save a copy and capture it
-	// into the closure context.
-	want := template.DeepCopy()
+	var machineDeployments []string
+	for i := range turtle.Spec.Hatchlings {
+		hatchling := &turtle.Spec.Hatchlings[i]
+		bootstrapTemplate := fmt.Sprintf("hatchling/%s/bootstrap-template", hatchling.Name)
+		machineTemplate := fmt.Sprintf("hatchling/%s/machine-template", hatchling.Name)
+		machineDeployment := fmt.Sprintf("hatchling/%s/machine-deployment", hatchling.Name)
+		machineDeployments = append(machineDeployments, machineDeployment)
+
+		steps = append(steps,
+			reconcileStep{
+				name: bootstrapTemplate,
+				fn: func(ctx context.Context, turtle *infrav1alpha1.Turtle) error {
+					return r.reconcileKubeadmConfigTemplate(ctx, turtle, hatchling)
+				},
+			},
+			reconcileStep{
+				name:  machineTemplate,
+				after: []string{"upgrade-plan"},
+				fn: func(ctx context.Context, turtle *infrav1alpha1.Turtle) error {
+					// Templates of hatchlings the plan holds back would
+					// be collected as unreferenced until their machine
+					// deployment is created.
+					if _, ok := plan.hatchlings[hatchling.Name]; !ok {
+						return nil
+					}
+					return r.reconcileMachineTemplate(ctx, turtle, hatchlingMachineTemplate(turtle, hatchling), hatchling)
+				},
+			},
+			reconcileStep{
+				name:  machineDeployment,
+				after: []string{"upgrade-plan", bootstrapTemplate, machineTemplate},
+				fn: func(ctx context.Context, turtle *infrav1alpha1.Turtle) error {
+					return r.reconcileMachineDeployment(ctx, turtle, hatchling, plan)
+				},
+			},
+		)
+	}
 
-	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, template, func() error {
-		if err := controllerutil.SetControllerReference(turtle, want, r.Scheme); err != nil {
-			return err
-		}
-		template = want
-		return nil
+	return append(steps,
+		reconcileStep{name: "prune-hatchlings", after: machineDeployments, exclusive: true, fn: r.pruneHatchlings},
+		reconcileStep{name: "machine-template-gc", after: []string{"control-plane", "prune-hatchlings"}, fn: r.garbageCollectMachineTemplates},
+		reconcileStep{name: "remote", after: []string{"cluster", "control-plane"}, fn: r.reconcileExternal},
+		reconcileStep{name: "cni", after: []string{"remote"}, exclusive: true, fn: r.reconcileCNI},
+		reconcileStep{name: "remote-watches", after: []string{"remote"}, fn: r.watchRemote},
+	)
+}
+
+func (r *TurtleReconciler) reconcileCluster(ctx context.Context, turtle *infrav1alpha1.Turtle) error {
+	want := getCluster(turtle.Namespace, turtle.Name, turtle.Spec.Location)
+	cluster := &capiv1alpha3.Cluster{ObjectMeta: want.ObjectMeta}
+
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, cluster, func() error {
+		// The control plane endpoint is filled in by Cluster API, so only
+		// the fields we own are copied over.
+		cluster.Spec.ClusterNetwork = want.Spec.ClusterNetwork
+		cluster.Spec.ControlPlaneRef = want.Spec.ControlPlaneRef
+		cluster.Spec.InfrastructureRef = want.Spec.InfrastructureRef
+		resumeCluster(cluster)
+		return r.setOwnership(turtle, cluster)
 	})
 
 	if err != nil {
@@ -135,28 +251,77 @@ func (r *TurtleReconciler) reconcileCluster(ctx context.Context, turtle *infrav1
 	return nil
 }
 
-func (r *TurtleReconciler) reconcileKubeadmControlPlane(ctx context.Context, turtle *infrav1alpha1.Turtle) error {
-	template, err := getKubeadmControlPlane(
+// pauseCluster annotates the cluster of a paused turtle, if it exists, so
+// Cluster API stops reconciling it and everything it owns. Clusters which
+// are already paused are left alone, so their pause isn't claimed.
+func (r *TurtleReconciler) pauseCluster(ctx context.Context, turtle *infrav1alpha1.Turtle) error {
+	cluster := &capiv1alpha3.Cluster{}
+	key := types.NamespacedName{Namespace: turtle.Namespace, Name: turtle.Name}
+	if err := r.Get(ctx, key, cluster); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+
+	if _, ok := cluster.Annotations[capiv1alpha3.PausedAnnotation]; ok {
+		return nil
+	}
+
+	patch := client.MergeFrom(cluster.DeepCopy())
+	if cluster.Annotations == nil {
+		cluster.Annotations = map[string]string{}
+	}
+	cluster.Annotations[capiv1alpha3.PausedAnnotation] = "true"
+	cluster.Annotations[infrav1alpha1.PausedByTurtleAnnotation] = "true"
+	if err := r.Patch(ctx, cluster, patch); err != nil {
+		return fmt.Errorf("failed to pause cluster: %w", err)
+	}
+
+	return nil
+}
+
+// resumeCluster removes the pause of a cluster if pauseCluster set it.
+func resumeCluster(cluster *capiv1alpha3.Cluster) {
+	if _, ok := cluster.Annotations[infrav1alpha1.PausedByTurtleAnnotation]; !ok {
+		return
+	}
+	delete(cluster.Annotations, capiv1alpha3.PausedAnnotation)
+	delete(cluster.Annotations, infrav1alpha1.PausedByTurtleAnnotation)
+}
+
+func (r *TurtleReconciler) reconcileKubeadmControlPlane(ctx context.Context, turtle *infrav1alpha1.Turtle, plan *upgradePlan) error {
+	settings, err := r.getAzureSettings(ctx, turtle)
+	if err != nil {
+		return err
+	}
+
+	want, err := getKubeadmControlPlane(
 		turtle.Namespace,
 		turtle.Name,
+		controlPlaneMachineTemplate(turtle).Name,
 		turtle.Spec.Location,
-		turtle.Spec.Version,
+		resourceGroup(turtle),
+		plan.controlPlane,
 		turtle.Spec.ControlPlaneReplicas,
-		r.AzureSettings,
+		settings,
 	)
 
 	if err != nil {
 		return fmt.Errorf("failed to get azure settings: %w", err)
 	}
 
-	// TODO(ace): Verify -- I believe this is necessary because CreateOrUpdate does a get
-	// into the object it receives, so we need to save a copy and capture it
-	// into the closure context.
-	want := template.DeepCopy()
+	// Like the rest of the kubeadm config, the controller manager flags only
+	// reach new control planes; the plugin of a turtle is immutable.
+	if cni.NeedsNodeCIDRs(cniPlugin(turtle)) {
+		cidr, err := podCIDR(getCluster(turtle.Namespace, turtle.Name, turtle.Spec.Location))
+		if err != nil {
+			return err
+		}
+		setNodeCIDRAllocation(want, cidr)
+	}
 
-	_, err = controllerutil.CreateOrUpdate(ctx, r.Client, template, func() error {
-		template = want
-		return nil
+	controlPlane := &kcpv1alpha3.KubeadmControlPlane{ObjectMeta: want.ObjectMeta}
+	_, err = controllerutil.CreateOrUpdate(ctx, r.Client, controlPlane, func() error {
+		setKubeadmControlPlaneSpec(controlPlane, want)
+		return r.setOwnership(turtle, controlPlane)
 	})
 
 	if err != nil {
@@ -166,20 +331,46 @@ func (r *TurtleReconciler) reconcileKubeadmControlPlane(ctx context.Context, tur
 	return nil
 }
 
-func (r *TurtleReconciler) reconcileKubeadmConfigTemplate(ctx context.Context, turtle *infrav1alpha1.Turtle) error {
-	template, err := getKubeadmConfigTemplate(turtle.Namespace, turtle.Name, turtle.Spec.Location, r.AzureSettings)
+// setKubeadmControlPlaneSpec sets the spec of a control plane to the wanted
+// one. The kubeadm config of an existing control plane is immutable, so it's
+// only set when the control plane is created; later updates are limited to
+// the fields the control plane webhook lets change.
+func setKubeadmControlPlaneSpec(controlPlane, want *kcpv1alpha3.KubeadmControlPlane) {
+	if controlPlane.CreationTimestamp.IsZero() {
+		controlPlane.Spec.KubeadmConfigSpec = want.Spec.KubeadmConfigSpec
+		controlPlane.Spec.InfrastructureTemplate = want.Spec.InfrastructureTemplate
+	}
+	controlPlane.Spec.Replicas = want.Spec.Replicas
+	controlPlane.Spec.Version = want.Spec.Version
+	controlPlane.Spec.InfrastructureTemplate.Name = want.Spec.InfrastructureTemplate.Name
+}
+
+func (r *TurtleReconciler) reconcileKubeadmConfigTemplate(ctx context.Context, turtle *infrav1alpha1.Turtle, hatchling *infrav1alpha1.HatchlingSpec) error {
+	settings, err := r.getAzureSettings(ctx, turtle)
 	if err != nil {
-		return fmt.Errorf("failed to get azure settings: %w", err)
+		return err
 	}
 
-	// TODO(ace): Verify -- I believe this is necessary because CreateOrUpdate does a get
-	// into the object it receives, so we need to save a copy and capture it
-	// into the closure context.
-	want := template.DeepCopy()
+	want, err := getKubeadmConfigTemplate(
+		turtle.Namespace,
+		hatchlingResourceName(turtle, hatchling),
+		turtle.Name,
+		turtle.Spec.Location,
+		resourceGroup(turtle),
+		settings,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to get azure settings: %w", err)
+	}
+	if err := setNodeConfig(&want.Spec.Template.Spec, hatchling); err != nil {
+		return err
+	}
 
+	template := &capbkv1alpha3.KubeadmConfigTemplate{ObjectMeta: want.ObjectMeta}
 	_, err = controllerutil.CreateOrUpdate(ctx, r.Client, template, func() error {
-		template = want
-		return nil
+		template.Spec = want.Spec
+		setHatchlingLabel(template, hatchling)
+		return r.setOwnership(turtle, template)
 	})
 
 	if err != nil {
@@ -189,60 +380,122 @@ func (r *TurtleReconciler) reconcileKubeadmConfigTemplate(ctx context.Context, t
 	return nil
 }
 
-func (r *TurtleReconciler) reconcileMachineTemplates(ctx context.Context, turtle *infrav1alpha1.Turtle) error {
-	for _, hatchling := range turtle.Spec.Hatchlings {
-		template := getMachineTemplate(turtle.Namespace, hatchling.Name, turtle.Spec.Location, hatchling.VMSize, hatchling.OSDiskSizeGB)
-		// TODO(ace): Verify -- I believe this is necessary because CreateOrUpdate does a get
-		// into the object it receives, so we need to save a copy and capture it
-		// into the closure context.
-		want := template.DeepCopy()
-
-		_, err := controllerutil.CreateOrUpdate(ctx, r.Client, template, func() error {
-			template = want
-			return nil
-		})
-
-		if err != nil {
-			return fmt.Errorf("failed to create/update machine template: %w", err)
+// reconcileMachineTemplate creates a machine template of the control plane,
+// or of a hatchling when one is given.
+func (r *TurtleReconciler) reconcileMachineTemplate(
+	ctx context.Context,
+	turtle *infrav1alpha1.Turtle,
+	want *capzv1alpha3.AzureMachineTemplate,
+	hatchling *infrav1alpha1.HatchlingSpec,
+) error {
+	template := &capzv1alpha3.AzureMachineTemplate{ObjectMeta: want.ObjectMeta}
+
+	// The name is derived from the spec, so the spec of an existing
+	// template never needs to change.
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, template, func() error {
+		if template.CreationTimestamp.IsZero() {
+			template.Spec = want.Spec
+		}
+		if hatchling != nil {
+			setHatchlingLabel(template, hatchling)
 		}
+		return r.setOwnership(turtle, template)
+	})
+
+	if err != nil {
+		return fmt.Errorf("failed to create/update machine template: %w", err)
 	}
 
 	return nil
 }
 
-func (r *TurtleReconciler) reconcileMachineDeployments(ctx context.Context, turtle *infrav1alpha1.Turtle) error {
-	for _, hatchling := range turtle.Spec.Hatchlings {
-		template := getMachineDeployment(turtle.Namespace, hatchling.Name, hatchling.Version, hatchling.Replicas)
+// reconcileMachineDeployment creates the machine deployment of a hatchling
+// at the version of the upgrade plan. Hatchlings the plan holds back are
+// not created yet.
+func (r *TurtleReconciler) reconcileMachineDeployment(
+	ctx context.Context,
+	turtle *infrav1alpha1.Turtle,
+	hatchling *infrav1alpha1.HatchlingSpec,
+	plan *upgradePlan,
+) error {
+	version, ok := plan.hatchlings[hatchling.Name]
+	if !ok {
+		return nil
+	}
 
-		// TODO(ace): Verify -- I believe this is necessary because CreateOrUpdate does a get
-		// into the object it receives, so we need to save a copy and capture it
-		// into the closure context.
-		want := template.DeepCopy()
+	if err := r.checkFailureDomain(ctx, turtle, hatchling); err != nil {
+		return err
+	}
 
-		_, err := controllerutil.CreateOrUpdate(ctx, r.Client, template, func() error {
-			template = want
-			return nil
-		})
+	name := hatchlingResourceName(turtle, hatchling)
+	want := getMachineDeployment(
+		turtle.Namespace,
+		name,
+		turtle.Name,
+		name,
+		hatchlingMachineTemplate(turtle, hatchling).Name,
+		version,
+		hatchling.Replicas,
+		hatchlingLabels(turtle, hatchling),
+	)
+	if hatchling.FailureDomain != "" {
+		failureDomain := hatchling.FailureDomain
+		want.Spec.Template.Spec.FailureDomain = &failureDomain
+	}
+	md := &capiv1alpha3.MachineDeployment{ObjectMeta: metav1.ObjectMeta{Name: want.Name, Namespace: want.Namespace}}
 
-		if err != nil {
-			return fmt.Errorf("failed to create/update machine deployment: %w", err)
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, md, func() error {
+		// The selector is immutable, and Cluster API adds its own labels
+		// to both the selector and the machine template.
+		if md.CreationTimestamp.IsZero() {
+			md.Spec.Selector = want.Spec.Selector
+		}
+		if md.Spec.Template.Labels == nil {
+			md.Spec.Template.Labels = map[string]string{}
 		}
+		for key, value := range want.Spec.Template.Labels {
+			md.Spec.Template.Labels[key] = value
+		}
+		// Nodes only read their bootstrap template when they are created,
+		// so a change to their configuration replaces them.
+		if hash := nodeConfigHash(hatchling); hash != "" {
+			if md.Spec.Template.Annotations == nil {
+				md.Spec.Template.Annotations = map[string]string{}
+			}
+			md.Spec.Template.Annotations[infrav1alpha1.NodeConfigHashAnnotation] = hash
+		} else {
+			delete(md.Spec.Template.Annotations, infrav1alpha1.NodeConfigHashAnnotation)
+		}
+		md.Spec.ClusterName = want.Spec.ClusterName
+		md.Spec.Replicas = want.Spec.Replicas
+		md.Spec.Template.Spec = want.Spec.Template.Spec
+		setHatchlingLabel(md, hatchling)
+		return r.setOwnership(turtle, md)
+	})
+
+	if err != nil {
+		return fmt.Errorf("failed to create/update machine deployment %s: %w", name, err)
 	}
 
 	return nil
 }
 
 func (r *TurtleReconciler) reconcileAzureCluster(ctx context.Context, turtle *infrav1alpha1.Turtle) error {
-	template := getAzureCluster(turtle.Namespace, turtle.Name, turtle.Spec.Location)
+	settings, err := r.getAzureSettings(ctx, turtle)
+	if err != nil {
+		return err
+	}
 
-	// TODO(ace): Verify -- I believe this is necessary because CreateOrUpdate does a get
-	// into the object it receives, so we need to save a copy and capture it
-	// into the closure context.
-	want := template.DeepCopy()
+	want := getAzureCluster(turtle.Namespace, turtle.Name, turtle.Spec.Location, resourceGroup(turtle), settings[auth.SubscriptionID])
+	azureCluster := &capzv1alpha3.AzureCluster{ObjectMeta: want.ObjectMeta}
 
-	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, template, func() error {
-		template = want
-		return nil
+	_, err = controllerutil.CreateOrUpdate(ctx, r.Client, azureCluster, func() error {
+		// The rest of the network spec is defaulted by CAPZ.
+		azureCluster.Spec.Location = want.Spec.Location
+		azureCluster.Spec.ResourceGroup = want.Spec.ResourceGroup
+		azureCluster.Spec.SubscriptionID = want.Spec.SubscriptionID
+		azureCluster.Spec.NetworkSpec.Vnet.Name = want.Spec.NetworkSpec.Vnet.Name
+		return r.setOwnership(turtle, azureCluster)
 	})
 
 	if err != nil {
@@ -253,44 +506,29 @@ func (r *TurtleReconciler) reconcileAzureCluster(ctx context.Context, turtle *in
 }
 
 func (r *TurtleReconciler) reconcileExternal(ctx context.Context, turtle *infrav1alpha1.Turtle) error {
-	// TODO(ace): don't hardcode, specify as reference in object
-	azureSecret := &corev1.Secret{}
-	azureKey := types.NamespacedName{
-		Name:      "bale-manager-credentials",
-		Namespace: "bale-system",
-	}
-
-	// Fetch azure manager credentials to transfer to remote cluster
-	if err := r.Get(ctx, azureKey, azureSecret); err != nil {
-		return fmt.Errorf("failed to get azure manager secret to apply to cluster: %w", err)
-	}
-
-	// Fetch remove kubeconfig
-	kubeconfigSecret := &corev1.Secret{}
-	kubeconfigKey := types.NamespacedName{
-		Name:      fmt.Sprintf("%s-kubeconfig", turtle.Name),
-		Namespace: turtle.Namespace,
+	cluster := &capiv1alpha3.Cluster{}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: turtle.Namespace, Name: turtle.Name}, cluster); err != nil {
+		return fmt.Errorf("failed to get cluster: %w", err)
 	}
-
-	if err := r.Get(ctx, kubeconfigKey, kubeconfigSecret); err != nil {
-		return fmt.Errorf("failed to get remote kubeconfig to apply to cluster: %w", err)
+	if !cluster.Status.ControlPlaneInitialized {
+		return waitFor("WaitingForControlPlane", "waiting on the control plane to be initialized", statusRequeueInterval)
 	}
 
-	data, ok := kubeconfigSecret.Data[secret.KubeconfigDataName]
-	if !ok {
-		return fmt.Errorf("missing key %q in secret data", secret.KubeconfigDataName)
+	// Fetch the turtle's azure credentials to transfer to remote cluster
+	azureSecret, err := r.getCredentials(ctx, turtle)
+	if err != nil {
+		return err
 	}
 
-	// Construct a kubeclient with it
-	remoteClient, err := remote.NewClient(data)
+	remoteClient, err := newRemoteClient(ctx, r.Client, r.Tracker, turtle)
 	if err != nil {
-		return fmt.Errorf("failed to create REST configuration for turtle %s/%s : %w", turtle.Namespace, turtle.Name, err)
+		return err
 	}
 
 	// Ensure existence of remote namespace
 	remoteNamespace := &corev1.Namespace{
 		ObjectMeta: metav1.ObjectMeta{
-			Name: azureKey.Namespace,
+			Name: defaultCredentials.Namespace,
 		},
 	}
 	_, err = controllerutil.CreateOrUpdate(ctx, remoteClient, remoteNamespace, func() error {
@@ -300,28 +538,122 @@ func (r *TurtleReconciler) reconcileExternal(ctx context.Context, turtle *infrav
 		return fmt.Errorf("failed to create remote azure manager namespace")
 	}
 
-	// Create fresh copy to avoid copying stuff like UID, resourceVersion
+	// Create fresh copy to avoid copying stuff like UID, resourceVersion.
+	// The remote name is fixed so workloads in every turtle find it in the
+	// same place, whichever secret it came from.
+	data := credentialsData(turtle, azureSecret)
 	remoteSecret := &corev1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      azureKey.Name,
-			Namespace: azureKey.Namespace,
+			Name:      defaultCredentials.Name,
+			Namespace: defaultCredentials.Namespace,
 		},
-		Data: azureSecret.Data,
 	}
-	want := remoteSecret.DeepCopy()
 	_, err = controllerutil.CreateOrUpdate(ctx, remoteClient, remoteSecret, func() error {
-		remoteSecret = want
+		remoteSecret.Data = data
 		return nil
 	})
 	if err != nil {
 		return fmt.Errorf("failed to create remote azure manager secret")
 	}
 
-	_, _, err = remoteClient.Apply("https://raw.githubusercontent.com/kubernetes-sigs/cluster-api-provider-azure/master/templates/addons/calico.yaml")
+	return nil
+}
+
+// newRemoteClient returns a client for the workload cluster of a turtle,
+// built from the kubeconfig Cluster API stores in the management cluster.
+// The client is reused from the tracker when there is one.
+func newRemoteClient(ctx context.Context, c client.Client, tracker *remote.ClusterCacheTracker, turtle *infrav1alpha1.Turtle) (*remote.Client, error) {
+	kubeconfig, err := getKubeconfig(ctx, c, turtle)
+	if err != nil {
+		return nil, err
+	}
+
+	if tracker != nil {
+		remoteClient, err := tracker.Client(types.NamespacedName{Namespace: turtle.Namespace, Name: turtle.Name}, kubeconfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get remote client for turtle %s/%s: %w", turtle.Namespace, turtle.Name, err)
+		}
+		return remoteClient, nil
+	}
 
+	// Construct a kubeclient with it
+	remoteClient, err := remote.NewClient(kubeconfig)
 	if err != nil {
-		return fmt.Errorf("failed to apply calico config: %w", err)
+		return nil, fmt.Errorf("failed to create REST configuration for turtle %s/%s : %w", turtle.Namespace, turtle.Name, err)
 	}
 
-	return nil
+	return remoteClient, nil
+}
+
+// getKubeconfig returns the kubeconfig of the workload cluster of a turtle.
+func getKubeconfig(ctx context.Context, c client.Client, turtle *infrav1alpha1.Turtle) ([]byte, error) {
+	kubeconfigSecret := &corev1.Secret{}
+	kubeconfigKey := types.NamespacedName{
+		Name:      secret.Name(turtle.Name, secret.Kubeconfig),
+		Namespace: turtle.Namespace,
+	}
+
+	if err := c.Get(ctx, kubeconfigKey, kubeconfigSecret); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, waitFor("WaitingForKubeconfig", fmt.Sprintf("waiting on kubeconfig secret %s", kubeconfigKey.Name), statusRequeueInterval)
+		}
+		return nil, fmt.Errorf("failed to get remote kubeconfig to apply to cluster: %w", err)
+	}
+
+	kubeconfig, ok := kubeconfigSecret.Data[secret.KubeconfigDataName]
+	if !ok {
+		return nil, fmt.Errorf("missing key %q in secret data", secret.KubeconfigDataName)
+	}
+
+	return kubeconfig, nil
+}
+
+// hatchlingLabels returns the labels selecting the machines of a hatchling.
+func hatchlingLabels(turtle *infrav1alpha1.Turtle, hatchling *infrav1alpha1.HatchlingSpec) map[string]string {
+	return map[string]string{
+		capiv1alpha3.ClusterLabelName:    turtle.Name,
+		infrav1alpha1.TurtleNameLabel:    turtle.Name,
+		infrav1alpha1.HatchlingNameLabel: hatchling.Name,
+	}
+}
+
+// hatchlingVersion returns the Kubernetes version of a hatchling, which
+// defaults to the version of the control plane.
+func hatchlingVersion(turtle *infrav1alpha1.Turtle, hatchling *infrav1alpha1.HatchlingSpec) string {
+	if hatchling.Version != "" {
+		return hatchling.Version
+	}
+	return turtle.Spec.Version
+}
+
+// setHatchlingLabel labels an object generated for a single hatchling.
+func setHatchlingLabel(obj metav1.Object, hatchling *infrav1alpha1.HatchlingSpec) {
+	labels := obj.GetLabels()
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	labels[infrav1alpha1.HatchlingNameLabel] = hatchling.Name
+	obj.SetLabels(labels)
+}
+
+// setOwnership labels an object generated for a turtle and makes the turtle
+// its controller.
+func (r *TurtleReconciler) setOwnership(turtle *infrav1alpha1.Turtle, obj metav1.Object) error {
+	labels := obj.GetLabels()
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	labels[capiv1alpha3.ClusterLabelName] = turtle.Name
+	labels[infrav1alpha1.TurtleNameLabel] = turtle.Name
+	obj.SetLabels(labels)
+	return controllerutil.SetControllerReference(turtle, obj, r.Scheme)
+}
+
+// resourceGroup returns the Azure resource group of a turtle, which
+// defaults to its name.
+func resourceGroup(turtle *infrav1alpha1.Turtle) string {
+	if turtle.Spec.ResourceGroup != "" {
+		return turtle.Spec.ResourceGroup
+	}
+	return turtle.Name
 }
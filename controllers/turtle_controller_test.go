@@ -0,0 +1,399 @@
+// Copyright 2020 Alexander Eldeib
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS
+// OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+// CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+// TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	capiv1alpha3 "sigs.k8s.io/cluster-api/api/v1alpha3"
+	capbkv1alpha3 "sigs.k8s.io/cluster-api/bootstrap/kubeadm/api/v1alpha3"
+	kcpv1alpha3 "sigs.k8s.io/cluster-api/controlplane/kubeadm/api/v1alpha3"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	infrav1alpha1 "github.com/alexeldeib/bale/api/v1alpha1"
+)
+
+func TestResumeCluster(t *testing.T) {
+	g := NewWithT(t)
+
+	cluster := &capiv1alpha3.Cluster{}
+	cluster.Annotations = map[string]string{capiv1alpha3.PausedAnnotation: "true"}
+	resumeCluster(cluster)
+	g.Expect(cluster.Annotations).To(HaveKey(capiv1alpha3.PausedAnnotation), "pauses set by others are kept")
+
+	cluster.Annotations[infrav1alpha1.PausedByTurtleAnnotation] = "true"
+	resumeCluster(cluster)
+	g.Expect(cluster.Annotations).To(BeEmpty())
+}
+
+func TestReconcileKubeadmControlPlane(t *testing.T) {
+	turtle := &infrav1alpha1.Turtle{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "turtle", UID: "uid"},
+		Spec: infrav1alpha1.TurtleSpec{
+			Location:             "westus2",
+			Version:              "v1.18.3",
+			ControlPlaneReplicas: 3,
+		},
+	}
+	credentials := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: defaultCredentials.Namespace, Name: defaultCredentials.Name},
+		Data: map[string][]byte{
+			subscriptionIDKey: []byte("subscription"),
+			tenantIDKey:       []byte("tenant"),
+			clientIDKey:       []byte("client"),
+			clientSecretKey:   []byte("rotated"),
+		},
+	}
+	existing := func() *kcpv1alpha3.KubeadmControlPlane {
+		replicas := int32(1)
+		return &kcpv1alpha3.KubeadmControlPlane{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace:         "default",
+				Name:              "turtle",
+				CreationTimestamp: metav1.Now(),
+			},
+			Spec: kcpv1alpha3.KubeadmControlPlaneSpec{
+				Replicas: &replicas,
+				Version:  "v1.17.7",
+				InfrastructureTemplate: corev1.ObjectReference{
+					APIVersion: "infrastructure.cluster.x-k8s.io/v1alpha3",
+					Kind:       "AzureMachineTemplate",
+					Namespace:  "default",
+					Name:       "turtle-control-plane-old",
+				},
+				KubeadmConfigSpec: capbkv1alpha3.KubeadmConfigSpec{PreKubeadmCommands: []string{"created"}},
+			},
+		}
+	}
+
+	flannel := turtle.DeepCopy()
+	flannel.Spec.Network.CNI = infrav1alpha1.CNIFlannel
+
+	cases := map[string]struct {
+		turtle    *infrav1alpha1.Turtle
+		objects   []runtime.Object
+		wantSpec  func(g *WithT, spec kcpv1alpha3.KubeadmControlPlaneSpec)
+		wantError string
+	}{
+		"creates the control plane": {
+			objects: []runtime.Object{credentials},
+			wantSpec: func(g *WithT, spec kcpv1alpha3.KubeadmControlPlaneSpec) {
+				g.Expect(spec.KubeadmConfigSpec.Files).NotTo(BeEmpty())
+				g.Expect(spec.InfrastructureTemplate.Kind).To(Equal("AzureMachineTemplate"))
+			},
+		},
+		"keeps the kubeadm config of an existing control plane": {
+			objects: []runtime.Object{credentials, existing()},
+			wantSpec: func(g *WithT, spec kcpv1alpha3.KubeadmControlPlaneSpec) {
+				g.Expect(spec.KubeadmConfigSpec).To(Equal(capbkv1alpha3.KubeadmConfigSpec{PreKubeadmCommands: []string{"created"}}))
+			},
+		},
+		"allocates node cidrs of new flannel control planes": {
+			turtle:  flannel,
+			objects: []runtime.Object{credentials},
+			wantSpec: func(g *WithT, spec kcpv1alpha3.KubeadmControlPlaneSpec) {
+				g.Expect(spec.KubeadmConfigSpec.ClusterConfiguration.ControllerManager.ExtraArgs).To(HaveKeyWithValue("allocate-node-cidrs", "true"))
+			},
+		},
+		"leaves node cidrs of existing control planes alone": {
+			turtle:  flannel,
+			objects: []runtime.Object{credentials, existing()},
+			wantSpec: func(g *WithT, spec kcpv1alpha3.KubeadmControlPlaneSpec) {
+				g.Expect(spec.KubeadmConfigSpec.ClusterConfiguration).To(BeNil())
+			},
+		},
+		"fails without credentials": {
+			objects:   []runtime.Object{existing()},
+			wantError: "failed to get azure credentials",
+		},
+	}
+
+	for name, tc := range cases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			scheme := runtime.NewScheme()
+			g.Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+			g.Expect(infrav1alpha1.AddToScheme(scheme)).To(Succeed())
+			g.Expect(kcpv1alpha3.AddToScheme(scheme)).To(Succeed())
+
+			turtle := turtle
+			if tc.turtle != nil {
+				turtle = tc.turtle
+			}
+
+			r := &TurtleReconciler{Client: fake.NewFakeClientWithScheme(scheme, tc.objects...), Scheme: scheme}
+			err := r.reconcileKubeadmControlPlane(context.Background(), turtle, &upgradePlan{controlPlane: "v1.18.3"})
+			if tc.wantError != "" {
+				g.Expect(err).To(MatchError(ContainSubstring(tc.wantError)))
+				return
+			}
+			g.Expect(err).NotTo(HaveOccurred())
+
+			controlPlane := &kcpv1alpha3.KubeadmControlPlane{}
+			g.Expect(r.Get(context.Background(), types.NamespacedName{Namespace: "default", Name: "turtle"}, controlPlane)).To(Succeed())
+			g.Expect(*controlPlane.Spec.Replicas).To(Equal(int32(3)))
+			g.Expect(controlPlane.Spec.Version).To(Equal("v1.18.3"))
+			g.Expect(controlPlane.Spec.InfrastructureTemplate.Name).To(Equal(controlPlaneMachineTemplate(turtle).Name))
+			tc.wantSpec(g, controlPlane.Spec)
+		})
+	}
+}
+
+func TestReconcileMachineDeployment(t *testing.T) {
+	turtle := &infrav1alpha1.Turtle{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "turtle", UID: "uid"},
+		Spec: infrav1alpha1.TurtleSpec{
+			Location:   "westus2",
+			Version:    "v1.18.3",
+			Hatchlings: []infrav1alpha1.HatchlingSpec{{Name: "default", Replicas: 3, VMSize: "Standard_D2s_v3"}},
+		},
+	}
+	hatchling := &turtle.Spec.Hatchlings[0]
+	plan := &upgradePlan{controlPlane: "v1.18.3", hatchlings: map[string]string{"default": "v1.18.3"}}
+	wantLabels := map[string]string{
+		capiv1alpha3.ClusterLabelName:    "turtle",
+		infrav1alpha1.TurtleNameLabel:    "turtle",
+		infrav1alpha1.HatchlingNameLabel: "default",
+	}
+
+	// Cluster API adds its own labels to the selector and template of
+	// machine deployments it admits.
+	existing := &capiv1alpha3.MachineDeployment{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "turtle-default", CreationTimestamp: metav1.Now()},
+		Spec: capiv1alpha3.MachineDeploymentSpec{
+			ClusterName: "turtle",
+			Selector: metav1.LabelSelector{MatchLabels: map[string]string{
+				capiv1alpha3.ClusterLabelName:           "turtle",
+				capiv1alpha3.MachineDeploymentLabelName: "turtle-default",
+			}},
+			Template: capiv1alpha3.MachineTemplateSpec{
+				ObjectMeta: capiv1alpha3.ObjectMeta{
+					Labels:      map[string]string{capiv1alpha3.MachineDeploymentLabelName: "turtle-default"},
+					Annotations: map[string]string{infrav1alpha1.NodeConfigHashAnnotation: "stale"},
+				},
+			},
+		},
+	}
+	other := &capiv1alpha3.MachineDeployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      "other-default",
+			Labels:    map[string]string{capiv1alpha3.ClusterLabelName: "other", infrav1alpha1.HatchlingNameLabel: "default"},
+		},
+		Spec: capiv1alpha3.MachineDeploymentSpec{ClusterName: "other"},
+	}
+	zoned := turtle.DeepCopy()
+	zoned.Spec.Hatchlings[0].FailureDomain = "3"
+	cluster := func(infrastructureReady bool) *capiv1alpha3.Cluster {
+		return &capiv1alpha3.Cluster{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "turtle"},
+			Status: capiv1alpha3.ClusterStatus{
+				InfrastructureReady: infrastructureReady,
+				FailureDomains:      capiv1alpha3.FailureDomains{"1": {}, "2": {}},
+			},
+		}
+	}
+
+	cases := map[string]struct {
+		turtle    *infrav1alpha1.Turtle
+		plan      *upgradePlan
+		objects   []runtime.Object
+		wantMD    func(g *WithT, md *capiv1alpha3.MachineDeployment)
+		wantError string
+	}{
+		"creates the machine deployment of a hatchling": {
+			wantMD: func(g *WithT, md *capiv1alpha3.MachineDeployment) {
+				g.Expect(md.Labels).To(Equal(wantLabels))
+				g.Expect(md.Spec.ClusterName).To(Equal("turtle"))
+				g.Expect(md.Spec.Selector.MatchLabels).To(Equal(wantLabels))
+				g.Expect(md.Spec.Template.Labels).To(Equal(wantLabels))
+				g.Expect(md.Spec.Template.Spec.ClusterName).To(Equal("turtle"))
+				g.Expect(md.Spec.Template.Spec.Bootstrap.ConfigRef.Name).To(Equal("turtle-default"))
+				g.Expect(md.Spec.Template.Spec.InfrastructureRef.Name).To(Equal(hatchlingMachineTemplate(turtle, hatchling).Name))
+				g.Expect(metav1.IsControlledBy(md, turtle)).To(BeTrue())
+			},
+		},
+		"keeps the selector and labels cluster api added when updating": {
+			objects: []runtime.Object{existing},
+			wantMD: func(g *WithT, md *capiv1alpha3.MachineDeployment) {
+				g.Expect(md.Spec.Selector).To(Equal(existing.Spec.Selector))
+				g.Expect(md.Spec.Template.Labels).To(HaveKeyWithValue(capiv1alpha3.MachineDeploymentLabelName, "turtle-default"))
+				g.Expect(md.Spec.Template.Labels).To(HaveKeyWithValue(infrav1alpha1.HatchlingNameLabel, "default"))
+				g.Expect(md.Spec.Template.Annotations).NotTo(HaveKey(infrav1alpha1.NodeConfigHashAnnotation))
+				g.Expect(*md.Spec.Replicas).To(Equal(int32(3)))
+				g.Expect(*md.Spec.Template.Spec.Version).To(Equal("v1.18.3"))
+				g.Expect(md.Labels).To(Equal(wantLabels))
+			},
+		},
+		"leaves the same hatchling of another turtle alone": {
+			objects: []runtime.Object{other},
+			wantMD: func(g *WithT, md *capiv1alpha3.MachineDeployment) {
+				g.Expect(md.Spec.ClusterName).To(Equal("turtle"))
+			},
+		},
+		"doesn't create hatchlings the plan holds back": {
+			plan: &upgradePlan{controlPlane: "v1.18.3", hatchlings: map[string]string{}},
+		},
+		"places hatchlings in failure domains of the cluster": {
+			turtle:    zoned,
+			objects:   []runtime.Object{cluster(true)},
+			wantError: `failure domain "3" of hatchling default is not one of the failure domains of westus2: [1, 2]`,
+		},
+		"waits for the failure domains of the cluster": {
+			turtle:    zoned,
+			objects:   []runtime.Object{cluster(false)},
+			wantError: "waiting on the failure domains of the cluster",
+		},
+	}
+
+	for name, tc := range cases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			g := NewWithT(t)
+			ctx := context.Background()
+
+			scheme := runtime.NewScheme()
+			g.Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+			g.Expect(infrav1alpha1.AddToScheme(scheme)).To(Succeed())
+			g.Expect(capiv1alpha3.AddToScheme(scheme)).To(Succeed())
+
+			turtle := turtle
+			if tc.turtle != nil {
+				turtle = tc.turtle
+			}
+			plan := plan
+			if tc.plan != nil {
+				plan = tc.plan
+			}
+
+			r := &TurtleReconciler{Client: fake.NewFakeClientWithScheme(scheme, tc.objects...), Scheme: scheme}
+			err := r.reconcileMachineDeployment(ctx, turtle, &turtle.Spec.Hatchlings[0], plan)
+			if tc.wantError != "" {
+				g.Expect(err).To(MatchError(ContainSubstring(tc.wantError)))
+				return
+			}
+			g.Expect(err).NotTo(HaveOccurred())
+
+			for _, obj := range tc.objects {
+				if md, ok := obj.(*capiv1alpha3.MachineDeployment); ok && md != existing {
+					stored := &capiv1alpha3.MachineDeployment{}
+					g.Expect(r.Get(ctx, types.NamespacedName{Namespace: "default", Name: md.Name}, stored)).To(Succeed())
+					g.Expect(stored.Spec).To(Equal(md.Spec), "machine deployments of other turtles are untouched")
+				}
+			}
+
+			md := &capiv1alpha3.MachineDeployment{}
+			err = r.Get(ctx, types.NamespacedName{Namespace: "default", Name: "turtle-default"}, md)
+			if tc.wantMD == nil {
+				g.Expect(apierrors.IsNotFound(err)).To(BeTrue())
+				return
+			}
+			g.Expect(err).NotTo(HaveOccurred())
+			tc.wantMD(g, md)
+		})
+	}
+}
+
+func TestReconcileKubeadmConfigTemplate(t *testing.T) {
+	turtle := &infrav1alpha1.Turtle{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "turtle", UID: "uid"},
+		Spec: infrav1alpha1.TurtleSpec{
+			Location:   "westus2",
+			Hatchlings: []infrav1alpha1.HatchlingSpec{{Name: "default"}},
+		},
+	}
+	credentials := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: defaultCredentials.Namespace, Name: defaultCredentials.Name},
+		Data: map[string][]byte{
+			subscriptionIDKey: []byte("subscription"),
+			tenantIDKey:       []byte("tenant"),
+			clientIDKey:       []byte("client"),
+			clientSecretKey:   []byte("secret"),
+		},
+	}
+	stale := &capbkv1alpha3.KubeadmConfigTemplate{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "turtle-default", Labels: map[string]string{"team": "a"}},
+		Spec: capbkv1alpha3.KubeadmConfigTemplateSpec{Template: capbkv1alpha3.KubeadmConfigTemplateResource{
+			Spec: capbkv1alpha3.KubeadmConfigSpec{PreKubeadmCommands: []string{"stale"}},
+		}},
+	}
+
+	cases := map[string]struct {
+		objects   []runtime.Object
+		wantError string
+		wantExtra map[string]string
+	}{
+		"creates the bootstrap template of a hatchling": {
+			objects: []runtime.Object{credentials},
+		},
+		"updates an existing bootstrap template": {
+			objects:   []runtime.Object{credentials, stale},
+			wantExtra: map[string]string{"team": "a"},
+		},
+		"fails without credentials": {
+			wantError: "failed to get azure credentials",
+		},
+	}
+
+	for name, tc := range cases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			g := NewWithT(t)
+			ctx := context.Background()
+
+			scheme := runtime.NewScheme()
+			g.Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+			g.Expect(infrav1alpha1.AddToScheme(scheme)).To(Succeed())
+			g.Expect(capbkv1alpha3.AddToScheme(scheme)).To(Succeed())
+
+			r := &TurtleReconciler{Client: fake.NewFakeClientWithScheme(scheme, tc.objects...), Scheme: scheme}
+			err := r.reconcileKubeadmConfigTemplate(ctx, turtle, &turtle.Spec.Hatchlings[0])
+			if tc.wantError != "" {
+				g.Expect(err).To(MatchError(ContainSubstring(tc.wantError)))
+				return
+			}
+			g.Expect(err).NotTo(HaveOccurred())
+
+			template := &capbkv1alpha3.KubeadmConfigTemplate{}
+			g.Expect(r.Get(ctx, types.NamespacedName{Namespace: "default", Name: "turtle-default"}, template)).To(Succeed())
+			g.Expect(template.Labels).To(HaveKeyWithValue(capiv1alpha3.ClusterLabelName, "turtle"))
+			g.Expect(template.Labels).To(HaveKeyWithValue(infrav1alpha1.TurtleNameLabel, "turtle"))
+			g.Expect(template.Labels).To(HaveKeyWithValue(infrav1alpha1.HatchlingNameLabel, "default"))
+			for key, value := range tc.wantExtra {
+				g.Expect(template.Labels).To(HaveKeyWithValue(key, value))
+			}
+			g.Expect(template.Spec.Template.Spec.PreKubeadmCommands).NotTo(ContainElement("stale"))
+			g.Expect(template.Spec.Template.Spec.Files).NotTo(BeEmpty())
+			g.Expect(metav1.IsControlledBy(template, turtle)).To(BeTrue())
+		})
+	}
+}
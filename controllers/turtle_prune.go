@@ -0,0 +1,190 @@
+// Copyright 2020 Alexander Eldeib
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS
+// OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+// CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+// TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	capiv1alpha3 "sigs.k8s.io/cluster-api/api/v1alpha3"
+	capbkv1alpha3 "sigs.k8s.io/cluster-api/bootstrap/kubeadm/api/v1alpha3"
+	"sigs.k8s.io/cluster-api/util/secret"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	infrav1alpha1 "github.com/alexeldeib/bale/api/v1alpha1"
+)
+
+// pruneHatchlings deletes the machine deployments and bootstrap templates of
+// hatchlings which were removed from the spec of a turtle, or which were
+// renamed. Their machine templates are garbage collected once unreferenced.
+// A machine deployment whose nodes still run workloads is kept unless the
+// turtle is annotated to force pruning, so removing a hatchling by mistake
+// doesn't take down what runs on it.
+func (r *TurtleReconciler) pruneHatchlings(ctx context.Context, turtle *infrav1alpha1.Turtle) error {
+	// Objects of hatchlings which are still wanted are stale when they
+	// don't have the name we'd generate, e.g. after a naming change.
+	wanted := make(map[string]string, len(turtle.Spec.Hatchlings))
+	for _, hatchling := range turtle.Spec.Hatchlings {
+		hatchling := hatchling
+		wanted[hatchling.Name] = hatchlingResourceName(turtle, &hatchling)
+	}
+
+	stale := func(obj metav1.Object) bool {
+		hatchling, ok := obj.GetLabels()[infrav1alpha1.HatchlingNameLabel]
+		if !ok || !metav1.IsControlledBy(obj, turtle) {
+			return false
+		}
+		name, ok := wanted[hatchling]
+		return !ok || obj.GetName() != name
+	}
+
+	opts := []client.ListOption{
+		client.InNamespace(turtle.Namespace),
+		client.MatchingLabels{infrav1alpha1.TurtleNameLabel: turtle.Name},
+	}
+
+	var mds capiv1alpha3.MachineDeploymentList
+	if err := r.List(ctx, &mds, opts...); err != nil {
+		return fmt.Errorf("failed to list machine deployments: %w", err)
+	}
+
+	// Bootstrap templates are only deleted once no machine deployment of
+	// their hatchling is left, since they are referenced until then.
+	remaining := map[string]bool{}
+	var blocked []string
+	for i := range mds.Items {
+		md := &mds.Items[i]
+		if !stale(md) {
+			continue
+		}
+		hatchling := md.Labels[infrav1alpha1.HatchlingNameLabel]
+		remaining[hatchling] = true
+
+		if _, ok := turtle.Annotations[infrav1alpha1.ForcePruneAnnotation]; !ok {
+			workloads, err := r.getWorkloads(ctx, turtle, md)
+			if err != nil {
+				return err
+			}
+			if len(workloads) > 0 {
+				blocked = append(blocked, fmt.Sprintf("%s (%s)", hatchling, strings.Join(workloads, ", ")))
+				continue
+			}
+		}
+
+		r.Log.Info("pruning removed hatchling", "turtle", turtle.Name, "hatchling", hatchling, "machineDeployment", md.Name)
+		if err := deleteIfPresent(ctx, r.Client, md); err != nil {
+			return fmt.Errorf("failed to delete machine deployment %s: %w", md.Name, err)
+		}
+	}
+
+	var bootstrapTemplates capbkv1alpha3.KubeadmConfigTemplateList
+	if err := r.List(ctx, &bootstrapTemplates, opts...); err != nil {
+		return fmt.Errorf("failed to list kubeadm config templates: %w", err)
+	}
+	for i := range bootstrapTemplates.Items {
+		template := &bootstrapTemplates.Items[i]
+		if stale(template) && !remaining[template.Labels[infrav1alpha1.HatchlingNameLabel]] {
+			if err := deleteIfPresent(ctx, r.Client, template); err != nil {
+				return fmt.Errorf("failed to delete kubeadm config template %s: %w", template.Name, err)
+			}
+		}
+	}
+
+	if len(blocked) > 0 {
+		setCondition(&turtle.Status.Conditions, infrav1alpha1.TurtleHatchlingsPrunedCondition, corev1.ConditionFalse, "WorkloadsRunning",
+			fmt.Sprintf("stale node pools still run workloads, drain them or annotate with %s: %s",
+				infrav1alpha1.ForcePruneAnnotation, strings.Join(blocked, "; ")))
+	} else {
+		setCondition(&turtle.Status.Conditions, infrav1alpha1.TurtleHatchlingsPrunedCondition, corev1.ConditionTrue, "", "")
+	}
+
+	return nil
+}
+
+// getWorkloads returns the pods running on the nodes of a machine
+// deployment, except those belonging to daemon sets and static pods, which
+// run on every node anyway.
+func (r *TurtleReconciler) getWorkloads(ctx context.Context, turtle *infrav1alpha1.Turtle, md *capiv1alpha3.MachineDeployment) ([]string, error) {
+	var machines capiv1alpha3.MachineList
+	if err := r.List(ctx, &machines, client.InNamespace(md.Namespace), client.MatchingLabels{capiv1alpha3.MachineDeploymentLabelName: md.Name}); err != nil {
+		return nil, fmt.Errorf("failed to list machines: %w", err)
+	}
+
+	var nodes []string
+	for i := range machines.Items {
+		if nodeRef := machines.Items[i].Status.NodeRef; nodeRef != nil {
+			nodes = append(nodes, nodeRef.Name)
+		}
+	}
+	if len(nodes) == 0 {
+		return nil, nil
+	}
+
+	// Nodes can't have joined a cluster which never produced a kubeconfig.
+	kubeconfig := &corev1.Secret{}
+	key := types.NamespacedName{Namespace: turtle.Namespace, Name: secret.Name(turtle.Name, secret.Kubeconfig)}
+	if err := r.Get(ctx, key, kubeconfig); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get kubeconfig: %w", err)
+	}
+
+	remoteClient, err := newRemoteClient(ctx, r.Client, r.Tracker, turtle)
+	if err != nil {
+		return nil, err
+	}
+
+	var workloads []string
+	for _, node := range nodes {
+		var pods corev1.PodList
+		if err := remoteClient.List(ctx, &pods, client.MatchingFields{"spec.nodeName": node}); err != nil {
+			return nil, fmt.Errorf("failed to list pods on node %s: %w", node, err)
+		}
+		for i := range pods.Items {
+			if isWorkload(&pods.Items[i]) {
+				workloads = append(workloads, fmt.Sprintf("%s/%s", pods.Items[i].Namespace, pods.Items[i].Name))
+			}
+		}
+	}
+
+	return workloads, nil
+}
+
+// isWorkload reports whether a pod would be lost if its node went away.
+func isWorkload(pod *corev1.Pod) bool {
+	if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+		return false
+	}
+	if _, ok := pod.Annotations[corev1.MirrorPodAnnotationKey]; ok {
+		return false
+	}
+	if owner := metav1.GetControllerOf(pod); owner != nil && owner.Kind == "DaemonSet" {
+		return false
+	}
+	return true
+}
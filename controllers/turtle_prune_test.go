@@ -0,0 +1,230 @@
+// Copyright 2020 Alexander Eldeib
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS
+// OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+// CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+// TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	capiv1alpha3 "sigs.k8s.io/cluster-api/api/v1alpha3"
+	capbkv1alpha3 "sigs.k8s.io/cluster-api/bootstrap/kubeadm/api/v1alpha3"
+	"sigs.k8s.io/cluster-api/util/secret"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	infrav1alpha1 "github.com/alexeldeib/bale/api/v1alpha1"
+)
+
+func TestPruneHatchlings(t *testing.T) {
+	turtle := &infrav1alpha1.Turtle{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "turtle", UID: "uid"},
+		Spec: infrav1alpha1.TurtleSpec{
+			Hatchlings: []infrav1alpha1.HatchlingSpec{{Name: "kept"}},
+		},
+	}
+	owned := func(name, hatchling string) metav1.ObjectMeta {
+		return metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      name,
+			Labels: map[string]string{
+				infrav1alpha1.TurtleNameLabel:    "turtle",
+				infrav1alpha1.HatchlingNameLabel: hatchling,
+			},
+			OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(turtle, infrav1alpha1.GroupVersion.WithKind("Turtle"))},
+		}
+	}
+	machineDeployment := func(name, hatchling string) *capiv1alpha3.MachineDeployment {
+		return &capiv1alpha3.MachineDeployment{ObjectMeta: owned(name, hatchling)}
+	}
+	bootstrapTemplate := func(name, hatchling string) *capbkv1alpha3.KubeadmConfigTemplate {
+		return &capbkv1alpha3.KubeadmConfigTemplate{ObjectMeta: owned(name, hatchling)}
+	}
+	node := &capiv1alpha3.Machine{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      "turtle-removed-abcde",
+			Labels:    map[string]string{capiv1alpha3.MachineDeploymentLabelName: "turtle-removed"},
+		},
+		Status: capiv1alpha3.MachineStatus{NodeRef: &corev1.ObjectReference{Name: "node"}},
+	}
+	// A kubeconfig without data makes the workloads of its nodes unknowable.
+	brokenKubeconfig := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: secret.Name("turtle", secret.Kubeconfig)}}
+	unowned := machineDeployment("turtle-removed", "removed")
+	unowned.OwnerReferences = nil
+	forced := turtle.DeepCopy()
+	forced.Annotations = map[string]string{infrav1alpha1.ForcePruneAnnotation: ""}
+	failMachineDeployments := func(obj runtime.Object) bool {
+		_, ok := obj.(*capiv1alpha3.MachineDeployment)
+		return ok
+	}
+
+	cases := map[string]struct {
+		turtle    *infrav1alpha1.Turtle
+		objects   []runtime.Object
+		fail      func(obj runtime.Object) bool
+		wantError string
+		wantGone  []runtime.Object
+		wantKept  []runtime.Object
+	}{
+		"prunes machine deployments of removed hatchlings before their bootstrap templates": {
+			objects: []runtime.Object{
+				machineDeployment("turtle-kept", "kept"), bootstrapTemplate("turtle-kept", "kept"),
+				machineDeployment("turtle-removed", "removed"), bootstrapTemplate("turtle-removed", "removed"),
+			},
+			wantGone: []runtime.Object{machineDeployment("turtle-removed", "removed")},
+			wantKept: []runtime.Object{machineDeployment("turtle-kept", "kept"), bootstrapTemplate("turtle-kept", "kept"), bootstrapTemplate("turtle-removed", "removed")},
+		},
+		"prunes bootstrap templates once their machine deployments are gone": {
+			objects: []runtime.Object{
+				machineDeployment("turtle-kept", "kept"), bootstrapTemplate("turtle-kept", "kept"),
+				bootstrapTemplate("turtle-removed", "removed"),
+			},
+			wantGone: []runtime.Object{bootstrapTemplate("turtle-removed", "removed")},
+			wantKept: []runtime.Object{machineDeployment("turtle-kept", "kept"), bootstrapTemplate("turtle-kept", "kept")},
+		},
+		"prunes objects of a hatchling under a stale name": {
+			objects:  []runtime.Object{machineDeployment("kept", "kept"), bootstrapTemplate("turtle-kept-old", "kept")},
+			wantGone: []runtime.Object{machineDeployment("kept", "kept")},
+			wantKept: []runtime.Object{bootstrapTemplate("turtle-kept-old", "kept")},
+		},
+		"leaves objects the turtle doesn't control": {
+			objects:  []runtime.Object{unowned},
+			wantKept: []runtime.Object{unowned},
+		},
+		"prunes nodes of clusters without a kubeconfig": {
+			objects:  []runtime.Object{machineDeployment("turtle-removed", "removed"), node},
+			wantGone: []runtime.Object{machineDeployment("turtle-removed", "removed")},
+		},
+		"keeps node pools whose workloads can't be checked": {
+			objects: []runtime.Object{
+				machineDeployment("turtle-removed", "removed"), bootstrapTemplate("turtle-removed", "removed"),
+				node, brokenKubeconfig,
+			},
+			wantError: "missing key",
+			wantKept:  []runtime.Object{machineDeployment("turtle-removed", "removed"), bootstrapTemplate("turtle-removed", "removed")},
+		},
+		"force prunes without checking workloads": {
+			turtle: forced,
+			objects: []runtime.Object{
+				machineDeployment("turtle-removed", "removed"), bootstrapTemplate("turtle-removed", "removed"),
+				node, brokenKubeconfig,
+			},
+			wantGone: []runtime.Object{machineDeployment("turtle-removed", "removed")},
+		},
+		"keeps the bootstrap template when deleting the machine deployment fails": {
+			objects:   []runtime.Object{machineDeployment("turtle-removed", "removed"), bootstrapTemplate("turtle-removed", "removed")},
+			fail:      failMachineDeployments,
+			wantError: "failed to delete machine deployment turtle-removed",
+			wantKept:  []runtime.Object{machineDeployment("turtle-removed", "removed"), bootstrapTemplate("turtle-removed", "removed")},
+		},
+	}
+
+	for name, tc := range cases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			g := NewWithT(t)
+			ctx := context.Background()
+
+			scheme := runtime.NewScheme()
+			g.Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+			g.Expect(infrav1alpha1.AddToScheme(scheme)).To(Succeed())
+			g.Expect(capiv1alpha3.AddToScheme(scheme)).To(Succeed())
+			g.Expect(capbkv1alpha3.AddToScheme(scheme)).To(Succeed())
+
+			turtle := turtle.DeepCopy()
+			if tc.turtle != nil {
+				turtle = tc.turtle.DeepCopy()
+			}
+
+			var c client.Client = fake.NewFakeClientWithScheme(scheme, tc.objects...)
+			if tc.fail != nil {
+				c = &failingDeleteClient{Client: c, fail: tc.fail}
+			}
+			r := &TurtleReconciler{Client: c, Log: ctrl.Log, Scheme: scheme}
+
+			err := r.pruneHatchlings(ctx, turtle)
+			if tc.wantError != "" {
+				g.Expect(err).To(MatchError(ContainSubstring(tc.wantError)))
+			} else {
+				g.Expect(err).NotTo(HaveOccurred())
+				condition := getCondition(turtle.Status.Conditions, infrav1alpha1.TurtleHatchlingsPrunedCondition)
+				g.Expect(condition).NotTo(BeNil())
+				g.Expect(condition.Status).To(Equal(corev1.ConditionTrue))
+			}
+
+			for _, obj := range tc.wantGone {
+				key, err := client.ObjectKeyFromObject(obj)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(apierrors.IsNotFound(r.Get(ctx, key, obj))).To(BeTrue(), "%T %s should be pruned", obj, key.Name)
+			}
+			for _, obj := range tc.wantKept {
+				key, err := client.ObjectKeyFromObject(obj)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(r.Get(ctx, key, obj)).To(Succeed(), "%T %s should be kept", obj, key.Name)
+			}
+		})
+	}
+}
+
+func TestIsWorkload(t *testing.T) {
+	controller := true
+	cases := map[string]struct {
+		pod  corev1.Pod
+		want bool
+	}{
+		"running pod": {
+			pod:  corev1.Pod{Status: corev1.PodStatus{Phase: corev1.PodRunning}},
+			want: true,
+		},
+		"completed pod": {
+			pod: corev1.Pod{Status: corev1.PodStatus{Phase: corev1.PodSucceeded}},
+		},
+		"failed pod": {
+			pod: corev1.Pod{Status: corev1.PodStatus{Phase: corev1.PodFailed}},
+		},
+		"static pod": {
+			pod: corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{corev1.MirrorPodAnnotationKey: "hash"}}},
+		},
+		"daemon set pod": {
+			pod: corev1.Pod{ObjectMeta: metav1.ObjectMeta{OwnerReferences: []metav1.OwnerReference{{Kind: "DaemonSet", Controller: &controller}}}},
+		},
+		"replica set pod": {
+			pod:  corev1.Pod{ObjectMeta: metav1.ObjectMeta{OwnerReferences: []metav1.OwnerReference{{Kind: "ReplicaSet", Controller: &controller}}}},
+			want: true,
+		},
+	}
+
+	for name, tc := range cases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			g := NewWithT(t)
+			g.Expect(isWorkload(&tc.pod)).To(Equal(tc.want))
+		})
+	}
+}
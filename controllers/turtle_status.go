@@ -0,0 +1,176 @@
+// Copyright 2020 Alexander Eldeib
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS
+// OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+// CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+// TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	capiv1alpha3 "sigs.k8s.io/cluster-api/api/v1alpha3"
+	kcpv1alpha3 "sigs.k8s.io/cluster-api/controlplane/kubeadm/api/v1alpha3"
+	"sigs.k8s.io/cluster-api/util/secret"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	infrav1alpha1 "github.com/alexeldeib/bale/api/v1alpha1"
+)
+
+// statusRequeueInterval is how often a turtle which isn't ready is revisited.
+// Machine deployments report progress without triggering a reconcile.
+const statusRequeueInterval = 30 * time.Second
+
+// updateStatus aggregates the status of the Cluster API objects of a turtle
+// into its status and persists it.
+func (r *TurtleReconciler) updateStatus(ctx context.Context, turtle *infrav1alpha1.Turtle) error {
+	status := &turtle.Status
+	status.ObservedGeneration = turtle.Generation
+	status.FailureReason = ""
+	status.FailureMessage = ""
+
+	cluster := &capiv1alpha3.Cluster{}
+	clusterFound := true
+	if err := r.Get(ctx, types.NamespacedName{Namespace: turtle.Namespace, Name: turtle.Name}, cluster); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to get cluster: %w", err)
+		}
+		clusterFound = false
+	}
+
+	controlPlane := &kcpv1alpha3.KubeadmControlPlane{}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: turtle.Namespace, Name: turtle.Name}, controlPlane); client.IgnoreNotFound(err) != nil {
+		return fmt.Errorf("failed to get kubeadm control plane: %w", err)
+	}
+
+	var controlPlaneMachines capiv1alpha3.MachineList
+	if err := r.List(ctx, &controlPlaneMachines, client.InNamespace(turtle.Namespace), client.MatchingLabels{
+		capiv1alpha3.ClusterLabelName:             turtle.Name,
+		capiv1alpha3.MachineControlPlaneLabelName: "",
+	}); err != nil {
+		return fmt.Errorf("failed to list control plane machines: %w", err)
+	}
+
+	kubeconfig := &corev1.Secret{}
+	kubeconfigFound := true
+	if err := r.Get(ctx, types.NamespacedName{Namespace: turtle.Namespace, Name: secret.Name(turtle.Name, secret.Kubeconfig)}, kubeconfig); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to get kubeconfig: %w", err)
+		}
+		kubeconfigFound = false
+	}
+
+	if endpoint := cluster.Spec.ControlPlaneEndpoint; !endpoint.IsZero() {
+		status.APIEndpoint = endpoint.String()
+	}
+
+	status.ControlPlane = infrav1alpha1.ControlPlaneStatus{
+		Replicas:        controlPlane.Status.Replicas,
+		ReadyReplicas:   controlPlane.Status.ReadyReplicas,
+		UpdatedReplicas: controlPlane.Status.UpdatedReplicas,
+		Version:         controlPlane.Spec.Version,
+		FailureDomains:  machineFailureDomains(controlPlaneMachines.Items),
+	}
+
+	var failures []string
+	if cluster.Status.FailureReason != nil {
+		status.FailureReason = string(*cluster.Status.FailureReason)
+	}
+	if cluster.Status.FailureMessage != nil {
+		failures = append(failures, *cluster.Status.FailureMessage)
+	}
+	if controlPlane.Status.FailureReason != "" && status.FailureReason == "" {
+		status.FailureReason = string(controlPlane.Status.FailureReason)
+	}
+	if controlPlane.Status.FailureMessage != nil {
+		failures = append(failures, *controlPlane.Status.FailureMessage)
+	}
+
+	hatchlingsReady := true
+	var waiting []string
+	status.Hatchlings = nil
+	for _, hatchling := range turtle.Spec.Hatchlings {
+		md := &capiv1alpha3.MachineDeployment{}
+		key := types.NamespacedName{Namespace: turtle.Namespace, Name: hatchlingResourceName(turtle, &hatchling)}
+		if err := r.Get(ctx, key, md); client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("failed to get machine deployment %s: %w", key.Name, err)
+		}
+
+		status.Hatchlings = append(status.Hatchlings, infrav1alpha1.HatchlingStatus{
+			Name:            hatchling.Name,
+			Replicas:        hatchling.Replicas,
+			ReadyReplicas:   md.Status.ReadyReplicas,
+			UpdatedReplicas: md.Status.UpdatedReplicas,
+			Phase:           md.Status.Phase,
+			Version:         machineDeploymentVersion(md),
+		})
+
+		if md.Status.Phase == string(capiv1alpha3.MachineDeploymentPhaseFailed) {
+			failures = append(failures, fmt.Sprintf("machine deployment %s failed", key.Name))
+		}
+		if md.Status.ReadyReplicas < hatchling.Replicas {
+			hatchlingsReady = false
+			waiting = append(waiting, fmt.Sprintf("%s %d/%d", hatchling.Name, md.Status.ReadyReplicas, hatchling.Replicas))
+		}
+	}
+	status.FailureMessage = strings.Join(failures, "; ")
+
+	conditions := &status.Conditions
+	setReadiness(conditions, infrav1alpha1.TurtleInfrastructureReadyCondition, cluster.Status.InfrastructureReady,
+		"InfrastructureProvisioning", "waiting for azure cluster to be provisioned")
+	setReadiness(conditions, infrav1alpha1.TurtleControlPlaneReadyCondition, controlPlane.Status.Ready,
+		"ControlPlaneProvisioning", fmt.Sprintf("%d of %d control plane replicas ready", controlPlane.Status.ReadyReplicas, turtle.Spec.ControlPlaneReplicas))
+	setReadiness(conditions, infrav1alpha1.TurtleKubeconfigAvailableCondition, kubeconfigFound,
+		"KubeconfigMissing", "waiting for cluster api to create the kubeconfig")
+	setReadiness(conditions, infrav1alpha1.TurtleHatchlingsReadyCondition, hatchlingsReady,
+		"HatchlingsScaling", fmt.Sprintf("waiting for ready nodes: %s", strings.Join(waiting, ", ")))
+
+	ready := cluster.Status.InfrastructureReady && controlPlane.Status.Ready && kubeconfigFound && hatchlingsReady
+	setReadiness(conditions, infrav1alpha1.TurtleReadyCondition, ready, "NotReady", "")
+
+	switch {
+	case !turtle.DeletionTimestamp.IsZero():
+		status.Phase = infrav1alpha1.TurtlePhaseDeleting
+	case status.FailureReason != "" || status.FailureMessage != "":
+		status.Phase = infrav1alpha1.TurtlePhaseFailed
+	case !clusterFound:
+		status.Phase = infrav1alpha1.TurtlePhasePending
+	case cluster.Status.ControlPlaneInitialized && kubeconfigFound:
+		status.Phase = infrav1alpha1.TurtlePhaseProvisioned
+	default:
+		status.Phase = infrav1alpha1.TurtlePhaseProvisioning
+	}
+
+	return r.Status().Update(ctx, turtle)
+}
+
+// setReadiness sets a condition to true, or to false with the given reason
+// and message.
+func setReadiness(conditions *infrav1alpha1.Conditions, conditionType infrav1alpha1.ConditionType, ready bool, reason, message string) {
+	if ready {
+		setCondition(conditions, conditionType, corev1.ConditionTrue, "", "")
+		return
+	}
+	setCondition(conditions, conditionType, corev1.ConditionFalse, reason, message)
+}
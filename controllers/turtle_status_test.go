@@ -0,0 +1,255 @@
+// Copyright 2020 Alexander Eldeib
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS
+// OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+// CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+// TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	capiv1alpha3 "sigs.k8s.io/cluster-api/api/v1alpha3"
+	kcpv1alpha3 "sigs.k8s.io/cluster-api/controlplane/kubeadm/api/v1alpha3"
+	capierrors "sigs.k8s.io/cluster-api/errors"
+	"sigs.k8s.io/cluster-api/util/secret"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	infrav1alpha1 "github.com/alexeldeib/bale/api/v1alpha1"
+)
+
+func TestUpdateStatus(t *testing.T) {
+	turtle := &infrav1alpha1.Turtle{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "turtle", Generation: 2},
+		Spec: infrav1alpha1.TurtleSpec{
+			ControlPlaneReplicas: 3,
+			Hatchlings:           []infrav1alpha1.HatchlingSpec{{Name: "default", Replicas: 2}},
+		},
+	}
+	meta := metav1.ObjectMeta{Namespace: "default", Name: "turtle"}
+	cluster := func(mutate func(*capiv1alpha3.Cluster)) *capiv1alpha3.Cluster {
+		cluster := &capiv1alpha3.Cluster{
+			ObjectMeta: meta,
+			Spec: capiv1alpha3.ClusterSpec{
+				ControlPlaneEndpoint: capiv1alpha3.APIEndpoint{Host: "turtle.westus2.cloudapp.azure.com", Port: 6443},
+			},
+			Status: capiv1alpha3.ClusterStatus{InfrastructureReady: true, ControlPlaneInitialized: true},
+		}
+		if mutate != nil {
+			mutate(cluster)
+		}
+		return cluster
+	}
+	controlPlane := func(mutate func(*kcpv1alpha3.KubeadmControlPlane)) *kcpv1alpha3.KubeadmControlPlane {
+		controlPlane := &kcpv1alpha3.KubeadmControlPlane{
+			ObjectMeta: meta,
+			Spec:       kcpv1alpha3.KubeadmControlPlaneSpec{Version: "v1.18.3"},
+			Status:     kcpv1alpha3.KubeadmControlPlaneStatus{Replicas: 3, ReadyReplicas: 3, UpdatedReplicas: 3, Ready: true},
+		}
+		if mutate != nil {
+			mutate(controlPlane)
+		}
+		return controlPlane
+	}
+	machineDeployment := func(phase capiv1alpha3.MachineDeploymentPhase, ready int32) *capiv1alpha3.MachineDeployment {
+		return &capiv1alpha3.MachineDeployment{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "turtle-default"},
+			Status:     capiv1alpha3.MachineDeploymentStatus{Phase: string(phase), ReadyReplicas: ready, UpdatedReplicas: ready},
+		}
+	}
+	kubeconfig := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: secret.Name("turtle", secret.Kubeconfig)}}
+
+	failed := turtle.DeepCopy()
+	failed.Status = infrav1alpha1.TurtleStatus{
+		Phase:          infrav1alpha1.TurtlePhaseFailed,
+		FailureReason:  "CreateError",
+		FailureMessage: "quota exceeded",
+		Hatchlings:     []infrav1alpha1.HatchlingStatus{{Name: "removed"}, {Name: "default"}},
+	}
+	setCondition(&failed.Status.Conditions, infrav1alpha1.TurtleReadyCondition, corev1.ConditionFalse, "NotReady", "")
+	now := metav1.Now()
+	deleting := turtle.DeepCopy()
+	deleting.DeletionTimestamp = &now
+	createError := capierrors.CreateClusterError
+	quota := "quota exceeded"
+
+	cases := map[string]struct {
+		turtle      *infrav1alpha1.Turtle
+		objects     []runtime.Object
+		wantPhase   infrav1alpha1.TurtlePhase
+		wantReady   bool
+		wantFalse   map[infrav1alpha1.ConditionType]string
+		wantFailure string
+		wantStatus  func(g *WithT, status infrav1alpha1.TurtleStatus)
+	}{
+		"pending without a cluster": {
+			wantPhase: infrav1alpha1.TurtlePhasePending,
+			wantFalse: map[infrav1alpha1.ConditionType]string{
+				infrav1alpha1.TurtleInfrastructureReadyCondition: "InfrastructureProvisioning",
+				infrav1alpha1.TurtleControlPlaneReadyCondition:   "ControlPlaneProvisioning",
+				infrav1alpha1.TurtleKubeconfigAvailableCondition: "KubeconfigMissing",
+				infrav1alpha1.TurtleHatchlingsReadyCondition:     "HatchlingsScaling",
+			},
+			wantStatus: func(g *WithT, status infrav1alpha1.TurtleStatus) {
+				g.Expect(status.APIEndpoint).To(BeEmpty())
+				g.Expect(status.Hatchlings).To(ConsistOf(infrav1alpha1.HatchlingStatus{Name: "default", Replicas: 2}))
+			},
+		},
+		"provisioning until the control plane is initialized": {
+			objects: []runtime.Object{
+				cluster(func(c *capiv1alpha3.Cluster) { c.Status.ControlPlaneInitialized = false }),
+				controlPlane(func(cp *kcpv1alpha3.KubeadmControlPlane) {
+					cp.Status.ReadyReplicas = 1
+					cp.Status.Ready = false
+				}),
+			},
+			wantPhase: infrav1alpha1.TurtlePhaseProvisioning,
+			wantFalse: map[infrav1alpha1.ConditionType]string{
+				infrav1alpha1.TurtleControlPlaneReadyCondition:   "ControlPlaneProvisioning",
+				infrav1alpha1.TurtleKubeconfigAvailableCondition: "KubeconfigMissing",
+			},
+			wantStatus: func(g *WithT, status infrav1alpha1.TurtleStatus) {
+				condition := getCondition(status.Conditions, infrav1alpha1.TurtleControlPlaneReadyCondition)
+				g.Expect(condition.Message).To(Equal("1 of 3 control plane replicas ready"))
+			},
+		},
+		"waits for hatchlings to scale": {
+			objects:   []runtime.Object{cluster(nil), controlPlane(nil), kubeconfig, machineDeployment(capiv1alpha3.MachineDeploymentPhaseScalingUp, 1)},
+			wantPhase: infrav1alpha1.TurtlePhaseProvisioned,
+			wantFalse: map[infrav1alpha1.ConditionType]string{
+				infrav1alpha1.TurtleHatchlingsReadyCondition: "HatchlingsScaling",
+			},
+			wantStatus: func(g *WithT, status infrav1alpha1.TurtleStatus) {
+				condition := getCondition(status.Conditions, infrav1alpha1.TurtleHatchlingsReadyCondition)
+				g.Expect(condition.Message).To(Equal("waiting for ready nodes: default 1/2"))
+			},
+		},
+		"ready": {
+			objects:   []runtime.Object{cluster(nil), controlPlane(nil), kubeconfig, machineDeployment(capiv1alpha3.MachineDeploymentPhaseRunning, 2)},
+			wantPhase: infrav1alpha1.TurtlePhaseProvisioned,
+			wantReady: true,
+			wantStatus: func(g *WithT, status infrav1alpha1.TurtleStatus) {
+				g.Expect(status.APIEndpoint).To(Equal("turtle.westus2.cloudapp.azure.com:6443"))
+				g.Expect(status.ControlPlane).To(Equal(infrav1alpha1.ControlPlaneStatus{Replicas: 3, ReadyReplicas: 3, UpdatedReplicas: 3, Version: "v1.18.3"}))
+				g.Expect(status.Hatchlings).To(ConsistOf(infrav1alpha1.HatchlingStatus{
+					Name: "default", Replicas: 2, ReadyReplicas: 2, UpdatedReplicas: 2, Phase: string(capiv1alpha3.MachineDeploymentPhaseRunning),
+				}))
+			},
+		},
+		"clears failures and removed hatchlings once recovered": {
+			turtle:    failed,
+			objects:   []runtime.Object{cluster(nil), controlPlane(nil), kubeconfig, machineDeployment(capiv1alpha3.MachineDeploymentPhaseRunning, 2)},
+			wantPhase: infrav1alpha1.TurtlePhaseProvisioned,
+			wantReady: true,
+			wantStatus: func(g *WithT, status infrav1alpha1.TurtleStatus) {
+				g.Expect(status.Hatchlings).To(HaveLen(1))
+				g.Expect(status.Hatchlings[0].Name).To(Equal("default"))
+			},
+		},
+		"surfaces failures of the cluster, control plane and hatchlings": {
+			objects: []runtime.Object{
+				cluster(func(c *capiv1alpha3.Cluster) {
+					c.Status.FailureReason = &createError
+					c.Status.FailureMessage = &quota
+				}),
+				controlPlane(func(cp *kcpv1alpha3.KubeadmControlPlane) {
+					message := "control plane unhealthy"
+					cp.Status.FailureReason = capierrors.InvalidConfigurationKubeadmControlPlaneError
+					cp.Status.FailureMessage = &message
+				}),
+				kubeconfig,
+				machineDeployment(capiv1alpha3.MachineDeploymentPhaseFailed, 2),
+			},
+			wantPhase:   infrav1alpha1.TurtlePhaseFailed,
+			wantReady:   true,
+			wantFailure: "quota exceeded; control plane unhealthy; machine deployment turtle-default failed",
+			wantStatus: func(g *WithT, status infrav1alpha1.TurtleStatus) {
+				g.Expect(status.FailureReason).To(Equal(string(createError)), "the cluster's reason wins")
+			},
+		},
+		"deleting": {
+			turtle:    deleting,
+			objects:   []runtime.Object{cluster(nil), controlPlane(nil), kubeconfig, machineDeployment(capiv1alpha3.MachineDeploymentPhaseRunning, 2)},
+			wantPhase: infrav1alpha1.TurtlePhaseDeleting,
+			wantReady: true,
+		},
+	}
+
+	for name, tc := range cases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			g := NewWithT(t)
+			ctx := context.Background()
+
+			scheme := runtime.NewScheme()
+			g.Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+			g.Expect(infrav1alpha1.AddToScheme(scheme)).To(Succeed())
+			g.Expect(capiv1alpha3.AddToScheme(scheme)).To(Succeed())
+			g.Expect(kcpv1alpha3.AddToScheme(scheme)).To(Succeed())
+
+			turtle := turtle.DeepCopy()
+			if tc.turtle != nil {
+				turtle = tc.turtle.DeepCopy()
+			}
+			r := &TurtleReconciler{Client: fake.NewFakeClientWithScheme(scheme, append(tc.objects, turtle.DeepCopy())...), Scheme: scheme}
+			g.Expect(r.updateStatus(ctx, turtle)).To(Succeed())
+
+			stored := &infrav1alpha1.Turtle{}
+			g.Expect(r.Get(ctx, types.NamespacedName{Namespace: "default", Name: "turtle"}, stored)).To(Succeed())
+			status := stored.Status
+			g.Expect(status.ObservedGeneration).To(Equal(int64(2)))
+			g.Expect(status.Phase).To(Equal(tc.wantPhase))
+			g.Expect(status.FailureMessage).To(Equal(tc.wantFailure))
+			if tc.wantFailure == "" {
+				g.Expect(status.FailureReason).To(BeEmpty())
+			}
+			g.Expect(isConditionTrue(status.Conditions, infrav1alpha1.TurtleReadyCondition)).To(Equal(tc.wantReady))
+			for conditionType, reason := range tc.wantFalse {
+				condition := getCondition(status.Conditions, conditionType)
+				g.Expect(condition).NotTo(BeNil(), "%s", conditionType)
+				g.Expect(condition.Status).To(Equal(corev1.ConditionFalse), "%s", conditionType)
+				g.Expect(condition.Reason).To(Equal(reason), "%s", conditionType)
+			}
+			if tc.wantStatus != nil {
+				tc.wantStatus(g, status)
+			}
+		})
+	}
+}
+
+func TestUpdateStatusErrors(t *testing.T) {
+	g := NewWithT(t)
+
+	// Without Cluster API types in the scheme, reads fail with errors other
+	// than not found, which must not be mistaken for missing objects.
+	scheme := runtime.NewScheme()
+	g.Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+	g.Expect(infrav1alpha1.AddToScheme(scheme)).To(Succeed())
+
+	turtle := &infrav1alpha1.Turtle{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "turtle"}}
+	r := &TurtleReconciler{Client: fake.NewFakeClientWithScheme(scheme, turtle.DeepCopy()), Scheme: scheme}
+	g.Expect(r.updateStatus(context.Background(), turtle)).To(MatchError(ContainSubstring("failed to get cluster")))
+	g.Expect(turtle.Status.Phase).To(BeEmpty())
+}
@@ -0,0 +1,201 @@
+// Copyright 2020 Alexander Eldeib
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS
+// OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+// CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+// TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/util/errors"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	infrav1alpha1 "github.com/alexeldeib/bale/api/v1alpha1"
+)
+
+// reconcileStep is one step of reconciling a turtle.
+type reconcileStep struct {
+	name string
+	// after names the steps which have to succeed before this one runs.
+	after []string
+	// exclusive steps write to the turtle, so they never run alongside
+	// other steps.
+	exclusive bool
+	fn        func(context.Context, *infrav1alpha1.Turtle) error
+}
+
+// waitingError is returned by a step which can't complete until something
+// outside of the controller happens, e.g. Cluster API creating an object the
+// step needs. The step is retried after an interval rather than backing off
+// like on errors.
+type waitingError struct {
+	reason       string
+	message      string
+	requeueAfter time.Duration
+}
+
+func (e *waitingError) Error() string {
+	return e.message
+}
+
+// waitFor returns an error reporting that a step is waiting.
+func waitFor(reason, message string, requeueAfter time.Duration) error {
+	return &waitingError{reason: reason, message: message, requeueAfter: requeueAfter}
+}
+
+// stepState is the outcome of a step in one reconcile.
+type stepState int
+
+const (
+	stepPending stepState = iota
+	stepDone
+	// stepStopped steps failed, waited or were skipped, so the steps after
+	// them are skipped.
+	stepStopped
+)
+
+// runSteps runs each step once the steps it comes after are done, together
+// with the other steps which become ready at the same time. Steps after a
+// step which fails or waits are skipped until the next reconcile. Waiting
+// and failed steps are reported through the Reconciled condition.
+func runSteps(ctx context.Context, log logr.Logger, turtle *infrav1alpha1.Turtle, steps []reconcileStep) (ctrl.Result, error) {
+	states := make(map[string]stepState, len(steps))
+	for _, step := range steps {
+		states[step.name] = stepPending
+	}
+
+	results := make(map[string]error, len(steps))
+	remaining := steps
+	for len(remaining) > 0 {
+		var ready, next []reconcileStep
+		for _, step := range remaining {
+			switch afterState(states, step.after) {
+			case stepDone:
+				ready = append(ready, step)
+			case stepStopped:
+				states[step.name] = stepStopped
+			default:
+				next = append(next, step)
+			}
+		}
+
+		if len(ready) == 0 {
+			// Only steps after unknown or cyclic steps are left.
+			for _, step := range next {
+				results[step.name] = fmt.Errorf("step %s can't run after %v", step.name, step.after)
+			}
+			break
+		}
+
+		var concurrent []reconcileStep
+		for _, step := range ready {
+			if step.exclusive {
+				results[step.name] = step.fn(ctx, turtle)
+				continue
+			}
+			concurrent = append(concurrent, step)
+		}
+
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		for _, step := range concurrent {
+			step := step
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				err := step.fn(ctx, turtle)
+				mu.Lock()
+				defer mu.Unlock()
+				results[step.name] = err
+			}()
+		}
+		wg.Wait()
+
+		for _, step := range ready {
+			states[step.name] = stepDone
+			if results[step.name] != nil {
+				states[step.name] = stepStopped
+			}
+		}
+		remaining = next
+	}
+
+	var errs []error
+	var requeueAfter time.Duration
+	var reason string
+	var messages []string
+	for _, step := range steps {
+		err, ok := results[step.name]
+		if !ok || err == nil {
+			continue
+		}
+
+		messages = append(messages, fmt.Sprintf("%s: %s", step.name, err))
+		var waiting *waitingError
+		if errors.As(err, &waiting) {
+			log.Info("waiting", "step", step.name, "reason", waiting.reason, "message", waiting.message)
+			if reason == "" {
+				reason = waiting.reason
+			}
+			if requeueAfter == 0 || waiting.requeueAfter < requeueAfter {
+				requeueAfter = waiting.requeueAfter
+			}
+			continue
+		}
+		errs = append(errs, fmt.Errorf("step %s failed: %w", step.name, err))
+	}
+
+	conditions := &turtle.Status.Conditions
+	switch {
+	case len(errs) > 0:
+		setCondition(conditions, infrav1alpha1.TurtleReconciledCondition, corev1.ConditionFalse, "ReconcileError", strings.Join(messages, "; "))
+		return ctrl.Result{}, kerrors.NewAggregate(errs)
+	case len(messages) > 0:
+		setCondition(conditions, infrav1alpha1.TurtleReconciledCondition, corev1.ConditionFalse, reason, strings.Join(messages, "; "))
+		return ctrl.Result{RequeueAfter: requeueAfter}, nil
+	}
+
+	setCondition(conditions, infrav1alpha1.TurtleReconciledCondition, corev1.ConditionTrue, "", "")
+	return ctrl.Result{}, nil
+}
+
+// afterState returns stepDone if all the given steps are done, stepStopped
+// if any of them stopped and stepPending otherwise.
+func afterState(states map[string]stepState, after []string) stepState {
+	state := stepDone
+	for _, name := range after {
+		switch current, ok := states[name]; {
+		case !ok:
+			return stepPending
+		case current == stepStopped:
+			return stepStopped
+		case current == stepPending:
+			state = stepPending
+		}
+	}
+	return state
+}
@@ -0,0 +1,115 @@
+// Copyright 2020 Alexander Eldeib
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS
+// OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+// CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+// TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	infrav1alpha1 "github.com/alexeldeib/bale/api/v1alpha1"
+)
+
+func TestRunSteps(t *testing.T) {
+	cases := map[string]struct {
+		outcomes   map[string]error
+		wantRan    []string
+		wantResult ctrl.Result
+		wantErr    bool
+		wantReason string
+	}{
+		"all done": {
+			wantRan:    []string{"a", "b", "c", "d"},
+			wantResult: ctrl.Result{},
+		},
+		"waiting skips dependents": {
+			outcomes:   map[string]error{"b": waitFor("WaitingForB", "b isn't there", time.Minute)},
+			wantRan:    []string{"a", "b", "c"},
+			wantResult: ctrl.Result{RequeueAfter: time.Minute},
+			wantReason: "WaitingForB",
+		},
+		"shortest wait wins": {
+			outcomes: map[string]error{
+				"b": waitFor("WaitingForB", "b isn't there", time.Minute),
+				"c": waitFor("WaitingForC", "c isn't there", time.Second),
+			},
+			wantRan:    []string{"a", "b", "c"},
+			wantResult: ctrl.Result{RequeueAfter: time.Second},
+			wantReason: "WaitingForB",
+		},
+		"error skips dependents only": {
+			outcomes:   map[string]error{"a": errors.New("boom")},
+			wantRan:    []string{"a", "c"},
+			wantErr:    true,
+			wantReason: "ReconcileError",
+		},
+	}
+
+	for name, tc := range cases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			var mu sync.Mutex
+			ran := map[string]bool{}
+			step := func(name string, after ...string) reconcileStep {
+				return reconcileStep{
+					name:  name,
+					after: after,
+					fn: func(context.Context, *infrav1alpha1.Turtle) error {
+						mu.Lock()
+						defer mu.Unlock()
+						for _, dependency := range after {
+							g.Expect(ran).To(HaveKey(dependency))
+						}
+						ran[name] = true
+						return tc.outcomes[name]
+					},
+				}
+			}
+
+			turtle := &infrav1alpha1.Turtle{}
+			result, err := runSteps(context.Background(), ctrl.Log, turtle, []reconcileStep{
+				step("a"),
+				step("b", "a"),
+				step("c"),
+				step("d", "b", "c"),
+			})
+
+			g.Expect(err != nil).To(Equal(tc.wantErr))
+			g.Expect(result).To(Equal(tc.wantResult))
+			g.Expect(ran).To(HaveLen(len(tc.wantRan)))
+			for _, name := range tc.wantRan {
+				g.Expect(ran).To(HaveKey(name))
+			}
+
+			condition := getCondition(turtle.Status.Conditions, infrav1alpha1.TurtleReconciledCondition)
+			g.Expect(condition).NotTo(BeNil())
+			g.Expect(condition.Reason).To(Equal(tc.wantReason))
+		})
+	}
+}
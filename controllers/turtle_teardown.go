@@ -0,0 +1,280 @@
+// Copyright 2020 Alexander Eldeib
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS
+// OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+// CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+// TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	capzv1alpha3 "sigs.k8s.io/cluster-api-provider-azure/api/v1alpha3"
+	capiv1alpha3 "sigs.k8s.io/cluster-api/api/v1alpha3"
+	kcpv1alpha3 "sigs.k8s.io/cluster-api/controlplane/kubeadm/api/v1alpha3"
+	"sigs.k8s.io/cluster-api/util/secret"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	infrav1alpha1 "github.com/alexeldeib/bale/api/v1alpha1"
+)
+
+// teardownRequeueInterval is how often a turtle being deleted is revisited
+// while waiting on one of the teardown steps.
+const teardownRequeueInterval = 15 * time.Second
+
+// teardownStep deletes part of a turtle's cluster and reports whether it is
+// completely gone.
+type teardownStep struct {
+	reason string
+	fn     func(context.Context, *infrav1alpha1.Turtle) (bool, error)
+}
+
+// reconcileDelete tears down the cluster of a turtle in order. Load
+// balancers and volumes are released from inside the cluster first, because
+// deleting the cluster underneath them would leak the Azure resources. The
+// nodes follow, then the control plane and the infrastructure, and the
+// finalizer is only removed once Cluster API has deleted everything.
+func (r *TurtleReconciler) reconcileDelete(ctx context.Context, log logr.Logger, turtle *infrav1alpha1.Turtle) (ctrl.Result, error) {
+	if !hasFinalizer(turtle) {
+		return ctrl.Result{}, nil
+	}
+
+	steps := []teardownStep{
+		{reason: "ResumingCluster", fn: r.resumeClusterForTeardown},
+		{reason: "WaitingForRemoteCleanup", fn: r.cleanupRemote},
+		{reason: "WaitingForMachineDeployments", fn: r.deleteMachineDeployments},
+		{reason: "WaitingForCluster", fn: r.deleteCluster},
+	}
+
+	for _, step := range steps {
+		done, err := step.fn(ctx, turtle)
+		if err != nil {
+			setCondition(&turtle.Status.Conditions, infrav1alpha1.TurtleDeletingCondition, corev1.ConditionTrue, step.reason, err.Error())
+			return ctrl.Result{}, err
+		}
+		if !done {
+			log.Info("waiting for teardown", "step", step.reason)
+			setCondition(&turtle.Status.Conditions, infrav1alpha1.TurtleDeletingCondition, corev1.ConditionTrue, step.reason, "")
+			return ctrl.Result{RequeueAfter: teardownRequeueInterval}, nil
+		}
+	}
+
+	if r.Tracker != nil {
+		r.Tracker.Stop(types.NamespacedName{Namespace: turtle.Namespace, Name: turtle.Name})
+	}
+
+	log.Info("cluster torn down, removing finalizer")
+	controllerutil.RemoveFinalizer(turtle, infrav1alpha1.TurtleFinalizer)
+	if err := r.Update(ctx, turtle); err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to remove finalizer: %w", err)
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// resumeClusterForTeardown resumes the cluster of a turtle deleted while
+// paused, so Cluster API deletes it. Pauses the controller didn't set are
+// kept, and teardown waits for them to be lifted.
+func (r *TurtleReconciler) resumeClusterForTeardown(ctx context.Context, turtle *infrav1alpha1.Turtle) (bool, error) {
+	cluster := &capiv1alpha3.Cluster{}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: turtle.Namespace, Name: turtle.Name}, cluster); err != nil {
+		if apierrors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, fmt.Errorf("failed to get cluster: %w", err)
+	}
+
+	patch := client.MergeFrom(cluster.DeepCopy())
+	resumeCluster(cluster)
+	if err := r.Patch(ctx, cluster, patch); err != nil {
+		return false, fmt.Errorf("failed to resume cluster: %w", err)
+	}
+
+	_, paused := cluster.Annotations[capiv1alpha3.PausedAnnotation]
+	return !paused, nil
+}
+
+// cleanupRemote deletes LoadBalancer services and persistent volume claims
+// in the workload cluster, along with the pods mounting those claims so the
+// volumes can be released. Clusters whose control plane never came up have
+// nothing to clean up.
+func (r *TurtleReconciler) cleanupRemote(ctx context.Context, turtle *infrav1alpha1.Turtle) (bool, error) {
+	if _, ok := turtle.Annotations[infrav1alpha1.SkipRemoteCleanupAnnotation]; ok {
+		return true, nil
+	}
+
+	cluster := &capiv1alpha3.Cluster{}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: turtle.Namespace, Name: turtle.Name}, cluster); err != nil {
+		if apierrors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, fmt.Errorf("failed to get cluster: %w", err)
+	}
+	if !cluster.Status.ControlPlaneInitialized {
+		return true, nil
+	}
+
+	kubeconfig := &corev1.Secret{}
+	key := types.NamespacedName{Namespace: turtle.Namespace, Name: secret.Name(turtle.Name, secret.Kubeconfig)}
+	if err := r.Get(ctx, key, kubeconfig); err != nil {
+		if apierrors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, fmt.Errorf("failed to get kubeconfig: %w", err)
+	}
+
+	remoteClient, err := newRemoteClient(ctx, r.Client, r.Tracker, turtle)
+	if err != nil {
+		return false, err
+	}
+
+	var services corev1.ServiceList
+	if err := remoteClient.List(ctx, &services); err != nil {
+		return false, fmt.Errorf("failed to list remote services: %w", err)
+	}
+
+	var claims corev1.PersistentVolumeClaimList
+	if err := remoteClient.List(ctx, &claims); err != nil {
+		return false, fmt.Errorf("failed to list remote persistent volume claims: %w", err)
+	}
+
+	var pods corev1.PodList
+	if err := remoteClient.List(ctx, &pods); err != nil {
+		return false, fmt.Errorf("failed to list remote pods: %w", err)
+	}
+
+	remaining := 0
+	for i := range services.Items {
+		service := &services.Items[i]
+		if service.Spec.Type != corev1.ServiceTypeLoadBalancer {
+			continue
+		}
+		remaining++
+		if err := deleteIfPresent(ctx, remoteClient, service); err != nil {
+			return false, fmt.Errorf("failed to delete remote service %s/%s: %w", service.Namespace, service.Name, err)
+		}
+	}
+
+	mounted := map[types.NamespacedName]bool{}
+	for i := range claims.Items {
+		claim := &claims.Items[i]
+		mounted[types.NamespacedName{Namespace: claim.Namespace, Name: claim.Name}] = true
+		remaining++
+		if err := deleteIfPresent(ctx, remoteClient, claim); err != nil {
+			return false, fmt.Errorf("failed to delete remote persistent volume claim %s/%s: %w", claim.Namespace, claim.Name, err)
+		}
+	}
+
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		for _, volume := range pod.Spec.Volumes {
+			if volume.PersistentVolumeClaim == nil {
+				continue
+			}
+			if !mounted[types.NamespacedName{Namespace: pod.Namespace, Name: volume.PersistentVolumeClaim.ClaimName}] {
+				continue
+			}
+			if err := deleteIfPresent(ctx, remoteClient, pod); err != nil {
+				return false, fmt.Errorf("failed to delete remote pod %s/%s: %w", pod.Namespace, pod.Name, err)
+			}
+			break
+		}
+	}
+
+	return remaining == 0, nil
+}
+
+// deleteMachineDeployments deletes the machine deployments of a turtle.
+func (r *TurtleReconciler) deleteMachineDeployments(ctx context.Context, turtle *infrav1alpha1.Turtle) (bool, error) {
+	var mds capiv1alpha3.MachineDeploymentList
+	if err := r.List(ctx, &mds, client.InNamespace(turtle.Namespace), client.MatchingLabels{infrav1alpha1.TurtleNameLabel: turtle.Name}); err != nil {
+		return false, fmt.Errorf("failed to list machine deployments: %w", err)
+	}
+
+	for i := range mds.Items {
+		if err := deleteIfPresent(ctx, r.Client, &mds.Items[i]); err != nil {
+			return false, fmt.Errorf("failed to delete machine deployment %s: %w", mds.Items[i].Name, err)
+		}
+	}
+
+	return len(mds.Items) == 0, nil
+}
+
+// deleteCluster deletes the control plane and the cluster, and waits for
+// Cluster API to delete the Azure infrastructure.
+func (r *TurtleReconciler) deleteCluster(ctx context.Context, turtle *infrav1alpha1.Turtle) (bool, error) {
+	key := types.NamespacedName{Namespace: turtle.Namespace, Name: turtle.Name}
+	objects := []runtime.Object{
+		&kcpv1alpha3.KubeadmControlPlane{},
+		&capiv1alpha3.Cluster{},
+		&capzv1alpha3.AzureCluster{},
+	}
+
+	done := true
+	for _, obj := range objects {
+		if err := r.Get(ctx, key, obj); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return false, fmt.Errorf("failed to get %T: %w", obj, err)
+		}
+		done = false
+
+		// The AzureCluster is deleted by Cluster API once the control plane
+		// is gone, which is what releases the resource group.
+		if _, ok := obj.(*capzv1alpha3.AzureCluster); ok {
+			continue
+		}
+		if err := deleteIfPresent(ctx, r.Client, obj); err != nil {
+			return false, fmt.Errorf("failed to delete %T: %w", obj, err)
+		}
+	}
+
+	return done, nil
+}
+
+// deleteIfPresent deletes an object unless it is already being deleted.
+func deleteIfPresent(ctx context.Context, c client.Client, obj runtime.Object) error {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return err
+	}
+	if !accessor.GetDeletionTimestamp().IsZero() {
+		return nil
+	}
+	return client.IgnoreNotFound(c.Delete(ctx, obj))
+}
+
+func hasFinalizer(turtle *infrav1alpha1.Turtle) bool {
+	for _, finalizer := range turtle.Finalizers {
+		if finalizer == infrav1alpha1.TurtleFinalizer {
+			return true
+		}
+	}
+	return false
+}
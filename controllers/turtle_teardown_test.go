@@ -0,0 +1,200 @@
+// Copyright 2020 Alexander Eldeib
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS
+// OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+// CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+// TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	capzv1alpha3 "sigs.k8s.io/cluster-api-provider-azure/api/v1alpha3"
+	capiv1alpha3 "sigs.k8s.io/cluster-api/api/v1alpha3"
+	kcpv1alpha3 "sigs.k8s.io/cluster-api/controlplane/kubeadm/api/v1alpha3"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	infrav1alpha1 "github.com/alexeldeib/bale/api/v1alpha1"
+)
+
+// failingDeleteClient fails deletes of the objects matched by fail.
+type failingDeleteClient struct {
+	client.Client
+	fail func(obj runtime.Object) bool
+}
+
+func (c *failingDeleteClient) Delete(ctx context.Context, obj runtime.Object, opts ...client.DeleteOption) error {
+	if c.fail(obj) {
+		return errors.New("injected failure")
+	}
+	return c.Client.Delete(ctx, obj, opts...)
+}
+
+func TestReconcileDelete(t *testing.T) {
+	now := metav1.Now()
+	turtle := &infrav1alpha1.Turtle{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:         "default",
+			Name:              "turtle",
+			DeletionTimestamp: &now,
+			Finalizers:        []string{infrav1alpha1.TurtleFinalizer},
+		},
+	}
+	meta := metav1.ObjectMeta{Namespace: "default", Name: "turtle"}
+	cluster := &capiv1alpha3.Cluster{ObjectMeta: meta}
+	pausedCluster := &capiv1alpha3.Cluster{ObjectMeta: meta}
+	pausedCluster.Annotations = map[string]string{capiv1alpha3.PausedAnnotation: "true"}
+	controlPlane := &kcpv1alpha3.KubeadmControlPlane{ObjectMeta: meta}
+	azureCluster := &capzv1alpha3.AzureCluster{ObjectMeta: meta}
+	md := &capiv1alpha3.MachineDeployment{ObjectMeta: metav1.ObjectMeta{
+		Namespace: "default",
+		Name:      "turtle-default",
+		Labels:    map[string]string{infrav1alpha1.TurtleNameLabel: "turtle"},
+	}}
+	failMachineDeployments := func(obj runtime.Object) bool {
+		_, ok := obj.(*capiv1alpha3.MachineDeployment)
+		return ok
+	}
+	failControlPlane := func(obj runtime.Object) bool {
+		_, ok := obj.(*kcpv1alpha3.KubeadmControlPlane)
+		return ok
+	}
+
+	cases := map[string]struct {
+		objects       []runtime.Object
+		fail          func(obj runtime.Object) bool
+		wantReason    string
+		wantError     string
+		wantFinalizer bool
+		wantGone      []runtime.Object
+		wantKept      []runtime.Object
+	}{
+		"waits for pauses it didn't set": {
+			objects:       []runtime.Object{pausedCluster, controlPlane, azureCluster, md},
+			wantReason:    "ResumingCluster",
+			wantFinalizer: true,
+			wantKept:      []runtime.Object{md.DeepCopy(), controlPlane.DeepCopy(), cluster.DeepCopy()},
+		},
+		"deletes machine deployments before the control plane": {
+			objects:       []runtime.Object{cluster, controlPlane, azureCluster, md},
+			wantReason:    "WaitingForMachineDeployments",
+			wantFinalizer: true,
+			wantGone:      []runtime.Object{md.DeepCopy()},
+			wantKept:      []runtime.Object{controlPlane.DeepCopy(), cluster.DeepCopy(), azureCluster.DeepCopy()},
+		},
+		"deletes the control plane and cluster once nodes are gone": {
+			objects:       []runtime.Object{cluster, controlPlane, azureCluster},
+			wantReason:    "WaitingForCluster",
+			wantFinalizer: true,
+			wantGone:      []runtime.Object{controlPlane.DeepCopy(), cluster.DeepCopy()},
+			wantKept:      []runtime.Object{azureCluster.DeepCopy()},
+		},
+		"waits for cluster api to delete the infrastructure": {
+			objects:       []runtime.Object{azureCluster},
+			wantReason:    "WaitingForCluster",
+			wantFinalizer: true,
+			wantKept:      []runtime.Object{azureCluster.DeepCopy()},
+		},
+		"keeps the finalizer and the cluster when deleting nodes fails": {
+			objects:       []runtime.Object{cluster, controlPlane, azureCluster, md},
+			fail:          failMachineDeployments,
+			wantReason:    "WaitingForMachineDeployments",
+			wantError:     "failed to delete machine deployment turtle-default",
+			wantFinalizer: true,
+			wantKept:      []runtime.Object{md.DeepCopy(), controlPlane.DeepCopy(), cluster.DeepCopy()},
+		},
+		"keeps the finalizer when deleting the control plane fails": {
+			objects:       []runtime.Object{cluster, controlPlane, azureCluster},
+			fail:          failControlPlane,
+			wantReason:    "WaitingForCluster",
+			wantError:     "failed to delete *v1alpha3.KubeadmControlPlane",
+			wantFinalizer: true,
+			wantKept:      []runtime.Object{controlPlane.DeepCopy(), cluster.DeepCopy()},
+		},
+		"removes the finalizer once everything is gone": {
+			wantFinalizer: false,
+		},
+	}
+
+	for name, tc := range cases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			g := NewWithT(t)
+			ctx := context.Background()
+
+			scheme := runtime.NewScheme()
+			g.Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+			g.Expect(infrav1alpha1.AddToScheme(scheme)).To(Succeed())
+			g.Expect(capiv1alpha3.AddToScheme(scheme)).To(Succeed())
+			g.Expect(kcpv1alpha3.AddToScheme(scheme)).To(Succeed())
+			g.Expect(capzv1alpha3.AddToScheme(scheme)).To(Succeed())
+
+			objects := []runtime.Object{turtle.DeepCopy()}
+			for _, obj := range tc.objects {
+				objects = append(objects, obj.DeepCopyObject())
+			}
+			var c client.Client = fake.NewFakeClientWithScheme(scheme, objects...)
+			if tc.fail != nil {
+				c = &failingDeleteClient{Client: c, fail: tc.fail}
+			}
+			r := &TurtleReconciler{Client: c, Log: ctrl.Log, Scheme: scheme}
+
+			deleting := &infrav1alpha1.Turtle{}
+			g.Expect(r.Get(ctx, types.NamespacedName{Namespace: "default", Name: "turtle"}, deleting)).To(Succeed())
+			result, err := r.reconcileDelete(ctx, r.Log, deleting)
+			if tc.wantError != "" {
+				g.Expect(err).To(MatchError(ContainSubstring(tc.wantError)))
+			} else {
+				g.Expect(err).NotTo(HaveOccurred())
+			}
+			if tc.wantReason != "" {
+				condition := getCondition(deleting.Status.Conditions, infrav1alpha1.TurtleDeletingCondition)
+				g.Expect(condition).NotTo(BeNil())
+				g.Expect(condition.Reason).To(Equal(tc.wantReason))
+			}
+			if tc.wantFinalizer && tc.wantError == "" {
+				g.Expect(result.RequeueAfter).To(Equal(teardownRequeueInterval))
+			}
+
+			stored := &infrav1alpha1.Turtle{}
+			g.Expect(r.Get(ctx, types.NamespacedName{Namespace: "default", Name: "turtle"}, stored)).To(Succeed())
+			g.Expect(hasFinalizer(stored)).To(Equal(tc.wantFinalizer))
+
+			for _, obj := range tc.wantGone {
+				key, err := client.ObjectKeyFromObject(obj)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(apierrors.IsNotFound(r.Get(ctx, key, obj))).To(BeTrue(), "%T should be deleted", obj)
+			}
+			for _, obj := range tc.wantKept {
+				key, err := client.ObjectKeyFromObject(obj)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(r.Get(ctx, key, obj)).To(Succeed(), "%T should be kept", obj)
+			}
+		})
+	}
+}
@@ -0,0 +1,378 @@
+// Copyright 2020 Alexander Eldeib
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS
+// OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+// CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+// TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/blang/semver"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	capiv1alpha3 "sigs.k8s.io/cluster-api/api/v1alpha3"
+	kcpv1alpha3 "sigs.k8s.io/cluster-api/controlplane/kubeadm/api/v1alpha3"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	infrav1alpha1 "github.com/alexeldeib/bale/api/v1alpha1"
+)
+
+// upgradeMachineDeadline is how long replacing a single machine may take
+// before an upgrade is reported as stalled.
+const upgradeMachineDeadline = 20 * time.Minute
+
+// upgradePlan holds the Kubernetes versions the control plane and the
+// hatchlings of a turtle are set to in one reconcile. Version changes are
+// rolled out one component at a time: the control plane first, then each
+// hatchling in the order of the spec, each waiting for the previous one to
+// be fully rolled out and healthy.
+type upgradePlan struct {
+	controlPlane string
+	// hatchlings maps the names of hatchlings to their versions. Hatchlings
+	// missing from it are not created yet.
+	hatchlings map[string]string
+	// upgrade is the component being upgraded, if any.
+	upgrade *infrav1alpha1.UpgradeStatus
+	// waiting explains why pending version changes don't start yet.
+	waiting string
+	// blocked explains why requested versions can't be rolled out.
+	blocked string
+}
+
+// planUpgrade decides which versions to roll out to a turtle and records
+// the progress of its upgrade in its status.
+func (r *TurtleReconciler) planUpgrade(ctx context.Context, turtle *infrav1alpha1.Turtle) (*upgradePlan, error) {
+	key := types.NamespacedName{Namespace: turtle.Namespace, Name: turtle.Name}
+
+	var controlPlane *kcpv1alpha3.KubeadmControlPlane
+	existing := &kcpv1alpha3.KubeadmControlPlane{}
+	if err := r.Get(ctx, key, existing); err == nil {
+		controlPlane = existing
+	} else if !apierrors.IsNotFound(err) {
+		return nil, fmt.Errorf("failed to get kubeadm control plane: %w", err)
+	}
+
+	var machines capiv1alpha3.MachineList
+	if err := r.List(ctx, &machines, client.InNamespace(turtle.Namespace), client.MatchingLabels{
+		capiv1alpha3.ClusterLabelName:             turtle.Name,
+		capiv1alpha3.MachineControlPlaneLabelName: "",
+	}); err != nil {
+		return nil, fmt.Errorf("failed to list control plane machines: %w", err)
+	}
+
+	mds := map[string]*capiv1alpha3.MachineDeployment{}
+	for _, hatchling := range turtle.Spec.Hatchlings {
+		md := &capiv1alpha3.MachineDeployment{}
+		key := types.NamespacedName{Namespace: turtle.Namespace, Name: hatchlingResourceName(turtle, &hatchling)}
+		if err := r.Get(ctx, key, md); err == nil {
+			mds[hatchling.Name] = md
+		} else if !apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("failed to get machine deployment %s: %w", key.Name, err)
+		}
+	}
+
+	plan := computeUpgradePlan(turtle, controlPlane, machines.Items, mds)
+	setUpgradeStatus(turtle, plan, controlPlane, mds, time.Now())
+	if plan.blocked != "" {
+		r.Log.Info("upgrade blocked", "turtle", turtle.Name, "reason", plan.blocked)
+	}
+
+	return plan, nil
+}
+
+// computeUpgradePlan decides the versions of the components of a turtle
+// from the state of its control plane and machine deployments. A missing
+// control plane means the cluster is created at the requested versions.
+func computeUpgradePlan(
+	turtle *infrav1alpha1.Turtle,
+	controlPlane *kcpv1alpha3.KubeadmControlPlane,
+	machines []capiv1alpha3.Machine,
+	mds map[string]*capiv1alpha3.MachineDeployment,
+) *upgradePlan {
+	plan := &upgradePlan{
+		controlPlane: turtle.Spec.Version,
+		hatchlings:   map[string]string{},
+	}
+
+	if controlPlane == nil {
+		for _, hatchling := range turtle.Spec.Hatchlings {
+			desired := hatchlingVersion(turtle, &hatchling)
+			if err := validateSkew(plan.controlPlane, desired); err != nil {
+				plan.blocked = joinReasons(plan.blocked, fmt.Sprintf("hatchling %s: %s", hatchling.Name, err))
+				continue
+			}
+			plan.hatchlings[hatchling.Name] = desired
+		}
+		return plan
+	}
+
+	current := controlPlane.Spec.Version
+
+	// An upgrade which was started keeps going until its component is
+	// rolled out, even if the spec changed in the meantime.
+	if previous := turtle.Status.Upgrade; previous != nil {
+		if previous.Component == infrav1alpha1.ControlPlaneComponent {
+			if controlPlane.Spec.Version == previous.Version && !controlPlaneRolledOut(controlPlane, machines) {
+				plan.upgrade = previous
+			}
+		} else if md, ok := mds[previous.Component]; ok {
+			if hatchlingRunningVersion(md, current) == previous.Version && !machineDeploymentRolledOut(md) {
+				plan.upgrade = previous
+			}
+		}
+	}
+
+	if current != turtle.Spec.Version {
+		plan.controlPlane = current
+		switch {
+		case plan.upgrade != nil:
+			plan.waiting = fmt.Sprintf("control plane waits for %s to be upgraded", plan.upgrade.Component)
+		default:
+			if err := infrav1alpha1.ValidateUpgrade(current, turtle.Spec.Version); err != nil {
+				plan.blocked = fmt.Sprintf("control plane: %s", err)
+				break
+			}
+			plan.controlPlane = turtle.Spec.Version
+			plan.upgrade = &infrav1alpha1.UpgradeStatus{
+				Component: infrav1alpha1.ControlPlaneComponent,
+				Version:   turtle.Spec.Version,
+			}
+		}
+	}
+
+	controlPlaneDone := plan.controlPlane == current && controlPlaneRolledOut(controlPlane, machines)
+
+	for _, hatchling := range turtle.Spec.Hatchlings {
+		desired := hatchlingVersion(turtle, &hatchling)
+
+		// Nodes must not be newer than the control plane they join.
+		if err := validateSkew(plan.controlPlane, desired); err != nil {
+			plan.blocked = joinReasons(plan.blocked, fmt.Sprintf("hatchling %s: %s", hatchling.Name, err))
+			if md, ok := mds[hatchling.Name]; ok {
+				plan.hatchlings[hatchling.Name] = hatchlingRunningVersion(md, current)
+			}
+			continue
+		}
+
+		md, ok := mds[hatchling.Name]
+		if !ok {
+			// New hatchlings join once the control plane is done upgrading.
+			if plan.upgrade != nil && plan.upgrade.Component == infrav1alpha1.ControlPlaneComponent {
+				plan.waiting = joinReasons(plan.waiting, fmt.Sprintf("hatchling %s waits for the control plane to be upgraded", hatchling.Name))
+				continue
+			}
+			plan.hatchlings[hatchling.Name] = desired
+			continue
+		}
+
+		version := hatchlingRunningVersion(md, current)
+		plan.hatchlings[hatchling.Name] = version
+		if version == desired {
+			continue
+		}
+
+		switch {
+		case plan.upgrade != nil:
+			plan.waiting = joinReasons(plan.waiting, fmt.Sprintf("hatchling %s waits for %s to be upgraded", hatchling.Name, plan.upgrade.Component))
+		case !controlPlaneDone:
+			plan.waiting = joinReasons(plan.waiting, fmt.Sprintf("hatchling %s waits for the control plane to be rolled out and healthy", hatchling.Name))
+		default:
+			if err := infrav1alpha1.ValidateUpgrade(version, desired); err != nil {
+				plan.blocked = joinReasons(plan.blocked, fmt.Sprintf("hatchling %s: %s", hatchling.Name, err))
+				continue
+			}
+			plan.hatchlings[hatchling.Name] = desired
+			plan.upgrade = &infrav1alpha1.UpgradeStatus{
+				Component: hatchling.Name,
+				Version:   desired,
+			}
+		}
+	}
+
+	return plan
+}
+
+// setUpgradeStatus records the upgrade in progress in the status of a
+// turtle and reports it through conditions. An upgrade is stalled when its
+// versions are not supported, or when its component took longer than the
+// deadline of each of its machines.
+func setUpgradeStatus(
+	turtle *infrav1alpha1.Turtle,
+	plan *upgradePlan,
+	controlPlane *kcpv1alpha3.KubeadmControlPlane,
+	mds map[string]*capiv1alpha3.MachineDeployment,
+	now time.Time,
+) {
+	previous := turtle.Status.Upgrade
+	turtle.Status.Upgrade = nil
+	if plan.upgrade != nil {
+		upgrade := *plan.upgrade
+		if previous != nil && previous.Component == upgrade.Component && previous.Version == upgrade.Version {
+			upgrade.StartTime = previous.StartTime
+		} else {
+			upgrade.StartTime = metav1.NewTime(now)
+		}
+		turtle.Status.Upgrade = &upgrade
+	}
+
+	conditions := &turtle.Status.Conditions
+	upgrade := turtle.Status.Upgrade
+	switch {
+	case upgrade != nil && upgrade.Component == infrav1alpha1.ControlPlaneComponent:
+		setCondition(conditions, infrav1alpha1.TurtleUpgradingCondition, corev1.ConditionTrue, "UpgradingControlPlane",
+			joinReasons(fmt.Sprintf("upgrading control plane to %s", upgrade.Version), plan.waiting))
+	case upgrade != nil:
+		setCondition(conditions, infrav1alpha1.TurtleUpgradingCondition, corev1.ConditionTrue, "UpgradingHatchling",
+			joinReasons(fmt.Sprintf("upgrading hatchling %s to %s", upgrade.Component, upgrade.Version), plan.waiting))
+	case plan.waiting != "":
+		setCondition(conditions, infrav1alpha1.TurtleUpgradingCondition, corev1.ConditionTrue, "Waiting", plan.waiting)
+	default:
+		setCondition(conditions, infrav1alpha1.TurtleUpgradingCondition, corev1.ConditionFalse, "UpToDate", "")
+	}
+
+	switch {
+	case plan.blocked != "":
+		setCondition(conditions, infrav1alpha1.TurtleUpgradeStalledCondition, corev1.ConditionTrue, "UnsupportedUpgrade", plan.blocked)
+	case upgrade != nil && now.Sub(upgrade.StartTime.Time) > upgradeDeadline(upgrade, controlPlane, mds):
+		setCondition(conditions, infrav1alpha1.TurtleUpgradeStalledCondition, corev1.ConditionTrue, "ProgressDeadlineExceeded",
+			fmt.Sprintf("%s has been upgrading to %s since %s", upgrade.Component, upgrade.Version, upgrade.StartTime.UTC().Format(time.RFC3339)))
+	default:
+		setCondition(conditions, infrav1alpha1.TurtleUpgradeStalledCondition, corev1.ConditionFalse, "", "")
+	}
+}
+
+// upgradeDeadline is how long upgrading a component may take, which grows
+// with its number of machines since they are replaced one by one.
+func upgradeDeadline(
+	upgrade *infrav1alpha1.UpgradeStatus,
+	controlPlane *kcpv1alpha3.KubeadmControlPlane,
+	mds map[string]*capiv1alpha3.MachineDeployment,
+) time.Duration {
+	var replicas *int32
+	if upgrade.Component == infrav1alpha1.ControlPlaneComponent {
+		if controlPlane != nil {
+			replicas = controlPlane.Spec.Replicas
+		}
+	} else if md, ok := mds[upgrade.Component]; ok {
+		replicas = md.Spec.Replicas
+	}
+
+	machines := int32(1)
+	if replicas != nil && *replicas > 1 {
+		machines = *replicas
+	}
+	return time.Duration(machines) * upgradeMachineDeadline
+}
+
+// controlPlaneRolledOut reports whether every control plane machine runs
+// the version of the control plane and is ready. Machines are checked as
+// well as the status, which lags behind a version change.
+func controlPlaneRolledOut(controlPlane *kcpv1alpha3.KubeadmControlPlane, machines []capiv1alpha3.Machine) bool {
+	replicas := int32(1)
+	if controlPlane.Spec.Replicas != nil {
+		replicas = *controlPlane.Spec.Replicas
+	}
+
+	status := controlPlane.Status
+	if !status.Ready || status.ReadyReplicas != replicas || status.UnavailableReplicas != 0 {
+		return false
+	}
+
+	if int32(len(machines)) != replicas {
+		return false
+	}
+	for i := range machines {
+		machine := &machines[i]
+		if !machine.DeletionTimestamp.IsZero() || machine.Status.NodeRef == nil {
+			return false
+		}
+		if machine.Spec.Version == nil || *machine.Spec.Version != controlPlane.Spec.Version {
+			return false
+		}
+	}
+
+	return true
+}
+
+// machineDeploymentRolledOut reports whether every machine of a machine
+// deployment matches its template and is available.
+func machineDeploymentRolledOut(md *capiv1alpha3.MachineDeployment) bool {
+	replicas := int32(1)
+	if md.Spec.Replicas != nil {
+		replicas = *md.Spec.Replicas
+	}
+
+	status := md.Status
+	return status.ObservedGeneration >= md.Generation &&
+		status.Replicas == replicas &&
+		status.UpdatedReplicas == replicas &&
+		status.AvailableReplicas == replicas
+}
+
+// machineDeploymentVersion returns the Kubernetes version of the machines
+// of a machine deployment.
+func machineDeploymentVersion(md *capiv1alpha3.MachineDeployment) string {
+	if md.Spec.Template.Spec.Version == nil {
+		return ""
+	}
+	return *md.Spec.Template.Spec.Version
+}
+
+// hatchlingRunningVersion returns the Kubernetes version of the machines of
+// a machine deployment. Machine deployments created before versions were
+// set on them follow the control plane, so they run its version.
+func hatchlingRunningVersion(md *capiv1alpha3.MachineDeployment, controlPlane string) string {
+	if version := machineDeploymentVersion(md); version != "" {
+		return version
+	}
+	return controlPlane
+}
+
+// validateSkew returns an error if nodes at a version may not join a
+// control plane at another.
+func validateSkew(controlPlane, node string) error {
+	controlPlaneVersion, err := semver.ParseTolerant(controlPlane)
+	if err != nil {
+		return fmt.Errorf("invalid version %q: %w", controlPlane, err)
+	}
+
+	nodeVersion, err := semver.ParseTolerant(node)
+	if err != nil {
+		return fmt.Errorf("invalid version %q: %w", node, err)
+	}
+
+	if nodeVersion.GT(controlPlaneVersion) {
+		return fmt.Errorf("version %s is newer than control plane version %s", node, controlPlane)
+	}
+
+	return nil
+}
+
+// joinReasons appends a reason to a list of reasons.
+func joinReasons(reasons, reason string) string {
+	if reasons == "" {
+		return reason
+	}
+	return reasons + "; " + reason
+}
@@ -0,0 +1,210 @@
+// Copyright 2020 Alexander Eldeib
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS
+// OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+// CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+// TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package controllers
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	capiv1alpha3 "sigs.k8s.io/cluster-api/api/v1alpha3"
+	kcpv1alpha3 "sigs.k8s.io/cluster-api/controlplane/kubeadm/api/v1alpha3"
+
+	infrav1alpha1 "github.com/alexeldeib/bale/api/v1alpha1"
+)
+
+func TestComputeUpgradePlan(t *testing.T) {
+	controlPlaneAt := func(version string, rolledOut bool) (*kcpv1alpha3.KubeadmControlPlane, []capiv1alpha3.Machine) {
+		replicas := int32(1)
+		controlPlane := &kcpv1alpha3.KubeadmControlPlane{}
+		controlPlane.Spec.Replicas = &replicas
+		controlPlane.Spec.Version = version
+		controlPlane.Status.Ready = true
+		controlPlane.Status.ReadyReplicas = 1
+		machine := capiv1alpha3.Machine{}
+		machine.Spec.Version = &version
+		machine.Status.NodeRef = &corev1.ObjectReference{Name: "node"}
+		if !rolledOut {
+			old := "v1.16.9"
+			machine.Spec.Version = &old
+		}
+		return controlPlane, []capiv1alpha3.Machine{machine}
+	}
+
+	mdAt := func(version string, rolledOut bool) *capiv1alpha3.MachineDeployment {
+		replicas := int32(2)
+		md := &capiv1alpha3.MachineDeployment{}
+		md.Spec.Replicas = &replicas
+		md.Spec.Template.Spec.Version = &version
+		md.Status.Replicas = 2
+		md.Status.UpdatedReplicas = 2
+		md.Status.AvailableReplicas = 2
+		if !rolledOut {
+			md.Status.UpdatedReplicas = 1
+		}
+		return md
+	}
+
+	unversioned := func() *capiv1alpha3.MachineDeployment {
+		md := mdAt("", true)
+		md.Spec.Template.Spec.Version = nil
+		return md
+	}
+
+	turtleAt := func(version string, upgrade *infrav1alpha1.UpgradeStatus) *infrav1alpha1.Turtle {
+		turtle := &infrav1alpha1.Turtle{}
+		turtle.Spec.Version = version
+		turtle.Spec.Hatchlings = []infrav1alpha1.HatchlingSpec{{Name: "a"}, {Name: "b"}}
+		turtle.Status.Upgrade = upgrade
+		return turtle
+	}
+
+	cases := map[string]struct {
+		turtle       *infrav1alpha1.Turtle
+		controlPlane string
+		rolledOut    bool
+		mds          map[string]*capiv1alpha3.MachineDeployment
+		want         map[string]string
+		wantCP       string
+		wantUpgrade  string
+		wantBlocked  bool
+	}{
+		"new cluster": {
+			turtle: turtleAt("v1.18.3", nil),
+			want:   map[string]string{"a": "v1.18.3", "b": "v1.18.3"},
+			wantCP: "v1.18.3",
+		},
+		"control plane first": {
+			turtle:       turtleAt("v1.18.3", nil),
+			controlPlane: "v1.17.7",
+			rolledOut:    true,
+			mds:          map[string]*capiv1alpha3.MachineDeployment{"a": mdAt("v1.17.7", true), "b": mdAt("v1.17.7", true)},
+			want:         map[string]string{"a": "v1.17.7", "b": "v1.17.7"},
+			wantCP:       "v1.18.3",
+			wantUpgrade:  infrav1alpha1.ControlPlaneComponent,
+		},
+		"hatchlings wait for control plane rollout": {
+			turtle:       turtleAt("v1.18.3", &infrav1alpha1.UpgradeStatus{Component: infrav1alpha1.ControlPlaneComponent, Version: "v1.18.3"}),
+			controlPlane: "v1.18.3",
+			rolledOut:    false,
+			mds:          map[string]*capiv1alpha3.MachineDeployment{"a": mdAt("v1.17.7", true), "b": mdAt("v1.17.7", true)},
+			want:         map[string]string{"a": "v1.17.7", "b": "v1.17.7"},
+			wantCP:       "v1.18.3",
+			wantUpgrade:  infrav1alpha1.ControlPlaneComponent,
+		},
+		"first hatchling after control plane": {
+			turtle:       turtleAt("v1.18.3", &infrav1alpha1.UpgradeStatus{Component: infrav1alpha1.ControlPlaneComponent, Version: "v1.18.3"}),
+			controlPlane: "v1.18.3",
+			rolledOut:    true,
+			mds:          map[string]*capiv1alpha3.MachineDeployment{"a": mdAt("v1.17.7", true), "b": mdAt("v1.17.7", true)},
+			want:         map[string]string{"a": "v1.18.3", "b": "v1.17.7"},
+			wantCP:       "v1.18.3",
+			wantUpgrade:  "a",
+		},
+		"one hatchling at a time": {
+			turtle:       turtleAt("v1.18.3", &infrav1alpha1.UpgradeStatus{Component: "a", Version: "v1.18.3"}),
+			controlPlane: "v1.18.3",
+			rolledOut:    true,
+			mds:          map[string]*capiv1alpha3.MachineDeployment{"a": mdAt("v1.18.3", false), "b": mdAt("v1.17.7", true)},
+			want:         map[string]string{"a": "v1.18.3", "b": "v1.17.7"},
+			wantCP:       "v1.18.3",
+			wantUpgrade:  "a",
+		},
+		"next hatchling": {
+			turtle:       turtleAt("v1.18.3", &infrav1alpha1.UpgradeStatus{Component: "a", Version: "v1.18.3"}),
+			controlPlane: "v1.18.3",
+			rolledOut:    true,
+			mds:          map[string]*capiv1alpha3.MachineDeployment{"a": mdAt("v1.18.3", true), "b": mdAt("v1.17.7", true)},
+			want:         map[string]string{"a": "v1.18.3", "b": "v1.18.3"},
+			wantCP:       "v1.18.3",
+			wantUpgrade:  "b",
+		},
+		"minor version skip": {
+			turtle:       turtleAt("v1.18.3", nil),
+			controlPlane: "v1.16.9",
+			rolledOut:    true,
+			mds:          map[string]*capiv1alpha3.MachineDeployment{"a": mdAt("v1.16.9", true), "b": mdAt("v1.16.9", true)},
+			want:         map[string]string{"a": "v1.16.9", "b": "v1.16.9"},
+			wantCP:       "v1.16.9",
+			wantBlocked:  true,
+		},
+		"downgrade": {
+			turtle:       turtleAt("v1.17.7", nil),
+			controlPlane: "v1.18.3",
+			rolledOut:    true,
+			mds:          map[string]*capiv1alpha3.MachineDeployment{"a": mdAt("v1.17.7", true), "b": mdAt("v1.17.7", true)},
+			want:         map[string]string{"a": "v1.17.7", "b": "v1.17.7"},
+			wantCP:       "v1.18.3",
+			wantBlocked:  true,
+		},
+		"unset versions follow the control plane": {
+			turtle:       turtleAt("v1.18.3", nil),
+			controlPlane: "v1.18.3",
+			rolledOut:    true,
+			mds:          map[string]*capiv1alpha3.MachineDeployment{"a": unversioned(), "b": unversioned()},
+			want:         map[string]string{"a": "v1.18.3", "b": "v1.18.3"},
+			wantCP:       "v1.18.3",
+		},
+		"unset versions upgrade after the control plane": {
+			turtle:       turtleAt("v1.18.3", nil),
+			controlPlane: "v1.17.7",
+			rolledOut:    true,
+			mds:          map[string]*capiv1alpha3.MachineDeployment{"a": unversioned(), "b": mdAt("v1.17.7", true)},
+			want:         map[string]string{"a": "v1.17.7", "b": "v1.17.7"},
+			wantCP:       "v1.18.3",
+			wantUpgrade:  infrav1alpha1.ControlPlaneComponent,
+		},
+		"new hatchling waits for control plane upgrade": {
+			turtle:       turtleAt("v1.18.3", nil),
+			controlPlane: "v1.17.7",
+			rolledOut:    true,
+			mds:          map[string]*capiv1alpha3.MachineDeployment{"a": mdAt("v1.17.7", true)},
+			want:         map[string]string{"a": "v1.17.7"},
+			wantCP:       "v1.18.3",
+			wantUpgrade:  infrav1alpha1.ControlPlaneComponent,
+		},
+	}
+
+	for name, tc := range cases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			var controlPlane *kcpv1alpha3.KubeadmControlPlane
+			var machines []capiv1alpha3.Machine
+			if tc.controlPlane != "" {
+				controlPlane, machines = controlPlaneAt(tc.controlPlane, tc.rolledOut)
+			}
+
+			plan := computeUpgradePlan(tc.turtle, controlPlane, machines, tc.mds)
+			g.Expect(plan.controlPlane).To(Equal(tc.wantCP))
+			g.Expect(plan.hatchlings).To(Equal(tc.want))
+			g.Expect(plan.blocked != "").To(Equal(tc.wantBlocked), plan.blocked)
+			if tc.wantUpgrade == "" {
+				g.Expect(plan.upgrade).To(BeNil())
+			} else {
+				g.Expect(plan.upgrade).NotTo(BeNil())
+				g.Expect(plan.upgrade.Component).To(Equal(tc.wantUpgrade))
+			}
+		})
+	}
+}
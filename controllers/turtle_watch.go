@@ -0,0 +1,157 @@
+// Copyright 2020 Alexander Eldeib
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS
+// OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+// CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+// TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package controllers
+
+import (
+	"context"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	infrav1alpha1 "github.com/alexeldeib/bale/api/v1alpha1"
+	"github.com/alexeldeib/bale/pkg/remote"
+)
+
+// addonNamespace holds the addons applied to workload clusters.
+const addonNamespace = "kube-system"
+
+// watchRemote watches the objects the controller manages in the workload
+// cluster of a turtle, and its nodes, so the turtle is reconciled when they
+// change: deleted addons and credentials are put back, and node readiness
+// is reflected in the status without waiting for the next poll.
+func (r *TurtleReconciler) watchRemote(ctx context.Context, turtle *infrav1alpha1.Turtle) error {
+	if r.Tracker == nil || r.controller == nil {
+		return nil
+	}
+
+	kubeconfig, err := getKubeconfig(ctx, r.Client, turtle)
+	if err != nil {
+		return err
+	}
+
+	key := types.NamespacedName{Namespace: turtle.Namespace, Name: turtle.Name}
+	toTurtle := &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: handler.ToRequestsFunc(func(handler.MapObject) []reconcile.Request {
+			return []reconcile.Request{{NamespacedName: key}}
+		}),
+	}
+
+	watches := []struct {
+		name       string
+		kind       runtime.Object
+		namespace  string
+		predicates []predicate.Predicate
+	}{
+		{name: "nodes", kind: &corev1.Node{}, predicates: []predicate.Predicate{nodeReadinessChanged()}},
+		{
+			name:       "credentials",
+			kind:       &corev1.Secret{},
+			namespace:  defaultCredentials.Namespace,
+			predicates: []predicate.Predicate{inNamespace(defaultCredentials.Namespace, defaultCredentials.Name)},
+		},
+		{name: "addon-daemonsets", kind: &appsv1.DaemonSet{}, namespace: addonNamespace, predicates: []predicate.Predicate{specChangedOrDeleted()}},
+		{name: "addon-deployments", kind: &appsv1.Deployment{}, namespace: addonNamespace, predicates: []predicate.Predicate{specChangedOrDeleted()}},
+	}
+
+	for _, watch := range watches {
+		if err := r.Tracker.Watch(ctx, remote.WatchInput{
+			Name:         watch.name,
+			Cluster:      key,
+			Kubeconfig:   kubeconfig,
+			Watcher:      r.controller,
+			Kind:         watch.kind,
+			Namespace:    watch.namespace,
+			EventHandler: toTurtle,
+			Predicates:   watch.predicates,
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// inNamespace filters events to objects in a namespace, and to a single
+// object if a name is given.
+func inNamespace(namespace, name string) predicate.Predicate {
+	matches := func(meta metav1.Object) bool {
+		return meta.GetNamespace() == namespace && (name == "" || meta.GetName() == name)
+	}
+	return predicate.Funcs{
+		CreateFunc:  func(e event.CreateEvent) bool { return matches(e.Meta) },
+		UpdateFunc:  func(e event.UpdateEvent) bool { return matches(e.MetaNew) },
+		DeleteFunc:  func(e event.DeleteEvent) bool { return matches(e.Meta) },
+		GenericFunc: func(e event.GenericEvent) bool { return matches(e.Meta) },
+	}
+}
+
+// specChangedOrDeleted filters events to deletes and updates which change
+// the generation of an object, since workloads update their status all the
+// time and each event reapplies the addons.
+func specChangedOrDeleted() predicate.Predicate {
+	return predicate.Funcs{
+		CreateFunc: func(event.CreateEvent) bool { return false },
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			if e.MetaOld == nil || e.MetaNew == nil {
+				return true
+			}
+			return e.MetaOld.GetGeneration() != e.MetaNew.GetGeneration()
+		},
+		DeleteFunc:  func(event.DeleteEvent) bool { return true },
+		GenericFunc: func(event.GenericEvent) bool { return false },
+	}
+}
+
+// nodeReadinessChanged filters out node updates which don't change whether
+// the node is ready, since nodes update their status all the time.
+func nodeReadinessChanged() predicate.Predicate {
+	return predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			oldNode, ok := e.ObjectOld.(*corev1.Node)
+			if !ok {
+				return true
+			}
+			newNode, ok := e.ObjectNew.(*corev1.Node)
+			if !ok {
+				return true
+			}
+			return nodeReady(oldNode) != nodeReady(newNode)
+		},
+	}
+}
+
+// nodeReady reports whether a node has the Ready condition.
+func nodeReady(node *corev1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == corev1.NodeReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
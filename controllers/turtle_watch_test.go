@@ -0,0 +1,51 @@
+// Copyright 2020 Alexander Eldeib
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS
+// OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+// CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+// TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package controllers
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+func TestSpecChangedOrDeleted(t *testing.T) {
+	g := NewWithT(t)
+	p := specChangedOrDeleted()
+
+	deployment := func(generation int64, ready int32) *appsv1.Deployment {
+		return &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "coredns", Namespace: addonNamespace, Generation: generation},
+			Status:     appsv1.DeploymentStatus{ReadyReplicas: ready},
+		}
+	}
+	update := func(old, new *appsv1.Deployment) event.UpdateEvent {
+		return event.UpdateEvent{MetaOld: old, ObjectOld: old, MetaNew: new, ObjectNew: new}
+	}
+
+	g.Expect(p.Update(update(deployment(1, 0), deployment(1, 2)))).To(BeFalse(), "status updates are filtered")
+	g.Expect(p.Update(update(deployment(1, 2), deployment(2, 2)))).To(BeTrue(), "spec changes pass")
+	g.Expect(p.Delete(event.DeleteEvent{Meta: deployment(1, 2), Object: deployment(1, 2)})).To(BeTrue())
+	g.Expect(p.Create(event.CreateEvent{Meta: deployment(1, 0), Object: deployment(1, 0)})).To(BeFalse())
+}
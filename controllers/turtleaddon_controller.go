@@ -0,0 +1,535 @@
+// Copyright 2020 Alexander Eldeib
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS
+// OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+// CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+// TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	infrav1alpha1 "github.com/alexeldeib/bale/api/v1alpha1"
+	"github.com/alexeldeib/bale/pkg/remote"
+)
+
+// defaultAddonResyncPeriod is how often addons are re-applied when the
+// spec doesn't say.
+const defaultAddonResyncPeriod = 10 * time.Minute
+
+// TurtleAddonReconciler reconciles a TurtleAddon object
+type TurtleAddonReconciler struct {
+	client.Client
+	Log      logr.Logger
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+	// Tracker shares clients of the workload clusters with the Turtle
+	// controller, so they aren't rebuilt on every apply. Optional.
+	Tracker *remote.ClusterCacheTracker
+}
+
+// +kubebuilder:rbac:groups=infra.alexeldeib.xyz,resources=turtleaddons,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=infra.alexeldeib.xyz,resources=turtleaddons/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=infra.alexeldeib.xyz,resources=turtles,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+// +kubebuilder:rbac:groups=apps,resources=controllerrevisions,verbs=get;list;watch;create;update;patch;delete
+
+func (r *TurtleAddonReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&infrav1alpha1.TurtleAddon{}).
+		Watches(
+			&source.Kind{Type: &infrav1alpha1.Turtle{}},
+			&handler.EnqueueRequestsFromMapFunc{ToRequests: handler.ToRequestsFunc(r.turtleToAddons)},
+			builder.WithPredicates(turtleChanged()),
+		).
+		Watches(
+			&source.Kind{Type: &corev1.ConfigMap{}},
+			&handler.EnqueueRequestsFromMapFunc{ToRequests: r.sourceToAddons(infrav1alpha1.AddonSourceConfigMap)},
+		).
+		Watches(
+			&source.Kind{Type: &corev1.Secret{}},
+			&handler.EnqueueRequestsFromMapFunc{ToRequests: r.sourceToAddons(infrav1alpha1.AddonSourceSecret)},
+		).
+		Complete(r)
+}
+
+func (r *TurtleAddonReconciler) Reconcile(req ctrl.Request) (_ ctrl.Result, reterr error) {
+	ctx := context.Background()
+	log := r.Log.WithValues("turtleaddon", req.NamespacedName)
+
+	var addon infrav1alpha1.TurtleAddon
+	if err := r.Get(ctx, req.NamespacedName, &addon); err != nil {
+		log.Error(err, "unable to fetch")
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	// Addons have no finalizer: objects they applied are left in the
+	// workload clusters.
+	if !addon.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, nil
+	}
+
+	// A rollback rewrites the helm release, so act on it before anything
+	// else and let the resulting update trigger the next reconcile. A
+	// rollback which fails doesn't stop the rest of the reconcile.
+	if !addon.Spec.Paused && r.rollback(ctx, log, &addon) {
+		return ctrl.Result{}, nil
+	}
+
+	defer func() {
+		addon.Status.ObservedGeneration = addon.Generation
+		if err := r.Status().Update(ctx, &addon); err != nil && reterr == nil {
+			log.Error(err, "failed to update addon status")
+			reterr = err
+		}
+	}()
+
+	if addon.Spec.Paused {
+		setCondition(&addon.Status.Conditions, infrav1alpha1.PausedCondition, corev1.ConditionTrue, "Paused",
+			"manifests are not applied")
+		return ctrl.Result{}, nil
+	}
+	setCondition(&addon.Status.Conditions, infrav1alpha1.PausedCondition, corev1.ConditionFalse, "", "")
+
+	renderer, err := r.loadSources(ctx, log, &addon)
+	if err != nil {
+		setCondition(&addon.Status.Conditions, infrav1alpha1.TurtleAddonAppliedCondition, corev1.ConditionFalse,
+			"InvalidSources", err.Error())
+		// Sources are watched, so fixing them triggers the next reconcile.
+		var invalid *addonSourceError
+		if errors.As(err, &invalid) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	turtles, err := r.selectTurtles(ctx, &addon)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	now := time.Now()
+	resync := addonResyncPeriod(&addon)
+	previous := map[string]infrav1alpha1.AddonClusterStatus{}
+	for _, cluster := range addon.Status.Clusters {
+		previous[cluster.Turtle] = cluster
+	}
+
+	clusters := make([]infrav1alpha1.AddonClusterStatus, 0, len(turtles))
+	rendered := map[string][]byte{}
+	var tooLarge []string
+	var result ctrl.Result
+	for i := range turtles {
+		turtle := &turtles[i]
+		status, ok := previous[turtle.Name]
+		if !ok {
+			status = infrav1alpha1.AddonClusterStatus{Turtle: turtle.Name}
+		}
+
+		// Neither connect nor render unless something the manifests are
+		// rendered from changed, or the resync period passed.
+		inputs := renderer.inputHash(turtle)
+		if !addon.Spec.RenderOnly {
+			if next := nextAddonRender(&status, inputs, resync, now); next > 0 {
+				result = requeueSooner(result, next)
+				clusters = append(clusters, status)
+				continue
+			}
+		}
+
+		// Helm releases are rendered for the API versions and objects of
+		// their cluster.
+		var remoteClient *remote.Client
+		if renderer.release != nil {
+			if remoteClient = r.connect(ctx, log, turtle, &status); remoteClient == nil {
+				result = requeueSooner(result, statusRequeueInterval)
+				clusters = append(clusters, status)
+				continue
+			}
+		}
+
+		manifest, err := renderer.render(ctx, turtle, &status, remoteClient)
+		if err != nil {
+			status.Applied = false
+			status.Message = err.Error()
+			clusters = append(clusters, status)
+			continue
+		}
+		hash := renderer.hash(manifest)
+
+		if addon.Spec.RenderOnly {
+			status.Applied = false
+			if len(manifest) > maxRenderedSize {
+				status.Message = fmt.Sprintf("rendered manifests are %d bytes, more than the limit of %d", len(manifest), maxRenderedSize)
+				tooLarge = append(tooLarge, turtle.Name)
+			} else {
+				rendered[turtle.Name] = manifest
+				status.Message = fmt.Sprintf("rendered to %s %s", strings.ToLower(string(renderedKind(&addon))), renderedName(&addon, turtle.Name))
+			}
+			clusters = append(clusters, status)
+			continue
+		}
+
+		if next := nextAddonApply(&status, hash, resync, now); next > 0 {
+			status.InputHash = inputs
+			result = requeueSooner(result, next)
+			clusters = append(clusters, status)
+			continue
+		}
+
+		r.applyToTurtle(ctx, log, turtle, remoteClient, renderer, manifest, hash, &status)
+		if status.Applied {
+			status.InputHash = inputs
+			renderer.setApplied(&status)
+			result = requeueSooner(result, resync)
+		} else {
+			result = requeueSooner(result, statusRequeueInterval)
+		}
+		clusters = append(clusters, status)
+	}
+
+	if err := r.reconcileRendered(ctx, &addon, rendered); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	addon.Status.Clusters = clusters
+	setAddonApplied(&addon)
+	if len(tooLarge) > 0 {
+		setCondition(&addon.Status.Conditions, infrav1alpha1.TurtleAddonAppliedCondition, corev1.ConditionFalse,
+			"RenderTooLarge", fmt.Sprintf("manifests for %s are too large to render", strings.Join(tooLarge, ", ")))
+	}
+	return result, nil
+}
+
+// connect returns the client of the cluster of a turtle, or records why
+// there is none yet and returns nil.
+func (r *TurtleAddonReconciler) connect(ctx context.Context, log logr.Logger, turtle *infrav1alpha1.Turtle, status *infrav1alpha1.AddonClusterStatus) *remote.Client {
+	remoteClient, err := newRemoteClient(ctx, r.Client, r.Tracker, turtle)
+	if err != nil {
+		status.Applied = false
+		status.Message = err.Error()
+		var waiting *waitingError
+		if !errors.As(err, &waiting) {
+			log.Error(err, "failed to create remote client", "turtle", turtle.Name)
+		}
+		return nil
+	}
+	return remoteClient
+}
+
+// applyToTurtle applies the manifest of an addon to the cluster of a turtle,
+// prunes what the renderer no longer renders and records the outcome. The
+// remote client is created if it is nil.
+func (r *TurtleAddonReconciler) applyToTurtle(
+	ctx context.Context,
+	log logr.Logger,
+	turtle *infrav1alpha1.Turtle,
+	remoteClient *remote.Client,
+	renderer *addonRenderer,
+	manifest []byte,
+	hash string,
+	status *infrav1alpha1.AddonClusterStatus,
+) {
+	status.Applied = false
+	if turtle.Spec.Paused {
+		status.Message = "turtle is paused"
+		return
+	}
+
+	if remoteClient == nil {
+		if remoteClient = r.connect(ctx, log, turtle, status); remoteClient == nil {
+			return
+		}
+	}
+
+	results, err := remoteClient.Apply(ctx, manifest, renderer.applyOptions()...)
+	if err == nil {
+		err = results.Err()
+	}
+	if err != nil {
+		log.Error(err, "failed to apply addon", "turtle", turtle.Name)
+		status.Message = fmt.Sprintf("failed to apply manifests: %s", err)
+		return
+	}
+
+	if err := renderer.prune(ctx, remoteClient, results, status); err != nil {
+		log.Error(err, "failed to prune addon", "turtle", turtle.Name)
+		status.Message = fmt.Sprintf("failed to delete objects removed from the release: %s", err)
+		return
+	}
+
+	now := metav1.Now()
+	status.Applied = true
+	status.ManifestHash = hash
+	status.LastAppliedTime = &now
+	status.Message = ""
+}
+
+// selectTurtles returns the turtles selected by an addon, sorted by name.
+// Turtles being deleted are left out, nothing is applied to them anymore.
+func (r *TurtleAddonReconciler) selectTurtles(ctx context.Context, addon *infrav1alpha1.TurtleAddon) ([]infrav1alpha1.Turtle, error) {
+	selector, err := metav1.LabelSelectorAsSelector(addon.Spec.Selector)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert selector: %w", err)
+	}
+
+	var turtles infrav1alpha1.TurtleList
+	if err := r.List(ctx, &turtles, client.InNamespace(addon.Namespace), client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return nil, fmt.Errorf("failed to list turtles: %w", err)
+	}
+
+	selected := make([]infrav1alpha1.Turtle, 0, len(turtles.Items))
+	for i := range turtles.Items {
+		if turtles.Items[i].DeletionTimestamp.IsZero() {
+			selected = append(selected, turtles.Items[i])
+		}
+	}
+	sort.Slice(selected, func(i, j int) bool {
+		return selected[i].Name < selected[j].Name
+	})
+
+	return selected, nil
+}
+
+// sourceData returns the values of a ConfigMap or Secret.
+func (r *TurtleAddonReconciler) sourceData(ctx context.Context, namespace string, src infrav1alpha1.AddonSource) (map[string][]byte, error) {
+	key := types.NamespacedName{Namespace: namespace, Name: src.Name}
+
+	switch src.Kind {
+	case infrav1alpha1.AddonSourceSecret:
+		secret := &corev1.Secret{}
+		if err := r.Get(ctx, key, secret); err != nil {
+			if apierrors.IsNotFound(err) {
+				return nil, &addonSourceError{fmt.Sprintf("secret %s not found", src.Name)}
+			}
+			return nil, fmt.Errorf("failed to get secret %s: %w", src.Name, err)
+		}
+		return secret.Data, nil
+	default:
+		configMap := &corev1.ConfigMap{}
+		if err := r.Get(ctx, key, configMap); err != nil {
+			if apierrors.IsNotFound(err) {
+				return nil, &addonSourceError{fmt.Sprintf("configmap %s not found", src.Name)}
+			}
+			return nil, fmt.Errorf("failed to get configmap %s: %w", src.Name, err)
+		}
+		data := make(map[string][]byte, len(configMap.Data)+len(configMap.BinaryData))
+		for k, v := range configMap.BinaryData {
+			data[k] = v
+		}
+		for k, v := range configMap.Data {
+			data[k] = []byte(v)
+		}
+		return data, nil
+	}
+}
+
+// addonResyncPeriod returns how often an addon is re-applied.
+func addonResyncPeriod(addon *infrav1alpha1.TurtleAddon) time.Duration {
+	if addon.Spec.ResyncPeriod == nil || addon.Spec.ResyncPeriod.Duration <= 0 {
+		return defaultAddonResyncPeriod
+	}
+	return addon.Spec.ResyncPeriod.Duration
+}
+
+// nextAddonApply returns how long until an addon is due to be applied to a
+// cluster again, or zero if it is due now: the manifests changed, the last
+// apply failed, or the resync period passed.
+func nextAddonApply(status *infrav1alpha1.AddonClusterStatus, hash string, resync time.Duration, now time.Time) time.Duration {
+	if !status.Applied || status.ManifestHash != hash || status.LastAppliedTime == nil {
+		return 0
+	}
+
+	next := status.LastAppliedTime.Add(resync).Sub(now)
+	if next < 0 {
+		return 0
+	}
+	return next
+}
+
+// nextAddonRender returns how long until the manifests of an addon are due
+// to be rendered for a cluster again, or zero if they are due now: what they
+// are rendered from changed, or they are due to be applied.
+func nextAddonRender(status *infrav1alpha1.AddonClusterStatus, inputs string, resync time.Duration, now time.Time) time.Duration {
+	if status.InputHash != inputs {
+		return 0
+	}
+	return nextAddonApply(status, status.ManifestHash, resync, now)
+}
+
+// requeueSooner returns a result requeued after the earlier of its interval
+// and the given one.
+func requeueSooner(result ctrl.Result, after time.Duration) ctrl.Result {
+	if result.RequeueAfter == 0 || after < result.RequeueAfter {
+		result.RequeueAfter = after
+	}
+	return result
+}
+
+// setAddonApplied counts the clusters with their current manifests applied
+// and summarizes them in the Applied condition.
+func setAddonApplied(addon *infrav1alpha1.TurtleAddon) {
+	var pending []string
+	addon.Status.AppliedClusters = 0
+	addon.Status.SelectedClusters = int32(len(addon.Status.Clusters))
+	for _, cluster := range addon.Status.Clusters {
+		if cluster.Applied {
+			addon.Status.AppliedClusters++
+			continue
+		}
+		pending = append(pending, cluster.Turtle)
+	}
+
+	switch {
+	case addon.Spec.RenderOnly:
+		setCondition(&addon.Status.Conditions, infrav1alpha1.TurtleAddonAppliedCondition, corev1.ConditionFalse,
+			"RenderOnly", fmt.Sprintf("manifests are rendered to %ss %s", strings.ToLower(string(renderedKind(addon))), renderedName(addon, "<turtle>")))
+	case len(pending) > 0:
+		setCondition(&addon.Status.Conditions, infrav1alpha1.TurtleAddonAppliedCondition, corev1.ConditionFalse,
+			"Applying", fmt.Sprintf("not applied to %s", strings.Join(pending, ", ")))
+	default:
+		setCondition(&addon.Status.Conditions, infrav1alpha1.TurtleAddonAppliedCondition, corev1.ConditionTrue,
+			"Applied", fmt.Sprintf("applied to %d clusters", addon.Status.AppliedClusters))
+	}
+}
+
+// turtleToAddons maps a turtle to every addon in its namespace selecting
+// it. Addons which are already applied with their current manifests are
+// not applied again until their resync period passes.
+func (r *TurtleAddonReconciler) turtleToAddons(obj handler.MapObject) []reconcile.Request {
+	var addons infrav1alpha1.TurtleAddonList
+	if err := r.List(context.Background(), &addons, client.InNamespace(obj.Meta.GetNamespace())); err != nil {
+		r.Log.Error(err, "failed to list addons for turtle", "turtle", obj.Meta.GetName())
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for i := range addons.Items {
+		addon := &addons.Items[i]
+		selector, err := metav1.LabelSelectorAsSelector(addon.Spec.Selector)
+		if err != nil {
+			continue
+		}
+		if selector.Matches(labels.Set(obj.Meta.GetLabels())) || addonHasCluster(addon, obj.Meta.GetName()) {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Namespace: addon.Namespace, Name: addon.Name},
+			})
+		}
+	}
+
+	return requests
+}
+
+// turtleChanged filters turtle updates to those which may change the
+// addons selecting the turtle or where they can be applied: changes to its
+// spec, labels or readiness, and its deletion. Turtles update their status
+// all the time.
+func turtleChanged() predicate.Predicate {
+	return predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			oldTurtle, ok := e.ObjectOld.(*infrav1alpha1.Turtle)
+			if !ok {
+				return true
+			}
+			newTurtle, ok := e.ObjectNew.(*infrav1alpha1.Turtle)
+			if !ok {
+				return true
+			}
+			return oldTurtle.Generation != newTurtle.Generation ||
+				!reflect.DeepEqual(oldTurtle.Labels, newTurtle.Labels) ||
+				!reflect.DeepEqual(oldTurtle.Annotations, newTurtle.Annotations) ||
+				oldTurtle.DeletionTimestamp.IsZero() != newTurtle.DeletionTimestamp.IsZero() ||
+				isConditionTrue(oldTurtle.Status.Conditions, infrav1alpha1.TurtleReadyCondition) !=
+					isConditionTrue(newTurtle.Status.Conditions, infrav1alpha1.TurtleReadyCondition)
+		},
+	}
+}
+
+// addonHasCluster reports whether the status of an addon lists a turtle,
+// so turtles which stopped matching are dropped from it.
+func addonHasCluster(addon *infrav1alpha1.TurtleAddon, turtle string) bool {
+	for _, cluster := range addon.Status.Clusters {
+		if cluster.Turtle == turtle {
+			return true
+		}
+	}
+	return false
+}
+
+// sourceToAddons returns a function mapping a ConfigMap or Secret to the
+// addons in its namespace which read manifests or charts from it.
+func (r *TurtleAddonReconciler) sourceToAddons(kind infrav1alpha1.AddonSourceKind) handler.ToRequestsFunc {
+	return func(obj handler.MapObject) []reconcile.Request {
+		var addons infrav1alpha1.TurtleAddonList
+		if err := r.List(context.Background(), &addons, client.InNamespace(obj.Meta.GetNamespace())); err != nil {
+			r.Log.Error(err, "failed to list addons for source", "kind", kind, "name", obj.Meta.GetName())
+			return nil
+		}
+
+		var requests []reconcile.Request
+		for i := range addons.Items {
+			addon := &addons.Items[i]
+			if addonReadsSource(addon, kind, obj.Meta.GetName()) {
+				requests = append(requests, reconcile.Request{
+					NamespacedName: types.NamespacedName{Namespace: addon.Namespace, Name: addon.Name},
+				})
+			}
+		}
+
+		return requests
+	}
+}
+
+// addonReadsSource reports whether an addon reads manifests or a chart from
+// the ConfigMap or Secret with the given name.
+func addonReadsSource(addon *infrav1alpha1.TurtleAddon, kind infrav1alpha1.AddonSourceKind, name string) bool {
+	if addon.Spec.Helm != nil && kind == infrav1alpha1.AddonSourceConfigMap && addon.Spec.Helm.Chart.ConfigMap == name {
+		return true
+	}
+	for _, src := range addon.Spec.Sources {
+		if src.Name == name && (src.Kind == kind || src.Kind == "" && kind == infrav1alpha1.AddonSourceConfigMap) {
+			return true
+		}
+	}
+	return false
+}
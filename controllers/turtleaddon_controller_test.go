@@ -0,0 +1,148 @@
+// Copyright 2020 Alexander Eldeib
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS
+// OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+// CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+// TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	infrav1alpha1 "github.com/alexeldeib/bale/api/v1alpha1"
+)
+
+func TestSourceManifests(t *testing.T) {
+	data := map[string][]byte{
+		"b.yaml":     []byte("kind: B"),
+		"a.yaml":     []byte("kind: A"),
+		"empty.yaml": []byte("  \n"),
+	}
+
+	cases := map[string]struct {
+		keys    []string
+		want    []string
+		wantErr bool
+	}{
+		"every key sorted by name": {
+			want: []string{"kind: A", "kind: B"},
+		},
+		"listed keys in order": {
+			keys: []string{"b.yaml", "a.yaml"},
+			want: []string{"kind: B", "kind: A"},
+		},
+		"missing key": {
+			keys:    []string{"c.yaml"},
+			wantErr: true,
+		},
+	}
+
+	for name, tc := range cases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			g := NewWithT(t)
+			manifests, err := sourceManifests(data, tc.keys)
+			if tc.wantErr {
+				g.Expect(err).To(HaveOccurred())
+				return
+			}
+			g.Expect(err).NotTo(HaveOccurred())
+
+			var got []string
+			for _, manifest := range manifests {
+				got = append(got, string(manifest))
+			}
+			g.Expect(got).To(Equal(tc.want))
+		})
+	}
+}
+
+func TestNextAddonApply(t *testing.T) {
+	now := time.Now()
+	applied := metav1.NewTime(now.Add(-time.Minute))
+
+	cases := map[string]struct {
+		status infrav1alpha1.AddonClusterStatus
+		want   time.Duration
+	}{
+		"never applied": {
+			want: 0,
+		},
+		"last apply failed": {
+			status: infrav1alpha1.AddonClusterStatus{ManifestHash: "abc", LastAppliedTime: &applied},
+			want:   0,
+		},
+		"manifests changed": {
+			status: infrav1alpha1.AddonClusterStatus{Applied: true, ManifestHash: "old", LastAppliedTime: &applied},
+			want:   0,
+		},
+		"applied within resync period": {
+			status: infrav1alpha1.AddonClusterStatus{Applied: true, ManifestHash: "abc", LastAppliedTime: &applied},
+			want:   9 * time.Minute,
+		},
+	}
+
+	for name, tc := range cases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			g := NewWithT(t)
+			g.Expect(nextAddonApply(&tc.status, "abc", 10*time.Minute, now)).To(BeNumerically("~", tc.want, time.Second))
+		})
+	}
+}
+
+func TestNextAddonRender(t *testing.T) {
+	g := NewWithT(t)
+
+	now := time.Now()
+	applied := metav1.NewTime(now.Add(-time.Minute))
+	status := infrav1alpha1.AddonClusterStatus{Applied: true, ManifestHash: "abc", InputHash: "inputs", LastAppliedTime: &applied}
+
+	g.Expect(nextAddonRender(&status, "inputs", 10*time.Minute, now)).To(BeNumerically("~", 9*time.Minute, time.Second))
+	g.Expect(nextAddonRender(&status, "changed", 10*time.Minute, now)).To(BeZero())
+	g.Expect(nextAddonRender(&status, "inputs", time.Minute/2, now)).To(BeZero(), "resync period passed")
+}
+
+func TestTurtleChanged(t *testing.T) {
+	g := NewWithT(t)
+	p := turtleChanged()
+
+	turtle := &infrav1alpha1.Turtle{ObjectMeta: metav1.ObjectMeta{Name: "turtle", Generation: 1}}
+	update := func(mutate func(*infrav1alpha1.Turtle)) event.UpdateEvent {
+		updated := turtle.DeepCopy()
+		mutate(updated)
+		return event.UpdateEvent{MetaOld: turtle, ObjectOld: turtle, MetaNew: updated, ObjectNew: updated}
+	}
+
+	g.Expect(p.Update(update(func(t *infrav1alpha1.Turtle) {
+		t.Status.Phase = infrav1alpha1.TurtlePhasePending
+	}))).To(BeFalse(), "status updates are filtered")
+	g.Expect(p.Update(update(func(t *infrav1alpha1.Turtle) { t.Generation = 2 }))).To(BeTrue())
+	g.Expect(p.Update(update(func(t *infrav1alpha1.Turtle) {
+		t.Labels = map[string]string{"class": "gpu"}
+	}))).To(BeTrue())
+	g.Expect(p.Update(update(func(t *infrav1alpha1.Turtle) {
+		setCondition(&t.Status.Conditions, infrav1alpha1.TurtleReadyCondition, corev1.ConditionTrue, "", "")
+	}))).To(BeTrue())
+}
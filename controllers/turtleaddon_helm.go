@@ -0,0 +1,350 @@
+// Copyright 2020 Alexander Eldeib
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS
+// OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+// CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+// TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	infrav1alpha1 "github.com/alexeldeib/bale/api/v1alpha1"
+	"github.com/alexeldeib/bale/pkg/helm"
+	"github.com/alexeldeib/bale/pkg/remote"
+)
+
+// helmIndexKey is the key of the repository index in a chart ConfigMap.
+const helmIndexKey = "index.yaml"
+
+// helmCluster is the cluster a release is rendered for.
+type helmCluster interface {
+	APIVersions() ([]string, error)
+	// RESTConfig is the configuration lookup reaches the cluster with.
+	RESTConfig() *rest.Config
+}
+
+// helmRelease is the current helm release of an addon.
+type helmRelease struct {
+	name     string
+	spec     infrav1alpha1.HelmSpec
+	chart    *helm.Chart
+	values   *helm.ValuesTemplate
+	revision int64
+}
+
+// loadRelease resolves the chart of a helm addon and records the release as
+// a revision.
+func (r *TurtleAddonReconciler) loadRelease(ctx context.Context, log logr.Logger, addon *infrav1alpha1.TurtleAddon) (*helmRelease, error) {
+	spec := *addon.Spec.Helm
+	chart, err := r.resolveChart(ctx, addon.Namespace, &spec.Chart)
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := helm.ParseValues(spec.Values)
+	if err != nil {
+		return nil, &addonSourceError{err.Error()}
+	}
+
+	limit := defaultRevisionHistoryLimit
+	if addon.Spec.RevisionHistoryLimit != nil {
+		limit = int(*addon.Spec.RevisionHistoryLimit)
+	}
+
+	current, err := r.history(addon).record(ctx, log, helmRevisionName(addon, spec), spec, limit)
+	if err != nil {
+		return nil, err
+	}
+	addon.Status.Revision = current.Revision
+
+	release := &helmRelease{
+		name:     spec.ReleaseName,
+		spec:     spec,
+		chart:    chart,
+		values:   values,
+		revision: current.Revision,
+	}
+	if release.name == "" {
+		release.name = addon.Name
+	}
+
+	return release, nil
+}
+
+// resolveChart loads the chart of a release, and pins the version of the
+// source to the version loaded.
+func (r *TurtleAddonReconciler) resolveChart(ctx context.Context, namespace string, src *infrav1alpha1.HelmChartSource) (*helm.Chart, error) {
+	data, err := r.sourceData(ctx, namespace, infrav1alpha1.AddonSource{Kind: infrav1alpha1.AddonSourceConfigMap, Name: src.ConfigMap})
+	if err != nil {
+		return nil, err
+	}
+
+	chart, err := loadChart(data, src)
+	if err != nil {
+		return nil, &addonSourceError{fmt.Sprintf("configmap %s: %s", src.ConfigMap, err)}
+	}
+
+	src.Version = chart.Metadata.Version
+	return chart, nil
+}
+
+// loadChart loads a chart from the values of a ConfigMap, either packaged
+// under a key or listed in a repository index.
+func loadChart(data map[string][]byte, src *infrav1alpha1.HelmChartSource) (*helm.Chart, error) {
+	switch {
+	case src.Key != "" && src.Name != "":
+		return nil, fmt.Errorf("set either the key or the name of the chart, not both")
+	case src.Key != "":
+		archive, ok := data[src.Key]
+		if !ok {
+			return nil, fmt.Errorf("key %q not found", src.Key)
+		}
+		chart, err := helm.Load(archive)
+		if err != nil {
+			return nil, err
+		}
+		if src.Version != "" {
+			if err := chart.CheckVersion(src.Version); err != nil {
+				return nil, err
+			}
+		}
+		return chart, nil
+	case src.Name != "":
+		raw, ok := data[helmIndexKey]
+		if !ok {
+			return nil, fmt.Errorf("key %q not found", helmIndexKey)
+		}
+		index, err := helm.LoadIndex(raw)
+		if err != nil {
+			return nil, err
+		}
+		version, err := index.Get(src.Name, src.Version)
+		if err != nil {
+			return nil, err
+		}
+		key, err := version.ArchiveName()
+		if err != nil {
+			return nil, err
+		}
+		archive, ok := data[key]
+		if !ok {
+			return nil, fmt.Errorf("chart %s %s is listed in the index but key %q is not found", version.Name, version.Version, key)
+		}
+		if err := version.Verify(archive); err != nil {
+			return nil, err
+		}
+		return helm.Load(archive)
+	default:
+		return nil, fmt.Errorf("set the key or the name of the chart")
+	}
+}
+
+// render renders the release for a turtle, with the namespace of the
+// release. It is an install until the cluster reports a revision of the
+// release.
+func (h *helmRelease) render(ctx context.Context, turtle *infrav1alpha1.Turtle, status *infrav1alpha1.AddonClusterStatus, cluster helmCluster) ([]byte, error) {
+	values, err := h.values.Execute(turtle)
+	if err != nil {
+		return nil, err
+	}
+
+	apiVersions, err := cluster.APIVersions()
+	if err != nil {
+		return nil, err
+	}
+	capabilities, err := helm.NewCapabilities(turtle.Spec.Version, apiVersions)
+	if err != nil {
+		return nil, err
+	}
+
+	release := helm.Release{
+		Name:      h.name,
+		Namespace: h.spec.Namespace,
+		Revision:  int(h.revision),
+		IsInstall: status.Revision == 0,
+		IsUpgrade: status.Revision != 0,
+	}
+	manifest, err := helm.Render(h.chart, release, capabilities, values, cluster.RESTConfig())
+	if err != nil {
+		return nil, err
+	}
+
+	namespace := fmt.Sprintf("apiVersion: v1\nkind: Namespace\nmetadata:\n  name: %s\n---\n", h.spec.Namespace)
+	return append([]byte(namespace), manifest...), nil
+}
+
+// prune deletes the objects previous revisions of the release applied to a
+// cluster which are not in the results of applying the current one, and
+// records the objects of the release in the status of the cluster. Objects
+// which couldn't be deleted stay recorded, so they are deleted by the next
+// apply. Like Helm, the namespace of the release and the
+// CustomResourceDefinitions of the chart are never deleted.
+func (h *helmRelease) prune(ctx context.Context, c client.Writer, results remote.ApplyResults, status *infrav1alpha1.AddonClusterStatus) error {
+	current := make([]infrav1alpha1.AddonObjectReference, 0, len(results))
+	for _, result := range results {
+		if result.GroupVersionKind.GroupKind() == (schema.GroupKind{Kind: "Namespace"}) && result.Name == h.spec.Namespace {
+			continue
+		}
+		if result.GroupVersionKind.GroupKind() == (schema.GroupKind{Group: "apiextensions.k8s.io", Kind: "CustomResourceDefinition"}) {
+			continue
+		}
+		current = append(current, infrav1alpha1.AddonObjectReference{
+			APIVersion: result.GroupVersionKind.GroupVersion().String(),
+			Kind:       result.GroupVersionKind.Kind,
+			Namespace:  result.Namespace,
+			Name:       result.Name,
+		})
+	}
+
+	remaining, err := deleteObjects(ctx, c, staleObjects(status.Objects, current))
+	status.Objects = append(current, remaining...)
+	return err
+}
+
+// staleObjects returns the previous objects which are not current. Objects
+// are the same regardless of their API version.
+func staleObjects(previous, current []infrav1alpha1.AddonObjectReference) []infrav1alpha1.AddonObjectReference {
+	key := func(ref infrav1alpha1.AddonObjectReference) string {
+		gv, _ := schema.ParseGroupVersion(ref.APIVersion)
+		return strings.Join([]string{gv.Group, ref.Kind, ref.Namespace, ref.Name}, "/")
+	}
+
+	keep := sets.NewString()
+	for _, ref := range current {
+		keep.Insert(key(ref))
+	}
+
+	var stale []infrav1alpha1.AddonObjectReference
+	for _, ref := range previous {
+		if !keep.Has(key(ref)) {
+			stale = append(stale, ref)
+		}
+	}
+	return stale
+}
+
+// deleteObjects deletes objects in the reverse order they were applied, so
+// namespaces and CustomResourceDefinitions go last, and returns those which
+// couldn't be deleted.
+func deleteObjects(ctx context.Context, c client.Writer, refs []infrav1alpha1.AddonObjectReference) ([]infrav1alpha1.AddonObjectReference, error) {
+	var remaining []infrav1alpha1.AddonObjectReference
+	var errs []error
+	for i := len(refs) - 1; i >= 0; i-- {
+		ref := refs[i]
+		obj := &unstructured.Unstructured{}
+		obj.SetAPIVersion(ref.APIVersion)
+		obj.SetKind(ref.Kind)
+		obj.SetNamespace(ref.Namespace)
+		obj.SetName(ref.Name)
+		if err := c.Delete(ctx, obj, client.PropagationPolicy(metav1.DeletePropagationBackground)); client.IgnoreNotFound(err) != nil {
+			remaining = append([]infrav1alpha1.AddonObjectReference{ref}, remaining...)
+			errs = append(errs, fmt.Errorf("%s %s: %w", ref.Kind, ref.Name, err))
+		}
+	}
+	return remaining, utilerrors.NewAggregate(errs)
+}
+
+// chartName returns the name and version of the chart, like Helm lists it.
+func (h *helmRelease) chartName() string {
+	return fmt.Sprintf("%s-%s", h.chart.Metadata.Name, h.chart.Metadata.Version)
+}
+
+// helmRevisionName returns the name of the revision recording a release of
+// an addon.
+func helmRevisionName(addon *infrav1alpha1.TurtleAddon, spec infrav1alpha1.HelmSpec) string {
+	return fmt.Sprintf("%s-%s", addon.Name, computeJSONHash(spec))
+}
+
+// history returns the release history of an addon.
+func (r *TurtleAddonReconciler) history(addon *infrav1alpha1.TurtleAddon) *revisionHistory {
+	return &revisionHistory{client: r.Client, scheme: r.Scheme, owner: addon, label: infrav1alpha1.TurtleAddonNameLabel}
+}
+
+// rollback replaces the helm release of an addon with a previous revision
+// as requested by RollbackToAnnotation, and clears the annotation. It
+// reports whether the addon was rolled back. A rollback which fails only
+// clears the annotation, so the addon isn't stuck retrying it.
+func (r *TurtleAddonReconciler) rollback(ctx context.Context, log logr.Logger, addon *infrav1alpha1.TurtleAddon) bool {
+	value, ok := addon.Annotations[infrav1alpha1.RollbackToAnnotation]
+	if !ok {
+		return false
+	}
+
+	target, spec, err := r.rollbackTarget(ctx, addon, value)
+	if err == nil {
+		updated := addon.DeepCopy()
+		updated.Spec.Helm = spec
+		delete(updated.Annotations, infrav1alpha1.RollbackToAnnotation)
+		if err = r.Update(ctx, updated); err == nil {
+			*addon = *updated
+			log.Info("rolled back helm release", "revision", target.Revision)
+			r.Recorder.Eventf(addon, corev1.EventTypeNormal, "RolledBack", "Rolled back to revision %d", target.Revision)
+			return true
+		}
+		err = fmt.Errorf("failed to update addon: %w", err)
+	}
+
+	log.Error(err, "unable to roll back", "rollbackTo", value)
+	r.Recorder.Eventf(addon, corev1.EventTypeWarning, "RollbackFailed", "Unable to roll back to revision %q: %s", value, err)
+
+	patch := client.MergeFrom(addon.DeepCopy())
+	delete(addon.Annotations, infrav1alpha1.RollbackToAnnotation)
+	if err := r.Patch(ctx, addon, patch); err != nil {
+		log.Error(err, "unable to clear rollback annotation")
+	}
+	return false
+}
+
+// rollbackTarget returns the revision an addon is rolled back to, and its
+// helm release.
+func (r *TurtleAddonReconciler) rollbackTarget(
+	ctx context.Context,
+	addon *infrav1alpha1.TurtleAddon,
+	value string,
+) (*appsv1.ControllerRevision, *infrav1alpha1.HelmSpec, error) {
+	if addon.Spec.Helm == nil {
+		return nil, nil, fmt.Errorf("addon does not install a helm chart")
+	}
+
+	target, err := r.history(addon).find(ctx, value)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var spec infrav1alpha1.HelmSpec
+	if err := json.Unmarshal(target.Data.Raw, &spec); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode revision %s: %w", target.Name, err)
+	}
+
+	return target, &spec, nil
+}
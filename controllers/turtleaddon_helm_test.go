@@ -0,0 +1,389 @@
+// Copyright 2020 Alexander Eldeib
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS
+// OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+// CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+// TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package controllers
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	infrav1alpha1 "github.com/alexeldeib/bale/api/v1alpha1"
+	"github.com/alexeldeib/bale/pkg/helm"
+	"github.com/alexeldeib/bale/pkg/remote"
+)
+
+// packageChart returns a minimal chart archive with the given version,
+// rendering a ConfigMap from its values.
+func packageChart(t *testing.T, version string) []byte {
+	files := map[string]string{
+		"Chart.yaml":  fmt.Sprintf("name: agent\nversion: %s\n", version),
+		"values.yaml": "region: unknown\n",
+		"templates/configmap.yaml": `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: {{ .Release.Name }}
+data:
+  region: {{ .Values.region }}
+  install: {{ .Release.IsInstall | quote }}
+  service: {{ .Release.Service }}
+  monitoring: {{ .Capabilities.APIVersions.Has "monitoring.coreos.com/v1" | quote }}
+`,
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		header := &tar.Header{Name: "agent/" + name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestLoadChart(t *testing.T) {
+	older, newer := packageChart(t, "1.0.0"), packageChart(t, "1.1.0")
+	sum := sha256.Sum256(newer)
+	index := fmt.Sprintf(`apiVersion: v1
+entries:
+  agent:
+  - name: agent
+    version: 1.0.0
+    urls: [https://charts.example.com/agent-1.0.0.tgz]
+  - name: agent
+    version: 1.1.0
+    digest: %s
+    urls: [https://charts.example.com/agent-1.1.0.tgz]
+`, hex.EncodeToString(sum[:]))
+
+	data := map[string][]byte{
+		"agent.tgz":       older,
+		helmIndexKey:      []byte(index),
+		"agent-1.0.0.tgz": older,
+		"agent-1.1.0.tgz": newer,
+	}
+
+	cases := []struct {
+		name      string
+		src       infrav1alpha1.HelmChartSource
+		version   string
+		expectErr string
+	}{
+		{
+			name:    "packaged",
+			src:     infrav1alpha1.HelmChartSource{Key: "agent.tgz"},
+			version: "1.0.0",
+		},
+		{
+			name:      "packaged version mismatch",
+			src:       infrav1alpha1.HelmChartSource{Key: "agent.tgz", Version: "1.1.0"},
+			expectErr: "does not satisfy",
+		},
+		{
+			name:    "newest in index",
+			src:     infrav1alpha1.HelmChartSource{Name: "agent"},
+			version: "1.1.0",
+		},
+		{
+			name:    "constrained in index",
+			src:     infrav1alpha1.HelmChartSource{Name: "agent", Version: "~1.0.0"},
+			version: "1.0.0",
+		},
+		{
+			name:      "missing from index",
+			src:       infrav1alpha1.HelmChartSource{Name: "agent", Version: "2.x"},
+			expectErr: "no version of chart agent",
+		},
+		{
+			name:      "key and name",
+			src:       infrav1alpha1.HelmChartSource{Key: "agent.tgz", Name: "agent"},
+			expectErr: "not both",
+		},
+		{
+			name:      "missing key",
+			src:       infrav1alpha1.HelmChartSource{Key: "other.tgz"},
+			expectErr: `key "other.tgz" not found`,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			chart, err := loadChart(data, &tc.src)
+			if tc.expectErr != "" {
+				g.Expect(err).To(MatchError(ContainSubstring(tc.expectErr)))
+				return
+			}
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(chart.Metadata.Version).To(Equal(tc.version))
+		})
+	}
+
+	g := NewWithT(t)
+	data["agent-1.1.0.tgz"] = older
+	_, err := loadChart(data, &infrav1alpha1.HelmChartSource{Name: "agent"})
+	g.Expect(err).To(MatchError(ContainSubstring("does not match the index")))
+}
+
+// fakeCluster is a cluster serving fixed API versions, which lookup can't
+// reach.
+type fakeCluster struct {
+	apiVersions []string
+}
+
+func (f *fakeCluster) APIVersions() ([]string, error) {
+	return f.apiVersions, nil
+}
+
+func (f *fakeCluster) RESTConfig() *rest.Config {
+	return nil
+}
+
+func TestHelmReleaseRender(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	chart, err := helm.Load(packageChart(t, "1.0.0"))
+	g.Expect(err).NotTo(HaveOccurred())
+	values, err := helm.ParseValues("region: {{ .Spec.Location }}\n")
+	g.Expect(err).NotTo(HaveOccurred())
+
+	release := &helmRelease{
+		name:     "agent",
+		spec:     infrav1alpha1.HelmSpec{Namespace: "agents"},
+		chart:    chart,
+		values:   values,
+		revision: 2,
+	}
+	renderer := &addonRenderer{release: release}
+
+	turtle := &infrav1alpha1.Turtle{
+		ObjectMeta: metav1.ObjectMeta{Name: "turtle-1"},
+		Spec:       infrav1alpha1.TurtleSpec{Location: "westus2", Version: "1.18.3"},
+	}
+	status := &infrav1alpha1.AddonClusterStatus{Turtle: turtle.Name}
+	cluster := &fakeCluster{apiVersions: []string{"v1", "monitoring.coreos.com/v1"}}
+
+	manifest, err := renderer.render(ctx, turtle, status, cluster)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(string(manifest)).To(HavePrefix("apiVersion: v1\nkind: Namespace\nmetadata:\n  name: agents\n---\n"))
+	g.Expect(string(manifest)).To(ContainSubstring("region: westus2"))
+	g.Expect(string(manifest)).To(ContainSubstring(`install: "true"`))
+	g.Expect(string(manifest)).To(ContainSubstring("service: Helm"))
+	g.Expect(string(manifest)).To(ContainSubstring(`monitoring: "true"`))
+
+	renderer.setApplied(status)
+	g.Expect(status.Revision).To(BeEquivalentTo(2))
+	g.Expect(status.Chart).To(Equal("agent-1.0.0"))
+
+	upgrade, err := renderer.render(ctx, turtle, status, cluster)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(string(upgrade)).To(ContainSubstring(`install: "false"`))
+
+	hash := renderer.hash(upgrade)
+	release.revision = 3
+	g.Expect(renderer.hash(upgrade)).NotTo(Equal(hash), "every release is recorded on the cluster")
+
+	turtle.Spec.Version = "latest"
+	_, err = renderer.render(ctx, turtle, status, cluster)
+	g.Expect(err).To(MatchError(ContainSubstring("invalid kubernetes version")))
+}
+
+func TestValidateAddonSpec(t *testing.T) {
+	sources := []infrav1alpha1.AddonSource{{Name: "monitoring"}}
+	chart := &infrav1alpha1.HelmSpec{Chart: infrav1alpha1.HelmChartSource{ConfigMap: "charts", Key: "agent.tgz"}}
+
+	cases := []struct {
+		name  string
+		spec  infrav1alpha1.TurtleAddonSpec
+		valid bool
+	}{
+		{name: "sources", spec: infrav1alpha1.TurtleAddonSpec{Sources: sources}, valid: true},
+		{name: "helm", spec: infrav1alpha1.TurtleAddonSpec{Helm: chart}, valid: true},
+		{name: "neither", spec: infrav1alpha1.TurtleAddonSpec{}},
+		{name: "both", spec: infrav1alpha1.TurtleAddonSpec{Sources: sources, Helm: chart}},
+		{name: "kustomized chart", spec: infrav1alpha1.TurtleAddonSpec{Helm: chart, Kustomize: &infrav1alpha1.KustomizeSpec{}}},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+			err := validateAddonSpec(&tc.spec)
+			if tc.valid {
+				g.Expect(err).NotTo(HaveOccurred())
+				return
+			}
+			var invalid *addonSourceError
+			g.Expect(err).To(BeAssignableToTypeOf(invalid))
+		})
+	}
+}
+
+func TestHelmReleasePrune(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	configMap := func(name string) *corev1.ConfigMap {
+		return &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "agents", Name: name}}
+	}
+	ref := func(apiVersion, kind, name string) infrav1alpha1.AddonObjectReference {
+		return infrav1alpha1.AddonObjectReference{APIVersion: apiVersion, Kind: kind, Namespace: "agents", Name: name}
+	}
+
+	c := fake.NewFakeClient(configMap("agent"), configMap("removed"))
+	release := &helmRelease{name: "agent", spec: infrav1alpha1.HelmSpec{Namespace: "agents"}}
+	status := &infrav1alpha1.AddonClusterStatus{
+		Objects: []infrav1alpha1.AddonObjectReference{
+			ref("v1", "ConfigMap", "agent"),
+			ref("v1", "ConfigMap", "removed"),
+			ref("v1", "ConfigMap", "gone"),
+			ref("apps/v1beta2", "Deployment", "agent"),
+		},
+	}
+	results := remote.ApplyResults{
+		{GroupVersionKind: schema.GroupVersionKind{Version: "v1", Kind: "Namespace"}, Name: "agents"},
+		{GroupVersionKind: schema.GroupVersionKind{Group: "apiextensions.k8s.io", Version: "v1", Kind: "CustomResourceDefinition"}, Name: "agents.example.com"},
+		{GroupVersionKind: schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}, Namespace: "agents", Name: "agent"},
+		{GroupVersionKind: schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}, Namespace: "agents", Name: "agent"},
+	}
+
+	g.Expect(release.prune(ctx, c, results, status)).To(Succeed())
+	g.Expect(status.Objects).To(Equal([]infrav1alpha1.AddonObjectReference{
+		ref("v1", "ConfigMap", "agent"),
+		ref("apps/v1", "Deployment", "agent"),
+	}), "the release namespace and CRDs are not recorded")
+
+	var configMaps corev1.ConfigMapList
+	g.Expect(c.List(ctx, &configMaps)).To(Succeed())
+	g.Expect(configMaps.Items).To(HaveLen(1))
+	g.Expect(configMaps.Items[0].Name).To(Equal("agent"))
+}
+
+func TestTurtleAddonRollback(t *testing.T) {
+	chart := infrav1alpha1.HelmChartSource{ConfigMap: "charts", Name: "agent"}
+	v1 := infrav1alpha1.HelmSpec{Chart: chart, Namespace: "agents", Values: "version: 1\n"}
+	v2 := infrav1alpha1.HelmSpec{Chart: chart, Namespace: "agents", Values: "version: 2\n"}
+
+	cases := []struct {
+		name     string
+		sources  bool
+		value    string
+		conflict bool
+		want     infrav1alpha1.HelmSpec
+		event    string
+	}{
+		{name: "previous revision", value: "1", want: v1, event: "RolledBack"},
+		{name: "missing revision", value: "7", want: v2, event: "RollbackFailed"},
+		{name: "not a helm addon", sources: true, value: "1", event: "RollbackFailed"},
+		{name: "conflicting update", value: "1", conflict: true, want: v2, event: "RollbackFailed"},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+			ctx := context.Background()
+
+			scheme := runtime.NewScheme()
+			g.Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+			g.Expect(infrav1alpha1.AddToScheme(scheme)).To(Succeed())
+
+			addon := &infrav1alpha1.TurtleAddon{
+				ObjectMeta: metav1.ObjectMeta{Name: "agent", Namespace: "default", UID: "addon-uid"},
+			}
+			recorder := record.NewFakeRecorder(10)
+			r := &TurtleAddonReconciler{
+				Client:   fake.NewFakeClientWithScheme(scheme, addon),
+				Log:      ctrl.Log,
+				Scheme:   scheme,
+				Recorder: recorder,
+			}
+
+			key := types.NamespacedName{Namespace: addon.Namespace, Name: addon.Name}
+			g.Expect(r.Get(ctx, key, addon)).To(Succeed())
+			for _, spec := range []infrav1alpha1.HelmSpec{v1, v2} {
+				_, err := r.history(addon).record(ctx, r.Log, helmRevisionName(addon, spec), spec, defaultRevisionHistoryLimit)
+				g.Expect(err).NotTo(HaveOccurred())
+			}
+			if tc.sources {
+				addon.Spec.Sources = []infrav1alpha1.AddonSource{{Name: "agent"}}
+			} else {
+				addon.Spec.Helm = v2.DeepCopy()
+			}
+			addon.Annotations = map[string]string{infrav1alpha1.RollbackToAnnotation: tc.value}
+			g.Expect(r.Update(ctx, addon)).To(Succeed())
+
+			if tc.conflict {
+				stored := &infrav1alpha1.TurtleAddon{}
+				g.Expect(r.Get(ctx, key, stored)).To(Succeed())
+				stored.Labels = map[string]string{"changed": "true"}
+				g.Expect(r.Update(ctx, stored)).To(Succeed())
+			}
+
+			g.Expect(r.rollback(ctx, r.Log, addon)).To(Equal(tc.event == "RolledBack"))
+
+			stored := &infrav1alpha1.TurtleAddon{}
+			g.Expect(r.Get(ctx, key, stored)).To(Succeed())
+			g.Expect(stored.Annotations).NotTo(HaveKey(infrav1alpha1.RollbackToAnnotation), "the annotation is cleared")
+			if tc.sources {
+				g.Expect(stored.Spec.Helm).To(BeNil())
+			} else {
+				g.Expect(stored.Spec.Helm).To(Equal(&tc.want))
+			}
+
+			g.Expect(recorder.Events).To(Receive(WithTransform(func(event string) string {
+				return strings.Fields(event)[1]
+			}, Equal(tc.event))))
+		})
+	}
+}
@@ -0,0 +1,394 @@
+// Copyright 2020 Alexander Eldeib
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS
+// OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+// CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+// TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"text/template"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	infrav1alpha1 "github.com/alexeldeib/bale/api/v1alpha1"
+	"github.com/alexeldeib/bale/pkg/remote"
+)
+
+// addonSourceError means the sources of an addon can't be used: one doesn't
+// exist, lacks a key or holds invalid manifests.
+type addonSourceError struct {
+	message string
+}
+
+func (e *addonSourceError) Error() string {
+	return e.message
+}
+
+// addonRenderer renders the manifests of an addon for a turtle. Sources
+// applied as they are render the same manifests for every turtle, while
+// kustomizations are built once for each directory turtles resolve to.
+// Helm releases are rendered for each turtle.
+type addonRenderer struct {
+	manifest []byte
+	files    map[string][]byte
+	dir      *template.Template
+	builds   map[string]kustomizeBuild
+	release  *helmRelease
+}
+
+// kustomizeBuild is the outcome of building a kustomization.
+type kustomizeBuild struct {
+	manifest []byte
+	err      error
+}
+
+// loadSources reads the sources of an addon, or the chart it installs.
+func (r *TurtleAddonReconciler) loadSources(ctx context.Context, log logr.Logger, addon *infrav1alpha1.TurtleAddon) (*addonRenderer, error) {
+	if err := validateAddonSpec(&addon.Spec); err != nil {
+		return nil, err
+	}
+
+	if addon.Spec.Helm != nil {
+		release, err := r.loadRelease(ctx, log, addon)
+		if err != nil {
+			return nil, err
+		}
+		return &addonRenderer{release: release}, nil
+	}
+
+	renderer := &addonRenderer{files: map[string][]byte{}, builds: map[string]kustomizeBuild{}}
+
+	var documents [][]byte
+	for _, src := range addon.Spec.Sources {
+		data, err := r.sourceData(ctx, addon.Namespace, src)
+		if err != nil {
+			return nil, err
+		}
+
+		if addon.Spec.Kustomize != nil {
+			if err := addSourceFiles(renderer.files, data, src); err != nil {
+				return nil, &addonSourceError{fmt.Sprintf("%s %s: %s", src.Kind, src.Name, err)}
+			}
+			continue
+		}
+
+		manifests, err := sourceManifests(data, src.Keys)
+		if err != nil {
+			return nil, &addonSourceError{fmt.Sprintf("%s %s: %s", src.Kind, src.Name, err)}
+		}
+		documents = append(documents, manifests...)
+	}
+
+	if addon.Spec.Kustomize != nil {
+		dir, err := template.New("path").Option("missingkey=error").Parse(addon.Spec.Kustomize.Path)
+		if err != nil {
+			return nil, &addonSourceError{fmt.Sprintf("invalid kustomize path: %s", err)}
+		}
+		renderer.dir = dir
+		return renderer, nil
+	}
+
+	if len(documents) == 0 {
+		return nil, &addonSourceError{"sources contain no manifests"}
+	}
+
+	renderer.manifest = bytes.Join(documents, []byte("\n---\n"))
+	if _, err := remote.Decode(renderer.manifest); err != nil {
+		return nil, &addonSourceError{err.Error()}
+	}
+
+	return renderer, nil
+}
+
+// validateAddonSpec checks the combinations of fields the schema doesn't.
+func validateAddonSpec(spec *infrav1alpha1.TurtleAddonSpec) error {
+	switch {
+	case spec.Helm != nil && len(spec.Sources) > 0:
+		return &addonSourceError{"set either sources or helm, not both"}
+	case spec.Helm == nil && len(spec.Sources) == 0:
+		return &addonSourceError{"set sources or helm"}
+	case spec.Helm != nil && spec.Kustomize != nil:
+		return &addonSourceError{"kustomize only builds sources, not helm charts"}
+	}
+	return nil
+}
+
+// render returns the manifests of the addon for a turtle, given the state
+// of the addon in its cluster. Only helm releases read the cluster.
+func (a *addonRenderer) render(ctx context.Context, turtle *infrav1alpha1.Turtle, status *infrav1alpha1.AddonClusterStatus, cluster helmCluster) ([]byte, error) {
+	if a.release != nil {
+		return a.release.render(ctx, turtle, status, cluster)
+	}
+
+	if a.dir == nil {
+		return a.manifest, nil
+	}
+
+	var dir bytes.Buffer
+	if err := a.dir.Execute(&dir, turtle); err != nil {
+		return nil, fmt.Errorf("failed to resolve kustomize path: %w", err)
+	}
+
+	build, ok := a.builds[dir.String()]
+	if !ok {
+		build.manifest, build.err = remote.Build(a.files, dir.String())
+		a.builds[dir.String()] = build
+	}
+
+	return build.manifest, build.err
+}
+
+// hash returns the hash recorded for manifests applied to a cluster. For
+// helm releases it covers the revision, so a release which renders the same
+// manifests as the previous one is still recorded on each cluster.
+func (a *addonRenderer) hash(manifest []byte) string {
+	if a.release == nil {
+		return computeJSONHash(string(manifest))
+	}
+	return computeJSONHash(struct {
+		Manifest string
+		Revision int64
+	}{string(manifest), a.release.revision})
+}
+
+// inputHash returns the hash of what the manifests for a turtle are
+// rendered from. Kustomizations and helm releases may be rendered from the
+// turtle, but not from its status, which changes all the time.
+func (a *addonRenderer) inputHash(turtle *infrav1alpha1.Turtle) string {
+	inputs := struct {
+		Manifest string
+		Files    map[string][]byte
+		Revision int64
+		Turtle   interface{}
+	}{Manifest: string(a.manifest), Files: a.files}
+	if a.release != nil {
+		inputs.Revision = a.release.revision
+	}
+	if a.dir != nil || a.release != nil {
+		inputs.Turtle = struct {
+			Meta metav1.ObjectMeta
+			Spec infrav1alpha1.TurtleSpec
+		}{
+			Meta: metav1.ObjectMeta{Name: turtle.Name, Labels: turtle.Labels, Annotations: turtle.Annotations},
+			Spec: turtle.Spec,
+		}
+	}
+	return computeJSONHash(inputs)
+}
+
+// applyOptions returns the options the manifests are applied with: helm
+// releases default to the namespace of the release.
+func (a *addonRenderer) applyOptions() []remote.ApplyOption {
+	if a.release == nil {
+		return nil
+	}
+	return []remote.ApplyOption{remote.WithNamespace(a.release.spec.Namespace)}
+}
+
+// prune deletes the objects of a helm release its current revision no
+// longer renders from a cluster, see helmRelease.prune. Other addons don't
+// track what they applied.
+func (a *addonRenderer) prune(ctx context.Context, c client.Writer, results remote.ApplyResults, status *infrav1alpha1.AddonClusterStatus) error {
+	if a.release == nil {
+		status.Objects = nil
+		return nil
+	}
+	return a.release.prune(ctx, c, results, status)
+}
+
+// setApplied records the release applied to a cluster, if any.
+func (a *addonRenderer) setApplied(status *infrav1alpha1.AddonClusterStatus) {
+	status.Revision, status.Chart = 0, ""
+	if a.release != nil {
+		status.Revision = a.release.revision
+		status.Chart = a.release.chartName()
+	}
+}
+
+// addSourceFiles adds the values of a source to the files of a kustomize
+// build, in the directory of the source.
+func addSourceFiles(files, data map[string][]byte, src infrav1alpha1.AddonSource) error {
+	keys := src.Keys
+	if len(keys) == 0 {
+		for key := range data {
+			keys = append(keys, key)
+		}
+	}
+
+	for _, key := range keys {
+		value, ok := data[key]
+		if !ok {
+			return fmt.Errorf("key %q not found", key)
+		}
+
+		name := path.Join(src.Path, key)
+		if _, ok := files[name]; ok {
+			return fmt.Errorf("file %s is provided by more than one source", name)
+		}
+		files[name] = value
+	}
+
+	return nil
+}
+
+// sourceManifests returns the non-empty values of a source in order: the
+// listed keys, or every key sorted by name.
+func sourceManifests(data map[string][]byte, keys []string) ([][]byte, error) {
+	if len(keys) == 0 {
+		for key := range data {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+	}
+
+	var manifests [][]byte
+	for _, key := range keys {
+		value, ok := data[key]
+		if !ok {
+			return nil, fmt.Errorf("key %q not found", key)
+		}
+		if len(bytes.TrimSpace(value)) > 0 {
+			manifests = append(manifests, value)
+		}
+	}
+
+	return manifests, nil
+}
+
+// maxRenderedSize keeps the manifests rendered for a turtle, and the
+// metadata of the object holding them, within the size limit of objects.
+const maxRenderedSize = 1000 * 1000
+
+// renderedKey is the key of the manifests in a rendered object.
+const renderedKey = "manifests.yaml"
+
+// renderedName returns the name of the object holding the manifests of a
+// render only addon for a turtle.
+func renderedName(addon *infrav1alpha1.TurtleAddon, turtle string) string {
+	return fmt.Sprintf("%s-%s-rendered", addon.Name, turtle)
+}
+
+// renderedKind returns the kind of the objects holding the manifests of a
+// render only addon: Secrets for helm releases, whose charts and values may
+// render Secrets, or if any source is a Secret.
+func renderedKind(addon *infrav1alpha1.TurtleAddon) infrav1alpha1.AddonSourceKind {
+	if addon.Spec.Helm != nil {
+		return infrav1alpha1.AddonSourceSecret
+	}
+	for _, src := range addon.Spec.Sources {
+		if src.Kind == infrav1alpha1.AddonSourceSecret {
+			return infrav1alpha1.AddonSourceSecret
+		}
+	}
+	return infrav1alpha1.AddonSourceConfigMap
+}
+
+// reconcileRendered writes the manifests rendered for each turtle to an
+// object of its own, and removes the objects no longer rendered.
+func (r *TurtleAddonReconciler) reconcileRendered(ctx context.Context, addon *infrav1alpha1.TurtleAddon, rendered map[string][]byte) error {
+	kind := renderedKind(addon)
+	keep := sets.NewString()
+	for turtle := range rendered {
+		keep.Insert(renderedName(addon, turtle))
+	}
+
+	selector := client.MatchingLabels{infrav1alpha1.TurtleAddonNameLabel: addon.Name}
+	var configMaps corev1.ConfigMapList
+	if err := r.List(ctx, &configMaps, client.InNamespace(addon.Namespace), selector); err != nil {
+		return fmt.Errorf("failed to list rendered manifests: %w", err)
+	}
+	var secrets corev1.SecretList
+	if err := r.List(ctx, &secrets, client.InNamespace(addon.Namespace), selector); err != nil {
+		return fmt.Errorf("failed to list rendered manifests: %w", err)
+	}
+
+	// Objects of the other kind are stale too, after a Secret source is
+	// added or removed.
+	var stale []runtime.Object
+	for i := range configMaps.Items {
+		configMap := &configMaps.Items[i]
+		if metav1.IsControlledBy(configMap, addon) && !(kind == infrav1alpha1.AddonSourceConfigMap && keep.Has(configMap.Name)) {
+			stale = append(stale, configMap)
+		}
+	}
+	for i := range secrets.Items {
+		secret := &secrets.Items[i]
+		if metav1.IsControlledBy(secret, addon) && !(kind == infrav1alpha1.AddonSourceSecret && keep.Has(secret.Name)) {
+			stale = append(stale, secret)
+		}
+	}
+	for _, obj := range stale {
+		if err := r.Delete(ctx, obj); client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("failed to delete rendered manifests: %w", err)
+		}
+	}
+
+	for turtle, manifest := range rendered {
+		if err := r.writeRendered(ctx, addon, kind, turtle, manifest); err != nil {
+			return fmt.Errorf("failed to write rendered manifests for turtle %s: %w", turtle, err)
+		}
+	}
+
+	return nil
+}
+
+// writeRendered writes the manifests rendered for a turtle to a ConfigMap or
+// Secret.
+func (r *TurtleAddonReconciler) writeRendered(
+	ctx context.Context,
+	addon *infrav1alpha1.TurtleAddon,
+	kind infrav1alpha1.AddonSourceKind,
+	turtle string,
+	manifest []byte,
+) error {
+	meta := metav1.ObjectMeta{
+		Name:      renderedName(addon, turtle),
+		Namespace: addon.Namespace,
+		Labels:    map[string]string{infrav1alpha1.TurtleAddonNameLabel: addon.Name},
+	}
+
+	if kind == infrav1alpha1.AddonSourceSecret {
+		secret := &corev1.Secret{ObjectMeta: meta}
+		_, err := controllerutil.CreateOrUpdate(ctx, r.Client, secret, func() error {
+			secret.Labels = meta.Labels
+			secret.Data = map[string][]byte{renderedKey: manifest}
+			return controllerutil.SetControllerReference(addon, secret, r.Scheme)
+		})
+		return err
+	}
+
+	configMap := &corev1.ConfigMap{ObjectMeta: meta}
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, configMap, func() error {
+		configMap.Labels = meta.Labels
+		configMap.Data = map[string]string{renderedKey: string(manifest)}
+		return controllerutil.SetControllerReference(addon, configMap, r.Scheme)
+	})
+	return err
+}
@@ -0,0 +1,143 @@
+// Copyright 2020 Alexander Eldeib
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS
+// OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+// CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+// TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package controllers
+
+import (
+	"context"
+	"testing"
+	"text/template"
+
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	infrav1alpha1 "github.com/alexeldeib/bale/api/v1alpha1"
+)
+
+func TestAddonRendererKustomize(t *testing.T) {
+	g := NewWithT(t)
+
+	files := map[string][]byte{}
+	sources := []struct {
+		src  infrav1alpha1.AddonSource
+		data map[string][]byte
+	}{
+		{
+			src: infrav1alpha1.AddonSource{Name: "base", Path: "base"},
+			data: map[string][]byte{
+				"kustomization.yaml": []byte("resources:\n- configmap.yaml\n"),
+				"configmap.yaml":     []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: monitoring\n"),
+			},
+		},
+		{
+			src: infrav1alpha1.AddonSource{Name: "gpu", Path: "overlays/gpu"},
+			data: map[string][]byte{
+				"kustomization.yaml": []byte("bases:\n- ../../base\nnamePrefix: gpu-\n"),
+			},
+		},
+	}
+	for _, source := range sources {
+		g.Expect(addSourceFiles(files, source.data, source.src)).To(Succeed())
+	}
+	g.Expect(addSourceFiles(files, sources[0].data, sources[0].src)).NotTo(Succeed(), "files must not be provided twice")
+
+	renderer := &addonRenderer{
+		files:  files,
+		dir:    template.Must(template.New("path").Parse(`overlays/{{ index .Labels "class" }}`)),
+		builds: map[string]kustomizeBuild{},
+	}
+
+	gpu := &infrav1alpha1.Turtle{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"class": "gpu"}}}
+	manifest, err := renderer.render(context.Background(), gpu, &infrav1alpha1.AddonClusterStatus{}, nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(string(manifest)).To(ContainSubstring("name: gpu-monitoring"))
+
+	other := &infrav1alpha1.Turtle{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"class": "batch"}}}
+	_, err = renderer.render(context.Background(), other, &infrav1alpha1.AddonClusterStatus{}, nil)
+	g.Expect(err).To(HaveOccurred())
+	g.Expect(renderer.builds).To(HaveLen(2))
+}
+
+func TestReconcileRendered(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	scheme := runtime.NewScheme()
+	g.Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+	g.Expect(infrav1alpha1.AddToScheme(scheme)).To(Succeed())
+
+	addon := &infrav1alpha1.TurtleAddon{
+		ObjectMeta: metav1.ObjectMeta{Name: "agent", Namespace: "default", UID: "addon-uid"},
+		Spec: infrav1alpha1.TurtleAddonSpec{
+			Sources:    []infrav1alpha1.AddonSource{{Kind: infrav1alpha1.AddonSourceConfigMap, Name: "agent"}},
+			RenderOnly: true,
+		},
+	}
+	r := &TurtleAddonReconciler{Client: fake.NewFakeClientWithScheme(scheme, addon), Scheme: scheme}
+
+	names := func() []string {
+		var configMaps corev1.ConfigMapList
+		g.Expect(r.List(ctx, &configMaps)).To(Succeed())
+		var secrets corev1.SecretList
+		g.Expect(r.List(ctx, &secrets)).To(Succeed())
+		var names []string
+		for _, configMap := range configMaps.Items {
+			names = append(names, "configmap/"+configMap.Name)
+		}
+		for _, secret := range secrets.Items {
+			g.Expect(secret.Data).To(HaveKeyWithValue(renderedKey, []byte("kind: B")))
+			names = append(names, "secret/"+secret.Name)
+		}
+		return names
+	}
+
+	rendered := map[string][]byte{"turtle-1": []byte("kind: A"), "turtle-2": []byte("kind: B")}
+	g.Expect(r.reconcileRendered(ctx, addon, rendered)).To(Succeed())
+	g.Expect(names()).To(ConsistOf("configmap/agent-turtle-1-rendered", "configmap/agent-turtle-2-rendered"))
+
+	addon.Spec.Sources = append(addon.Spec.Sources, infrav1alpha1.AddonSource{Kind: infrav1alpha1.AddonSourceSecret, Name: "credentials"})
+	delete(rendered, "turtle-1")
+	g.Expect(r.reconcileRendered(ctx, addon, rendered)).To(Succeed())
+	g.Expect(names()).To(ConsistOf("secret/agent-turtle-2-rendered"), "secret content is not written to configmaps")
+
+	addon.Spec.RenderOnly = false
+	g.Expect(r.reconcileRendered(ctx, addon, nil)).To(Succeed())
+	g.Expect(names()).To(BeEmpty())
+}
+
+func TestRenderedKind(t *testing.T) {
+	g := NewWithT(t)
+
+	addon := &infrav1alpha1.TurtleAddon{}
+	addon.Spec.Sources = []infrav1alpha1.AddonSource{{Kind: infrav1alpha1.AddonSourceConfigMap, Name: "manifests"}}
+	g.Expect(renderedKind(addon)).To(Equal(infrav1alpha1.AddonSourceConfigMap))
+
+	addon.Spec.Sources = append(addon.Spec.Sources, infrav1alpha1.AddonSource{Kind: infrav1alpha1.AddonSourceSecret, Name: "credentials"})
+	g.Expect(renderedKind(addon)).To(Equal(infrav1alpha1.AddonSourceSecret))
+
+	addon.Spec.Sources = nil
+	addon.Spec.Helm = &infrav1alpha1.HelmSpec{}
+	g.Expect(renderedKind(addon)).To(Equal(infrav1alpha1.AddonSourceSecret), "charts may render secrets")
+}
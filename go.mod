@@ -5,21 +5,27 @@ go 1.13
 require (
 	github.com/Azure/go-autorest/autorest/azure/auth v0.5.0
 	github.com/Azure/go-autorest/autorest/to v0.4.0
+	github.com/Masterminds/goutils v1.1.1 // indirect
+	github.com/Masterminds/semver/v3 v3.1.0
+	github.com/Masterminds/sprig/v3 v3.1.0
 	github.com/blang/semver v3.5.1+incompatible
 	github.com/go-logr/logr v0.1.0
-	github.com/konsorten/go-windows-terminal-sequences v1.0.2 // indirect
+	github.com/huandu/xstrings v1.3.2 // indirect
+	github.com/mitchellh/copystructure v1.1.1 // indirect
 	github.com/onsi/ginkgo v1.13.0
 	github.com/onsi/gomega v1.10.1
 	github.com/pkg/errors v0.9.1
+	helm.sh/helm/v3 v3.2.4
 	k8s.io/api v0.18.5
 	k8s.io/apimachinery v0.18.5
-	k8s.io/cli-runtime v0.18.5
 	k8s.io/client-go v0.18.5
-	k8s.io/kubectl v0.18.5
 	k8s.io/kubernetes v1.18.5
+	k8s.io/utils v0.0.0-20200324210504-a9aa75ae1b89
 	sigs.k8s.io/cluster-api v0.3.6
 	sigs.k8s.io/cluster-api-provider-azure v0.4.5
 	sigs.k8s.io/controller-runtime v0.6.0
+	sigs.k8s.io/kustomize/api v0.6.5
+	sigs.k8s.io/yaml v1.2.0
 )
 
 replace (
@@ -26,6 +26,7 @@ import (
 	"os"
 
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	kubeadmv1beta1 "k8s.io/kubernetes/cmd/kubeadm/app/apis/kubeadm/v1beta1"
@@ -40,6 +41,7 @@ import (
 	balev1alpha1 "github.com/alexeldeib/bale/api/v1alpha1"
 	infrav1alpha1 "github.com/alexeldeib/bale/api/v1alpha1"
 	"github.com/alexeldeib/bale/controllers"
+	"github.com/alexeldeib/bale/pkg/remote"
 	// +kubebuilder:scaffold:imports
 )
 
@@ -59,6 +61,8 @@ func main() {
 	var webhookPort int
 	var enableLeaderElection bool
 	var healthAddr string
+	var credentialsNamespace string
+	var credentialsName string
 
 	flag.StringVar(
 		&metricsAddr,
@@ -87,6 +91,20 @@ func main() {
 		"The address the health endpoint binds to.",
 	)
 
+	flag.StringVar(
+		&credentialsNamespace,
+		"credentials-namespace",
+		"bale-system",
+		"The namespace of the Azure credentials secret used by Turtles without a credentialsRef.",
+	)
+
+	flag.StringVar(
+		&credentialsName,
+		"credentials-name",
+		"bale-manager-credentials",
+		"The name of the Azure credentials secret used by Turtles without a credentialsRef.",
+	)
+
 	flag.Parse()
 
 	ctrl.SetLogger(zap.New(zap.UseDevMode(true)))
@@ -104,10 +122,12 @@ func main() {
 	}
 
 	if webhookPort == 0 {
+		tracker := remote.NewClusterCacheTracker(ctrl.Log.WithName("remote"), mgr.GetScheme())
 		if err = (&controllers.BaleReconciler{
-			Client: mgr.GetClient(),
-			Log:    ctrl.Log.WithName("controllers").WithName("Bale"),
-			Scheme: mgr.GetScheme(),
+			Client:   mgr.GetClient(),
+			Log:      ctrl.Log.WithName("controllers").WithName("Bale"),
+			Scheme:   mgr.GetScheme(),
+			Recorder: mgr.GetEventRecorderFor("bale-controller"),
 		}).SetupWithManager(mgr); err != nil {
 			setupLog.Error(err, "unable to create controller", "controller", "Bale")
 			os.Exit(1)
@@ -116,10 +136,25 @@ func main() {
 			Client: mgr.GetClient(),
 			Log:    ctrl.Log.WithName("controllers").WithName("Turtle"),
 			Scheme: mgr.GetScheme(),
+			DefaultCredentials: types.NamespacedName{
+				Namespace: credentialsNamespace,
+				Name:      credentialsName,
+			},
+			Tracker: tracker,
 		}).SetupWithManager(mgr); err != nil {
 			setupLog.Error(err, "unable to create controller", "controller", "Turtle")
 			os.Exit(1)
 		}
+		if err = (&controllers.TurtleAddonReconciler{
+			Client:   mgr.GetClient(),
+			Log:      ctrl.Log.WithName("controllers").WithName("TurtleAddon"),
+			Scheme:   mgr.GetScheme(),
+			Recorder: mgr.GetEventRecorderFor("turtleaddon-controller"),
+			Tracker:  tracker,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "TurtleAddon")
+			os.Exit(1)
+		}
 	} else {
 		if err = (&infrav1alpha1.Bale{}).SetupWebhookWithManager(mgr); err != nil {
 			setupLog.Error(err, "unable to create webhook", "webhook", "Bale")
@@ -129,6 +164,10 @@ func main() {
 			setupLog.Error(err, "unable to create webhook", "webhook", "Bale")
 			os.Exit(1)
 		}
+		if err = (&infrav1alpha1.Turtle{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "Turtle")
+			os.Exit(1)
+		}
 	}
 	// +kubebuilder:scaffold:builder
 
@@ -0,0 +1,123 @@
+// Copyright 2020 Alexander Eldeib
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS
+// OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+// CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+// TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// Package cni bundles the manifests of the CNI plugins Bale installs into
+// workload clusters, so installing them doesn't depend on fetching mutable
+// URLs. Every version ever bundled stays available: a cluster only moves to
+// another version when asked to.
+package cni
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"text/template"
+
+	"github.com/blang/semver"
+
+	infrav1alpha1 "github.com/alexeldeib/bale/api/v1alpha1"
+)
+
+// Plugin is a CNI plugin, named as in the API.
+type Plugin = infrav1alpha1.CNIPlugin
+
+const (
+	// Calico is Project Calico with VXLAN encapsulation.
+	Calico = infrav1alpha1.CNICalico
+	// Cilium is Cilium with VXLAN encapsulation. Its operator assigns pod
+	// CIDRs to nodes from the pod CIDR of the cluster.
+	Cilium = infrav1alpha1.CNICilium
+	// Flannel is flannel with VXLAN encapsulation. It assigns pod addresses
+	// from the pod CIDRs allocated to nodes by the controller manager.
+	Flannel = infrav1alpha1.CNIFlannel
+)
+
+// manifests maps each plugin and version to its manifest template.
+var manifests = map[Plugin]map[string]string{
+	Calico: {
+		"v3.12.1": calicoV3121,
+	},
+	Cilium: {
+		"v1.8.2": ciliumV182,
+	},
+	Flannel: {
+		"v0.12.0": flannelV0120,
+	},
+}
+
+// Values are the values manifests are templated with.
+type Values struct {
+	// PodCIDR is the CIDR pod addresses are allocated from.
+	PodCIDR string
+}
+
+// Versions returns the bundled versions of a plugin, oldest first.
+func Versions(plugin Plugin) []string {
+	versions := make([]string, 0, len(manifests[plugin]))
+	for version := range manifests[plugin] {
+		versions = append(versions, version)
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return semver.MustParse(versions[i][1:]).LT(semver.MustParse(versions[j][1:]))
+	})
+	return versions
+}
+
+// Latest returns the newest bundled version of a plugin, or an empty string
+// for unknown plugins.
+func Latest(plugin Plugin) string {
+	versions := Versions(plugin)
+	if len(versions) == 0 {
+		return ""
+	}
+	return versions[len(versions)-1]
+}
+
+// NeedsNodeCIDRs reports whether a plugin relies on the controller manager
+// allocating a pod CIDR to every node.
+func NeedsNodeCIDRs(plugin Plugin) bool {
+	return plugin == Flannel
+}
+
+// Render returns the manifest of a version of a plugin.
+func Render(plugin Plugin, version string, values Values) ([]byte, error) {
+	versions, ok := manifests[plugin]
+	if !ok {
+		return nil, fmt.Errorf("unknown cni plugin %q", plugin)
+	}
+
+	manifest, ok := versions[version]
+	if !ok {
+		return nil, fmt.Errorf("version %s of cni plugin %s is not bundled, available versions are %v", version, plugin, Versions(plugin))
+	}
+
+	tmpl, err := template.New(string(plugin)).Option("missingkey=error").Parse(manifest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s %s manifest: %w", plugin, version, err)
+	}
+
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, values); err != nil {
+		return nil, fmt.Errorf("failed to render %s %s manifest: %w", plugin, version, err)
+	}
+
+	return out.Bytes(), nil
+}
@@ -0,0 +1,111 @@
+// Copyright 2020 Alexander Eldeib
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS
+// OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+// CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+// TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// Package helm renders Helm charts in process with Helm's own chart loader
+// and template engine, so releases can be applied to workload clusters like
+// any other manifest and render as helm template renders them.
+//
+// Dependencies must be vendored in the charts directory of a chart, as helm
+// dependency build does; they are never downloaded. Library charts can't be
+// installed. Install and upgrade hooks are applied with the rest of the
+// release, so their weights and deletion policies have no effect; other
+// hooks are left out.
+package helm
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/Masterminds/semver/v3"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+)
+
+// Chart is a loaded chart.
+type Chart struct {
+	// Metadata is the content of the Chart.yaml of the chart.
+	Metadata *chart.Metadata
+
+	// archive is the packaged chart. Rendering processes the dependencies
+	// of a chart in place, so every render loads its own copy.
+	archive []byte
+}
+
+// Load loads a packaged chart, a gzipped tarball holding the chart in a
+// single top-level directory.
+func Load(archive []byte) (*Chart, error) {
+	c, err := load(archive)
+	if err != nil {
+		return nil, err
+	}
+	if c.Metadata.Type == "library" {
+		return nil, fmt.Errorf("library chart %s can't be installed", c.Metadata.Name)
+	}
+	if err := checkDependencies(c); err != nil {
+		return nil, err
+	}
+	return &Chart{Metadata: c.Metadata, archive: archive}, nil
+}
+
+func load(archive []byte) (*chart.Chart, error) {
+	c, err := loader.LoadArchive(bytes.NewReader(archive))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load chart: %w", err)
+	}
+	return c, nil
+}
+
+// checkDependencies returns an error unless every dependency of a chart is
+// vendored in its charts directory.
+func checkDependencies(c *chart.Chart) error {
+	vendored := map[string]bool{}
+	for _, dependency := range c.Dependencies() {
+		vendored[dependency.Name()] = true
+	}
+
+	var missing []string
+	for _, dependency := range c.Metadata.Dependencies {
+		if !vendored[dependency.Name] {
+			missing = append(missing, dependency.Name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("dependencies %v of chart %s are not vendored in its charts directory", missing, c.Metadata.Name)
+	}
+	return nil
+}
+
+// CheckVersion returns an error unless the version of the chart satisfies
+// a semver constraint, like ~1.2.0 or an exact version.
+func (c *Chart) CheckVersion(constraint string) error {
+	constraints, err := semver.NewConstraint(constraint)
+	if err != nil {
+		return fmt.Errorf("invalid version constraint %q: %w", constraint, err)
+	}
+	version, err := semver.NewVersion(c.Metadata.Version)
+	if err != nil {
+		return fmt.Errorf("invalid version %q of chart %s: %w", c.Metadata.Version, c.Metadata.Name, err)
+	}
+	if !constraints.Check(version) {
+		return fmt.Errorf("version %s of chart %s does not satisfy %q", c.Metadata.Version, c.Metadata.Name, constraint)
+	}
+	return nil
+}
@@ -0,0 +1,428 @@
+// Copyright 2020 Alexander Eldeib
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS
+// OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+// CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+// TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package helm
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"k8s.io/client-go/rest"
+)
+
+// packageChart returns a chart archive holding the given files in the
+// directory of the chart.
+func packageChart(t *testing.T, name string, files map[string]string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for file, content := range files {
+		header := &tar.Header{Name: name + "/" + file, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+var exporterChart = map[string]string{
+	"Chart.yaml": `
+apiVersion: v2
+name: exporter
+version: 1.2.0
+appVersion: "0.9"
+kubeVersion: ">=1.16.0-0"
+`,
+	"values.yaml": `
+replicas: 1
+image:
+  repository: exporter
+  tag: latest
+labels:
+  team: platform
+`,
+	"templates/_helpers.tpl": `{{- define "exporter.fullname" -}}
+{{ .Release.Name }}-{{ .Chart.Name }}
+{{- end -}}`,
+	"templates/deployment.yaml": `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: {{ include "exporter.fullname" . }}
+  namespace: {{ .Release.Namespace }}
+  labels:
+{{ toYaml .Values.labels | indent 4 }}
+  annotations:
+    cluster: {{ required "cluster is required" .Values.cluster | quote }}
+    greeting: {{ tpl .Values.greeting . | quote }}
+spec:
+  replicas: {{ .Values.replicas }}
+  template:
+    spec:
+      containers:
+      - name: exporter
+        image: "{{ .Values.image.repository }}:{{ .Values.image.tag | default .Chart.AppVersion }}"
+`,
+	"templates/test.yaml": `
+apiVersion: v1
+kind: Pod
+metadata:
+  name: {{ .Release.Name }}-test
+  annotations:
+    helm.sh/hook: test
+`,
+	"templates/hooks.yaml": `
+apiVersion: batch/v1
+kind: Job
+metadata:
+  name: {{ .Release.Name }}-migrate
+  annotations:
+    helm.sh/hook: pre-install,pre-upgrade
+---
+apiVersion: batch/v1
+kind: Job
+metadata:
+  name: {{ .Release.Name }}-cleanup
+  annotations:
+    helm.sh/hook: pre-delete
+`,
+	"templates/NOTES.txt": `Thanks for installing {{ .Chart.Name }}`,
+	"files/config.txt":    `debug: true`,
+	"crds/exporters.yaml": `
+apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: exporters.metrics.example.com
+`,
+	"templates/configmap.yaml": `
+{{- if .Values.config }}
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: {{ .Release.Name }}-config
+data:
+  config.txt: {{ .Files.Get "files/config.txt" | quote }}
+  monitoring: {{ .Capabilities.APIVersions.Has "monitoring.coreos.com/v1/ServiceMonitor" | quote }}
+{{- with lookup "v1" "Namespace" "" .Release.Namespace }}
+  owner: {{ .metadata.labels.owner }}
+{{- end }}
+{{- end }}
+`,
+}
+
+func TestLoad(t *testing.T) {
+	g := NewWithT(t)
+
+	chart, err := Load(packageChart(t, "exporter", exporterChart))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(chart.Metadata.Name).To(Equal("exporter"))
+	g.Expect(chart.Metadata.Version).To(Equal("1.2.0"))
+	g.Expect(chart.CheckVersion("~1.2.0")).To(Succeed())
+	g.Expect(chart.CheckVersion("1.3.0")).To(MatchError(ContainSubstring("does not satisfy")))
+
+	umbrella, err := Load(packageChart(t, "umbrella", umbrellaChart))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(umbrella.Metadata.Dependencies).To(HaveLen(2))
+
+	_, err = Load(packageChart(t, "umbrella", map[string]string{
+		"Chart.yaml": "apiVersion: v2\nname: umbrella\nversion: 0.1.0\ndependencies:\n- name: exporter\n  version: 1.2.0\n",
+	}))
+	g.Expect(err).To(MatchError(ContainSubstring("[exporter] of chart umbrella are not vendored")))
+
+	_, err = Load(packageChart(t, "common", map[string]string{
+		"Chart.yaml": "apiVersion: v2\nname: common\nversion: 0.1.0\ntype: library\n",
+	}))
+	g.Expect(err).To(MatchError(ContainSubstring("library chart common can't be installed")))
+
+	_, err = Load(packageChart(t, "broken", map[string]string{"values.yaml": "{}"}))
+	g.Expect(err).To(MatchError(ContainSubstring("chart.metadata is required")))
+}
+
+func TestIndexGet(t *testing.T) {
+	archive := packageChart(t, "exporter", exporterChart)
+	sum := sha256.Sum256(archive)
+
+	index, err := LoadIndex([]byte(`
+apiVersion: v1
+entries:
+  exporter:
+  - name: exporter
+    version: 1.2.0
+    digest: ` + hex.EncodeToString(sum[:]) + `
+    urls:
+    - https://charts.example.com/exporter-1.2.0.tgz
+  - name: exporter
+    version: 1.10.1
+    urls:
+    - exporter-1.10.1.tgz
+  - name: exporter
+    version: 2.0.0-rc.1
+    urls:
+    - exporter-2.0.0-rc.1.tgz
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]struct {
+		constraint string
+		want       string
+		wantErr    bool
+	}{
+		"newest stable version": {
+			want: "1.10.1",
+		},
+		"patch releases": {
+			constraint: "~1.2.0",
+			want:       "1.2.0",
+		},
+		"pre-releases when asked for": {
+			constraint: ">=2.0.0-0",
+			want:       "2.0.0-rc.1",
+		},
+		"nothing satisfies": {
+			constraint: ">=3.0.0",
+			wantErr:    true,
+		},
+		"invalid constraint": {
+			constraint: "not a version",
+			wantErr:    true,
+		},
+	}
+
+	for name, tc := range cases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			g := NewWithT(t)
+			version, err := index.Get("exporter", tc.constraint)
+			if tc.wantErr {
+				g.Expect(err).To(HaveOccurred())
+				return
+			}
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(version.Version).To(Equal(tc.want))
+		})
+	}
+
+	g := NewWithT(t)
+	version, err := index.Get("exporter", "1.2.0")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(version.ArchiveName()).To(Equal("exporter-1.2.0.tgz"))
+	g.Expect(version.Verify(archive)).To(Succeed())
+	g.Expect(version.Verify([]byte("tampered"))).NotTo(Succeed())
+}
+
+// umbrellaChart vendors the exporter chart twice, once disabled by a
+// condition.
+var umbrellaChart = map[string]string{
+	"Chart.yaml": `
+apiVersion: v2
+name: umbrella
+version: 0.1.0
+dependencies:
+- name: exporter
+  version: 1.2.0
+- name: sidecar
+  version: 0.1.0
+  condition: sidecar.enabled
+`,
+	"values.yaml": `
+exporter:
+  cluster: umbrella
+  greeting: hello
+  replicas: 2
+sidecar:
+  enabled: false
+`,
+	"charts/exporter/Chart.yaml":                exporterChart["Chart.yaml"],
+	"charts/exporter/values.yaml":               exporterChart["values.yaml"],
+	"charts/exporter/templates/_helpers.tpl":    exporterChart["templates/_helpers.tpl"],
+	"charts/exporter/templates/deployment.yaml": exporterChart["templates/deployment.yaml"],
+	"charts/sidecar/Chart.yaml":                 "apiVersion: v2\nname: sidecar\nversion: 0.1.0\n",
+	"charts/sidecar/templates/configmap.yaml":   "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: sidecar\n",
+}
+
+// fakeAPIServer serves the core API with a single namespace, for lookup.
+func fakeAPIServer(t *testing.T) *rest.Config {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/api/v1":
+			fmt.Fprint(w, `{"kind":"APIResourceList","groupVersion":"v1","resources":[{"name":"namespaces","namespaced":false,"kind":"Namespace","verbs":["get","list"]}]}`)
+		case "/api/v1/namespaces/monitoring":
+			fmt.Fprint(w, `{"apiVersion":"v1","kind":"Namespace","metadata":{"name":"monitoring","labels":{"owner":"platform"}}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"NotFound","code":404}`)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return &rest.Config{Host: server.URL}
+}
+
+func TestRender(t *testing.T) {
+	g := NewWithT(t)
+
+	chart, err := Load(packageChart(t, "exporter", exporterChart))
+	g.Expect(err).NotTo(HaveOccurred())
+
+	release := Release{Name: "metrics", Namespace: "monitoring", Revision: 1, IsInstall: true}
+	capabilities, err := NewCapabilities("v1.18.3", []string{"v1", "monitoring.coreos.com/v1", "monitoring.coreos.com/v1/ServiceMonitor"})
+	g.Expect(err).NotTo(HaveOccurred())
+	config := fakeAPIServer(t)
+
+	values := map[string]interface{}{
+		"cluster":  "turtle-1",
+		"greeting": "hello from {{ .Release.Name }}",
+		"image":    map[string]interface{}{"tag": nil},
+		"config":   true,
+	}
+	manifest, err := Render(chart, release, capabilities, values, config)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	rendered := string(manifest)
+	g.Expect(strings.Index(rendered, "kind: CustomResourceDefinition")).To(BeNumerically("<", strings.Index(rendered, "kind: ConfigMap")), "CRDs come first")
+	g.Expect(strings.Index(rendered, "kind: ConfigMap")).To(BeNumerically("<", strings.Index(rendered, "kind: Deployment")), "objects are in install order")
+	g.Expect(rendered).To(ContainSubstring("name: metrics-exporter"))
+	g.Expect(rendered).To(ContainSubstring("namespace: monitoring"))
+	g.Expect(rendered).To(ContainSubstring("team: platform"))
+	g.Expect(rendered).To(ContainSubstring(`cluster: "turtle-1"`))
+	g.Expect(rendered).To(ContainSubstring(`greeting: "hello from metrics"`))
+	g.Expect(rendered).To(ContainSubstring(`image: "exporter:0.9"`), "null values remove defaults")
+	g.Expect(rendered).To(ContainSubstring(`config.txt: "debug: true"`))
+	g.Expect(rendered).To(ContainSubstring(`monitoring: "true"`))
+	g.Expect(rendered).To(ContainSubstring("owner: platform"))
+	g.Expect(rendered).To(ContainSubstring("name: metrics-migrate"), "install hooks are applied")
+	g.Expect(rendered).NotTo(ContainSubstring("metrics-cleanup"), "delete hooks are left out")
+	g.Expect(rendered).NotTo(ContainSubstring("metrics-test"), "test hooks are left out")
+	g.Expect(rendered).NotTo(ContainSubstring("Thanks"), "notes are left out")
+
+	again, err := Render(chart, release, capabilities, values, config)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(again).To(Equal(manifest), "renders are deterministic")
+
+	withoutLookup, err := Render(chart, release, capabilities, values, nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(string(withoutLookup)).NotTo(ContainSubstring("owner:"))
+
+	delete(values, "cluster")
+	_, err = Render(chart, release, capabilities, values, config)
+	g.Expect(err).To(MatchError(ContainSubstring("cluster is required")))
+
+	old, err := NewCapabilities("1.15.0", nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	values["cluster"] = "turtle-1"
+	_, err = Render(chart, release, old, values, config)
+	g.Expect(err).To(MatchError(ContainSubstring("requires kubernetes")))
+}
+
+func TestRenderDependencies(t *testing.T) {
+	g := NewWithT(t)
+
+	chart, err := Load(packageChart(t, "umbrella", umbrellaChart))
+	g.Expect(err).NotTo(HaveOccurred())
+	release := Release{Name: "metrics", Namespace: "monitoring", Revision: 1, IsInstall: true}
+	capabilities, err := NewCapabilities("v1.18.3", nil)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	manifest, err := Render(chart, release, capabilities, nil, nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(string(manifest)).To(ContainSubstring(`cluster: "umbrella"`), "parent values reach dependencies")
+	g.Expect(string(manifest)).To(ContainSubstring("replicas: 2"))
+	g.Expect(string(manifest)).NotTo(ContainSubstring("name: sidecar"), "conditions disable dependencies")
+
+	enabled := map[string]interface{}{"sidecar": map[string]interface{}{"enabled": true}}
+	manifest, err = Render(chart, release, capabilities, enabled, nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(string(manifest)).To(ContainSubstring("name: sidecar"))
+
+	manifest, err = Render(chart, release, capabilities, nil, nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(string(manifest)).NotTo(ContainSubstring("name: sidecar"), "renders don't share processed dependencies")
+}
+
+func TestParseValues(t *testing.T) {
+	cases := []struct {
+		name      string
+		values    string
+		want      map[string]interface{}
+		expectErr string
+	}{
+		{
+			name:   "templated",
+			values: "cluster: {{ .Name }}\nregion: {{ .Location | upper }}\n",
+			want:   map[string]interface{}{"cluster": "turtle-1", "region": "WESTUS2"},
+		},
+		{
+			name:   "empty",
+			values: "",
+			want:   map[string]interface{}{},
+		},
+		{
+			name:      "missing key",
+			values:    "zone: {{ .Zone }}",
+			expectErr: "failed to render values",
+		},
+		{
+			name:      "no environment",
+			values:    `home: {{ env "HOME" }}`,
+			expectErr: "failed to parse values",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			var got map[string]interface{}
+			values, err := ParseValues(tc.values)
+			if err == nil {
+				got, err = values.Execute(map[string]interface{}{"Name": "turtle-1", "Location": "westus2"})
+			}
+
+			if tc.expectErr != "" {
+				g.Expect(err).To(MatchError(ContainSubstring(tc.expectErr)))
+				return
+			}
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(got).To(Equal(tc.want))
+		})
+	}
+}
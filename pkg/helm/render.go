@@ -0,0 +1,133 @@
+// Copyright 2020 Alexander Eldeib
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS
+// OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+// CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+// TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package helm
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/engine"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/releaseutil"
+	"k8s.io/client-go/rest"
+)
+
+// Release describes the release a chart is rendered for, as .Release.
+type Release = chartutil.ReleaseOptions
+
+// Capabilities describe the cluster a chart is rendered for.
+type Capabilities = chartutil.Capabilities
+
+// NewCapabilities returns the capabilities of a cluster running a version of
+// Kubernetes and serving API versions, like apps/v1, and their kinds, like
+// apps/v1/Deployment.
+func NewCapabilities(kubeVersion string, apiVersions []string) (*Capabilities, error) {
+	version, err := semver.NewVersion(kubeVersion)
+	if err != nil {
+		return nil, fmt.Errorf("invalid kubernetes version %q: %w", kubeVersion, err)
+	}
+
+	return &Capabilities{
+		KubeVersion: chartutil.KubeVersion{
+			Version: "v" + version.String(),
+			Major:   fmt.Sprint(version.Major()),
+			Minor:   fmt.Sprint(version.Minor()),
+		},
+		APIVersions: apiVersions,
+	}, nil
+}
+
+// Render renders a chart for a release with the given values, which
+// override the defaults of the chart and its dependencies. It returns the
+// objects of the release as a multi-document YAML manifest: the
+// CustomResourceDefinitions of the chart first, then its objects in the
+// order Helm installs them, then its install and upgrade hooks. Templates
+// look objects up in the cluster of config; without one, lookup finds
+// nothing.
+func Render(ch *Chart, rel Release, capabilities *Capabilities, values map[string]interface{}, config *rest.Config) ([]byte, error) {
+	if ch.Metadata.KubeVersion != "" && !chartutil.IsCompatibleRange(ch.Metadata.KubeVersion, capabilities.KubeVersion.String()) {
+		return nil, fmt.Errorf("chart %s requires kubernetes %s, the cluster runs %s",
+			ch.Metadata.Name, ch.Metadata.KubeVersion, capabilities.KubeVersion.String())
+	}
+
+	c, err := load(ch.archive)
+	if err != nil {
+		return nil, err
+	}
+	if err := chartutil.ProcessDependencies(c, values); err != nil {
+		return nil, fmt.Errorf("failed to process dependencies of chart %s: %w", c.Metadata.Name, err)
+	}
+	top, err := chartutil.ToRenderValues(c, values, rel, capabilities)
+	if err != nil {
+		return nil, err
+	}
+
+	var files map[string]string
+	if config != nil {
+		files, err = engine.RenderWithClient(c, top, config)
+	} else {
+		files, err = engine.Render(c, top)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for name := range files {
+		if strings.HasSuffix(name, "NOTES.txt") {
+			delete(files, name)
+		}
+	}
+
+	hooks, manifests, err := releaseutil.SortManifests(files, capabilities.APIVersions, releaseutil.InstallOrder)
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	for _, crd := range c.CRDObjects() {
+		fmt.Fprintf(&out, "---\n# Source: %s\n%s\n", crd.Filename, crd.File.Data)
+	}
+	for _, manifest := range manifests {
+		fmt.Fprintf(&out, "---\n# Source: %s\n%s\n", manifest.Name, manifest.Content)
+	}
+	for _, hook := range hooks {
+		if installs(hook) {
+			fmt.Fprintf(&out, "---\n# Source: %s\n%s\n", hook.Path, hook.Manifest)
+		}
+	}
+
+	return out.Bytes(), nil
+}
+
+// installs reports whether a hook runs on install or upgrade, rather than
+// only on deletion, rollback or with helm test.
+func installs(hook *release.Hook) bool {
+	for _, event := range hook.Events {
+		switch event {
+		case release.HookPreInstall, release.HookPostInstall, release.HookPreUpgrade, release.HookPostUpgrade:
+			return true
+		}
+	}
+	return false
+}
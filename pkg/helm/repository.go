@@ -0,0 +1,109 @@
+// Copyright 2020 Alexander Eldeib
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS
+// OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+// CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+// TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package helm
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+
+	"github.com/Masterminds/semver/v3"
+	"sigs.k8s.io/yaml"
+)
+
+// Index is the index.yaml of a chart repository.
+type Index struct {
+	APIVersion string                    `json:"apiVersion"`
+	Entries    map[string][]ChartVersion `json:"entries"`
+}
+
+// ChartVersion is a version of a chart listed in a repository index.
+type ChartVersion struct {
+	Name       string   `json:"name"`
+	Version    string   `json:"version"`
+	AppVersion string   `json:"appVersion,omitempty"`
+	Digest     string   `json:"digest,omitempty"`
+	URLs       []string `json:"urls"`
+}
+
+// LoadIndex parses the index.yaml of a chart repository.
+func LoadIndex(data []byte) (*Index, error) {
+	index := &Index{}
+	if err := yaml.Unmarshal(data, index); err != nil {
+		return nil, fmt.Errorf("failed to parse repository index: %w", err)
+	}
+	return index, nil
+}
+
+// Get returns the newest version of a chart satisfying a semver constraint,
+// like ~1.2.0 or >=2.0.0 <3.0.0, or the newest stable version if the
+// constraint is empty. Pre-releases only satisfy constraints naming one.
+func (i *Index) Get(name, constraint string) (*ChartVersion, error) {
+	if constraint == "" {
+		constraint = "*"
+	}
+	constraints, err := semver.NewConstraint(constraint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid version constraint %q: %w", constraint, err)
+	}
+
+	var newest *ChartVersion
+	var newestVersion *semver.Version
+	for j := range i.Entries[name] {
+		entry := &i.Entries[name][j]
+		version, err := semver.NewVersion(entry.Version)
+		if err != nil || !constraints.Check(version) {
+			continue
+		}
+		if newestVersion == nil || version.GreaterThan(newestVersion) {
+			newest, newestVersion = entry, version
+		}
+	}
+
+	if newest == nil {
+		return nil, fmt.Errorf("no version of chart %s satisfies %q", name, constraint)
+	}
+	return newest, nil
+}
+
+// ArchiveName returns the file name of the packaged chart, the last element
+// of its first URL.
+func (v *ChartVersion) ArchiveName() (string, error) {
+	if len(v.URLs) == 0 {
+		return "", fmt.Errorf("chart %s %s has no urls", v.Name, v.Version)
+	}
+	return path.Base(v.URLs[0]), nil
+}
+
+// Verify checks a packaged chart against the digest in the index, if any.
+func (v *ChartVersion) Verify(archive []byte) error {
+	if v.Digest == "" {
+		return nil
+	}
+
+	sum := sha256.Sum256(archive)
+	if digest := hex.EncodeToString(sum[:]); digest != v.Digest {
+		return fmt.Errorf("digest %s of chart %s %s does not match the index", digest, v.Name, v.Version)
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+// Copyright 2020 Alexander Eldeib
+//
+// Permission is hereby granted, free of charge, to any person obtaining a
+// copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS
+// OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+// CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+// TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package helm
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/Masterminds/sprig/v3"
+	"sigs.k8s.io/yaml"
+)
+
+// ValuesTemplate is a values document which is also a Go template, so
+// values can be computed from the cluster a release is installed to.
+type ValuesTemplate struct {
+	tmpl *template.Template
+}
+
+// ParseValues parses a values template. It has the sprig functions chart
+// templates have, without access to the environment, and the conversions
+// Helm adds. It fails on missing keys.
+func ParseValues(text string) (*ValuesTemplate, error) {
+	tmpl := template.New("values").Option("missingkey=error")
+	tmpl.Funcs(valuesFuncMap(tmpl))
+	if _, err := tmpl.Parse(text); err != nil {
+		return nil, fmt.Errorf("failed to parse values: %w", err)
+	}
+	return &ValuesTemplate{tmpl: tmpl}, nil
+}
+
+// Execute evaluates the template against data and parses the resulting
+// values.
+func (v *ValuesTemplate) Execute(data interface{}) (map[string]interface{}, error) {
+	var out bytes.Buffer
+	if err := v.tmpl.Execute(&out, data); err != nil {
+		return nil, fmt.Errorf("failed to render values: %w", err)
+	}
+
+	values := map[string]interface{}{}
+	if err := yaml.Unmarshal(out.Bytes(), &values); err != nil {
+		return nil, fmt.Errorf("failed to parse values: %w", err)
+	}
+	return values, nil
+}
+
+// valuesFuncMap returns the functions available to values templates.
+func valuesFuncMap(tmpl *template.Template) template.FuncMap {
+	funcs := sprig.TxtFuncMap()
+	delete(funcs, "env")
+	delete(funcs, "expandenv")
+
+	extra := template.FuncMap{
+		"toYaml":   toYAML,
+		"fromYaml": fromYAML,
+		"toJson":   toJSON,
+		"fromJson": fromJSON,
+		"required": required,
+		"include": func(name string, data interface{}) (string, error) {
+			var out bytes.Buffer
+			if err := tmpl.ExecuteTemplate(&out, name, data); err != nil {
+				return "", err
+			}
+			return out.String(), nil
+		},
+	}
+	for name, fn := range extra {
+		funcs[name] = fn
+	}
+
+	return funcs
+}
+
+// toYAML returns the YAML of a value, or an empty string if it has none.
+func toYAML(v interface{}) string {
+	data, err := yaml.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSuffix(string(data), "\n")
+}
+
+// fromYAML parses a YAML map. Errors are returned under the Error key.
+func fromYAML(text string) map[string]interface{} {
+	m := map[string]interface{}{}
+	if err := yaml.Unmarshal([]byte(text), &m); err != nil {
+		m["Error"] = err.Error()
+	}
+	return m
+}
+
+// toJSON returns the JSON of a value, or an empty string if it has none.
+func toJSON(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// fromJSON parses a JSON map. Errors are returned under the Error key.
+func fromJSON(text string) map[string]interface{} {
+	m := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(text), &m); err != nil {
+		m["Error"] = err.Error()
+	}
+	return m
+}
+
+// required fails rendering with a message if a value is missing.
+func required(message string, v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, errors.New(message)
+	}
+	if s, ok := v.(string); ok && s == "" {
+		return nil, errors.New(message)
+	}
+	return v, nil
+}
@@ -0,0 +1,191 @@
+package remote
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// FieldManager owns the fields Bale sets through server-side apply.
+const FieldManager = "bale"
+
+// ApplyResult is the outcome of applying a single object.
+type ApplyResult struct {
+	// GroupVersionKind, Namespace and Name identify the object.
+	GroupVersionKind schema.GroupVersionKind
+	Namespace        string
+	Name             string
+	// Object is the object as returned by the server, if applied.
+	Object *unstructured.Unstructured
+	// Err is why the object couldn't be applied.
+	Err error
+}
+
+// String returns the kind and namespaced name of the object.
+func (r ApplyResult) String() string {
+	if r.Namespace == "" {
+		return fmt.Sprintf("%s %s", r.GroupVersionKind.Kind, r.Name)
+	}
+	return fmt.Sprintf("%s %s/%s", r.GroupVersionKind.Kind, r.Namespace, r.Name)
+}
+
+// ApplyResults are the outcomes of applying every object of a manifest,
+// in the order they were applied.
+type ApplyResults []ApplyResult
+
+// Err aggregates the errors of objects which couldn't be applied, or
+// returns nil if all of them were.
+func (r ApplyResults) Err() error {
+	var errs []error
+	for _, result := range r {
+		if result.Err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", result, result.Err))
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// ApplyOption configures Apply.
+type ApplyOption func(*applyOptions)
+
+type applyOptions struct {
+	namespace string
+}
+
+// WithNamespace sets the namespace of namespaced objects which don't set
+// one, instead of the default namespace.
+func WithNamespace(namespace string) ApplyOption {
+	return func(o *applyOptions) {
+		o.namespace = namespace
+	}
+}
+
+// Apply applies the objects of a multi-document YAML or JSON manifest with
+// server-side apply, taking ownership of conflicting fields. Namespaced
+// objects without a namespace go to the default namespace, unless set
+// otherwise with WithNamespace. Namespaces and
+// CustomResourceDefinitions are applied before the objects which may
+// depend on them.
+//
+// An error is returned if the manifest can't be decoded. Objects which fail
+// to apply don't stop the others, their errors are in the results.
+func (c *Client) Apply(ctx context.Context, manifest []byte, opts ...ApplyOption) (ApplyResults, error) {
+	options := applyOptions{namespace: metav1.NamespaceDefault}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	objects, err := Decode(manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make(ApplyResults, 0, len(objects))
+	for _, obj := range objects {
+		results = append(results, c.applyObject(ctx, obj, options))
+	}
+
+	// The objects may have added API versions, e.g. with
+	// CustomResourceDefinitions.
+	c.discovery.Invalidate()
+
+	return results, nil
+}
+
+func (c *Client) applyObject(ctx context.Context, obj *unstructured.Unstructured, options applyOptions) ApplyResult {
+	gvk := obj.GroupVersionKind()
+	result := ApplyResult{GroupVersionKind: gvk, Namespace: obj.GetNamespace(), Name: obj.GetName()}
+
+	mapping, err := c.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		result.Err = fmt.Errorf("failed to map resource: %w", err)
+		return result
+	}
+
+	if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+		if obj.GetNamespace() == "" {
+			obj.SetNamespace(options.namespace)
+		}
+	} else {
+		obj.SetNamespace("")
+	}
+	result.Namespace = obj.GetNamespace()
+
+	if err := c.Patch(ctx, obj, client.Apply, client.FieldOwner(FieldManager), client.ForceOwnership); err != nil {
+		result.Err = err
+		return result
+	}
+
+	result.Object = obj
+	return result
+}
+
+// Decode returns the objects of a multi-document YAML or JSON manifest.
+// Lists are flattened into their items and empty documents are skipped.
+// Namespaces and CustomResourceDefinitions come first, otherwise the order
+// of the manifest is kept.
+func Decode(manifest []byte) ([]*unstructured.Unstructured, error) {
+	decoder := yaml.NewYAMLOrJSONDecoder(bytes.NewReader(manifest), 4096)
+
+	var objects []*unstructured.Unstructured
+	for {
+		var content map[string]interface{}
+		if err := decoder.Decode(&content); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, fmt.Errorf("failed to decode manifest: %w", err)
+		}
+		if len(content) == 0 {
+			continue
+		}
+
+		obj := &unstructured.Unstructured{Object: content}
+		if obj.GetKind() == "" || obj.GetAPIVersion() == "" {
+			return nil, fmt.Errorf("object %q is missing apiVersion or kind", obj.GetName())
+		}
+
+		if !obj.IsList() {
+			objects = append(objects, obj)
+			continue
+		}
+
+		err := obj.EachListItem(func(item runtime.Object) error {
+			objects = append(objects, item.(*unstructured.Unstructured))
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode %s: %w", obj.GetKind(), err)
+		}
+	}
+
+	sort.SliceStable(objects, func(i, j int) bool {
+		return applyPriority(objects[i]) < applyPriority(objects[j])
+	})
+
+	return objects, nil
+}
+
+// applyPriority orders objects which others may depend on first.
+func applyPriority(obj *unstructured.Unstructured) int {
+	switch obj.GroupVersionKind().GroupKind() {
+	case schema.GroupKind{Kind: "Namespace"}:
+		return 0
+	case schema.GroupKind{Group: "apiextensions.k8s.io", Kind: "CustomResourceDefinition"}:
+		return 1
+	default:
+		return 2
+	}
+}
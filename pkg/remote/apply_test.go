@@ -0,0 +1,101 @@
+package remote
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestDecode(t *testing.T) {
+	cases := map[string]struct {
+		manifest string
+		want     []string
+		wantErr  bool
+	}{
+		"multiple documents": {
+			manifest: `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: a
+---
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: b
+`,
+			want: []string{"ConfigMap/a", "Secret/b"},
+		},
+		"namespaces and crds first": {
+			manifest: `
+apiVersion: example.com/v1
+kind: Widget
+metadata:
+  name: w
+---
+apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: widgets.example.com
+---
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: ns
+`,
+			want: []string{"Namespace/ns", "CustomResourceDefinition/widgets.example.com", "Widget/w"},
+		},
+		"lists are flattened": {
+			manifest: `
+apiVersion: v1
+kind: List
+items:
+- apiVersion: v1
+  kind: ConfigMap
+  metadata:
+    name: a
+- apiVersion: v1
+  kind: ConfigMap
+  metadata:
+    name: b
+`,
+			want: []string{"ConfigMap/a", "ConfigMap/b"},
+		},
+		"json": {
+			manifest: `{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "a"}}`,
+			want:     []string{"ConfigMap/a"},
+		},
+		"missing kind": {
+			manifest: `
+apiVersion: v1
+metadata:
+  name: a
+`,
+			wantErr: true,
+		},
+		"invalid yaml": {
+			manifest: "kind: [",
+			wantErr:  true,
+		},
+	}
+
+	for name, tc := range cases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			g := NewWithT(t)
+			objects, err := Decode([]byte(tc.manifest))
+			if tc.wantErr {
+				g.Expect(err).To(HaveOccurred())
+				return
+			}
+			g.Expect(err).NotTo(HaveOccurred())
+
+			var got []string
+			for _, obj := range objects {
+				got = append(got, obj.GetKind()+"/"+obj.GetName())
+			}
+			g.Expect(got).To(Equal(tc.want))
+		})
+	}
+}
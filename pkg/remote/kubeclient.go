@@ -1,19 +1,24 @@
 package remote
 
 import (
-	"bytes"
 	"fmt"
+	"path"
+	"strings"
 
-	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
-	"k8s.io/kubectl/pkg/cmd/apply"
-	cmdutil "k8s.io/kubectl/pkg/cmd/util"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
 )
 
 type Client struct {
 	client.Client
-	factory cmdutil.Factory
+	config    *rest.Config
+	mapper    meta.RESTMapper
+	discovery discovery.CachedDiscoveryInterface
 }
 
 func NewClient(kubeconfigBytes []byte) (*Client, error) {
@@ -22,59 +27,59 @@ func NewClient(kubeconfigBytes []byte) (*Client, error) {
 		return nil, fmt.Errorf("failed to create remote restclient: %w", err)
 	}
 
-	kubeclient, err := client.New(restConfig, client.Options{})
+	// The mapper rediscovers resources it doesn't know, so objects of
+	// CustomResourceDefinitions applied moments ago can be mapped.
+	mapper, err := apiutil.NewDynamicRESTMapper(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create remote rest mapper: %w", err)
+	}
+
+	return newClient(restConfig, mapper)
+}
+
+func newClient(restConfig *rest.Config, mapper meta.RESTMapper) (*Client, error) {
+	kubeclient, err := client.New(restConfig, client.Options{Mapper: mapper})
 	if err != nil {
 		return nil, fmt.Errorf("failed to create remote kubeclient: %w", err)
 	}
 
-	getter, err := NewRESTClientGetter(kubeconfigBytes)
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(restConfig)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create remote restclient getter: %w", err)
+		return nil, fmt.Errorf("failed to create remote discovery client: %w", err)
 	}
-	factory := cmdutil.NewFactory(getter)
 
 	return &Client{
 		kubeclient,
-		factory,
+		restConfig,
+		mapper,
+		memory.NewMemCacheClient(discoveryClient),
 	}, nil
 }
 
-/// TODO(ace): figure out how to get apply logic without the factory/cli tools.
-// 3-way merge and apply semantics are hard. Server-side apply is harder.
-func (c *Client) Apply(url string) (stdout *bytes.Buffer, stderr *bytes.Buffer, err error) {
-	return c.do(url, rawFn)
-
-}
-
-// TODO(ace): switch to krusty or shell to kustomize binary. This is reliable
-// but lacks newer kustomize features
-func (c *Client) Kustomize(url string, mutator ApplyOptionsMutateFn) (stdout *bytes.Buffer, stderr *bytes.Buffer, err error) {
-	return c.do(url, kustomizeFn)
+// RESTConfig returns the configuration the client connects to the cluster
+// with.
+func (c *Client) RESTConfig() *rest.Config {
+	return c.config
 }
 
-func (c *Client) do(url string, mutateFn ApplyOptionsMutateFn) (stdout *bytes.Buffer, stderr *bytes.Buffer, err error) {
-	stdio := bytes.NewBuffer(nil)
-	errio := bytes.NewBuffer(nil)
-	streams := genericclioptions.IOStreams{In: stdio, Out: stdio, ErrOut: errio}
-	cmd := apply.NewCmdApply("kubectl", c.factory, streams)
-	opts := apply.NewApplyOptions(streams)
-
-	// Apply file configuration, either raw or kustomize
-	mutateFn(opts, url)
-
-	if err := opts.Complete(c.factory, cmd); err != nil {
-		return nil, nil, fmt.Errorf("failed to complete apply options: %w", err)
+// APIVersions returns the API versions the cluster serves, like apps/v1, and
+// their kinds, like apps/v1/Deployment. Groups which fail discovery are left
+// out. Discovery is cached until the next Apply.
+func (c *Client) APIVersions() ([]string, error) {
+	_, lists, err := discovery.ServerGroupsAndResources(c.discovery)
+	if err != nil && !discovery.IsGroupDiscoveryFailedError(err) {
+		return nil, fmt.Errorf("failed to discover api versions: %w", err)
 	}
 
-	return stdio, errio, opts.Run()
-}
-
-type ApplyOptionsMutateFn func(opts *apply.ApplyOptions, url string)
-
-var kustomizeFn ApplyOptionsMutateFn = func(opts *apply.ApplyOptions, url string) {
-	opts.DeleteFlags.FileNameFlags.Kustomize = &url
-}
-
-var rawFn ApplyOptionsMutateFn = func(opts *apply.ApplyOptions, url string) {
-	opts.DeleteFlags.FileNameFlags.Filenames = &[]string{url}
+	var versions []string
+	for _, list := range lists {
+		versions = append(versions, list.GroupVersion)
+		for _, resource := range list.APIResources {
+			if strings.Contains(resource.Name, "/") {
+				continue
+			}
+			versions = append(versions, path.Join(list.GroupVersion, resource.Kind))
+		}
+	}
+	return versions, nil
 }
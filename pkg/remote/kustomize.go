@@ -0,0 +1,49 @@
+package remote
+
+import (
+	"context"
+	"fmt"
+	"path"
+
+	"sigs.k8s.io/kustomize/api/filesys"
+	"sigs.k8s.io/kustomize/api/krusty"
+)
+
+// kustomizeRoot is where the files of a build are placed in memory.
+const kustomizeRoot = "/kustomize"
+
+// Build renders the kustomization in the directory dir of an in-memory tree
+// of files, keyed by slash separated paths relative to the root of the tree.
+// Bases, patches, generators and components may be anywhere in the tree,
+// but not outside of it.
+func Build(files map[string][]byte, dir string) ([]byte, error) {
+	fSys := filesys.MakeFsInMemory()
+	for name, content := range files {
+		if err := fSys.WriteFile(inTree(name), content); err != nil {
+			return nil, fmt.Errorf("failed to write %s: %w", name, err)
+		}
+	}
+
+	resources, err := krusty.MakeKustomizer(fSys, krusty.MakeDefaultOptions()).Run(inTree(dir))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build kustomization %s: %w", dir, err)
+	}
+
+	return resources.AsYaml()
+}
+
+// Kustomize builds the kustomization in the directory dir of an in-memory
+// tree of files and applies the result. See Build and Apply.
+func (c *Client) Kustomize(ctx context.Context, files map[string][]byte, dir string) (ApplyResults, error) {
+	manifest, err := Build(files, dir)
+	if err != nil {
+		return nil, err
+	}
+	return c.Apply(ctx, manifest)
+}
+
+// inTree returns the in-memory path of a path relative to the root of a
+// build, which can't escape the root.
+func inTree(name string) string {
+	return path.Join(kustomizeRoot, path.Clean("/"+name))
+}
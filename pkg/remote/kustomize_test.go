@@ -0,0 +1,116 @@
+package remote
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestBuild(t *testing.T) {
+	files := map[string][]byte{
+		"base/kustomization.yaml": []byte(`
+resources:
+- configmap.yaml
+`),
+		"base/configmap.yaml": []byte(`
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: settings
+data:
+  mode: default
+`),
+		"overlays/gpu/kustomization.yaml": []byte(`
+bases:
+- ../../base
+namePrefix: gpu-
+patchesStrategicMerge:
+- patch.yaml
+configMapGenerator:
+- name: generated
+  literals:
+  - class=gpu
+`),
+		"overlays/gpu/patch.yaml": []byte(`
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: settings
+data:
+  mode: gpu
+`),
+		"components/spot/kustomization.yaml": []byte(`
+apiVersion: kustomize.config.k8s.io/v1alpha1
+kind: Component
+patchesStrategicMerge:
+- patch.yaml
+`),
+		"components/spot/patch.yaml": []byte(`
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: settings
+data:
+  mode: spot
+`),
+		"overlays/spot/kustomization.yaml": []byte(`
+resources:
+- ../../base
+components:
+- ../../components/spot
+`),
+	}
+
+	cases := map[string]struct {
+		dir     string
+		want    map[string]string
+		wantErr bool
+	}{
+		"base": {
+			dir:  "base",
+			want: map[string]string{"settings": "default"},
+		},
+		"overlay": {
+			dir:  "overlays/gpu",
+			want: map[string]string{"gpu-settings": "gpu"},
+		},
+		"component": {
+			dir:  "overlays/spot",
+			want: map[string]string{"settings": "spot"},
+		},
+		"paths stay within the tree": {
+			dir:  "../../base",
+			want: map[string]string{"settings": "default"},
+		},
+		"missing kustomization": {
+			dir:     "overlays",
+			wantErr: true,
+		},
+	}
+
+	for name, tc := range cases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			g := NewWithT(t)
+			manifest, err := Build(files, tc.dir)
+			if tc.wantErr {
+				g.Expect(err).To(HaveOccurred())
+				return
+			}
+			g.Expect(err).NotTo(HaveOccurred())
+
+			objects, err := Decode(manifest)
+			g.Expect(err).NotTo(HaveOccurred())
+			for name, mode := range tc.want {
+				var found bool
+				for _, obj := range objects {
+					if obj.GetName() == name {
+						found = true
+						g.Expect(obj.Object["data"]).To(HaveKeyWithValue("mode", mode))
+					}
+				}
+				g.Expect(found).To(BeTrue(), "object %s not found in\n%s", name, manifest)
+			}
+		})
+	}
+}
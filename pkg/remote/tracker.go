@@ -0,0 +1,315 @@
+package remote
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// DefaultSyncTimeout bounds how long starting a watch waits for the cache
+// of a remote cluster to sync, so an unreachable cluster doesn't block the
+// caller.
+const DefaultSyncTimeout = 30 * time.Second
+
+// Watcher starts watches, and is implemented by controller.Controller.
+type Watcher interface {
+	Watch(src source.Source, eventhandler handler.EventHandler, predicates ...predicate.Predicate) error
+}
+
+// ClusterCacheTracker keeps caches of each remote cluster, keyed by the
+// object owning the cluster, along with the watches started on them. A
+// cluster has a cache for each namespace watched, so objects in other
+// namespaces are never held in memory.
+type ClusterCacheTracker struct {
+	log         logr.Logger
+	scheme      *runtime.Scheme
+	syncTimeout time.Duration
+
+	// lock guards clusters only. Each cluster has its own lock, held while
+	// its caches sync, so an unreachable cluster doesn't block the others.
+	lock     sync.Mutex
+	clusters map[types.NamespacedName]*clusterCache
+}
+
+// clusterCache holds the caches of one remote cluster, and a client of it
+// sharing their REST mapper. Closing stop stops the caches and the watches
+// on them.
+type clusterCache struct {
+	// lock guards client, caches and watches.
+	lock sync.Mutex
+
+	config     *rest.Config
+	mapper     meta.RESTMapper
+	client     *Client
+	caches     map[string]cache.Cache
+	kubeconfig []byte
+	stop       chan struct{}
+	watches    sets.String
+}
+
+// NewClusterCacheTracker returns a tracker whose caches decode remote
+// objects with the given scheme.
+func NewClusterCacheTracker(log logr.Logger, scheme *runtime.Scheme) *ClusterCacheTracker {
+	return &ClusterCacheTracker{
+		log:         log,
+		scheme:      scheme,
+		syncTimeout: DefaultSyncTimeout,
+		clusters:    map[types.NamespacedName]*clusterCache{},
+	}
+}
+
+// WatchInput describes a watch on a remote cluster.
+type WatchInput struct {
+	// Name identifies the watch within the cluster. Watching again under
+	// the same name is a no-op.
+	Name string
+	// Cluster is the key of the object owning the remote cluster.
+	Cluster types.NamespacedName
+	// Kubeconfig of the remote cluster. The cache and its watches are
+	// recreated when it changes.
+	Kubeconfig []byte
+	// Watcher receives the events, usually the controller of the owner.
+	Watcher Watcher
+	// Kind is the type of remote objects to watch.
+	Kind runtime.Object
+	// Namespace the objects are watched in. Leave it empty only for
+	// cluster-scoped kinds, or every object of the kind in the cluster is
+	// cached.
+	Namespace string
+	// EventHandler maps remote events to requests for the watcher.
+	EventHandler handler.EventHandler
+	// Predicates filter the remote events.
+	Predicates []predicate.Predicate
+}
+
+// Watch starts watching a kind of object in a remote cluster, starting a
+// cache for the cluster first if needed.
+func (t *ClusterCacheTracker) Watch(ctx context.Context, input WatchInput) error {
+	cc, err := t.getClusterCache(input.Cluster, input.Kubeconfig)
+	if err != nil {
+		return err
+	}
+
+	cc.lock.Lock()
+	defer cc.lock.Unlock()
+
+	// The cluster may have been stopped while waiting for its lock, e.g.
+	// because its kubeconfig changed.
+	if cc.stopped() {
+		return fmt.Errorf("cache of %s stopped before watch %s started", input.Cluster, input.Name)
+	}
+
+	if cc.watches.Has(input.Name) {
+		return nil
+	}
+
+	syncCtx, cancel := context.WithTimeout(ctx, t.syncTimeout)
+	defer cancel()
+
+	remoteCache, err := t.getCache(input.Cluster, cc, input.Namespace)
+	if err != nil {
+		return err
+	}
+
+	informer, err := remoteCache.GetInformer(syncCtx, input.Kind)
+	if err != nil {
+		// Start over on the next attempt rather than reuse a cache which
+		// may never sync.
+		t.stopCache(input.Cluster, cc)
+		return fmt.Errorf("failed to get remote informer for watch %s of %s: %w", input.Name, input.Cluster, err)
+	}
+
+	// Handlers can't be removed from informers, so events are dropped once
+	// the cluster is stopped rather than delivered to the watcher.
+	eventHandler := &stoppableHandler{EventHandler: input.EventHandler, stop: cc.stop}
+	if err := input.Watcher.Watch(&source.Informer{Informer: informer}, eventHandler, input.Predicates...); err != nil {
+		return fmt.Errorf("failed to start watch %s of %s: %w", input.Name, input.Cluster, err)
+	}
+
+	cc.watches.Insert(input.Name)
+	t.log.Info("started remote watch", "cluster", input.Cluster, "watch", input.Name)
+	return nil
+}
+
+// Client returns a client of a remote cluster. It is created once per
+// kubeconfig of the cluster, so discovery doesn't run on every call.
+func (t *ClusterCacheTracker) Client(cluster types.NamespacedName, kubeconfig []byte) (*Client, error) {
+	cc, err := t.getClusterCache(cluster, kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+
+	cc.lock.Lock()
+	defer cc.lock.Unlock()
+
+	if cc.client == nil {
+		remoteClient, err := newClient(cc.config, cc.mapper)
+		if err != nil {
+			return nil, err
+		}
+		cc.client = remoteClient
+	}
+
+	return cc.client, nil
+}
+
+// Stop stops the caches of a remote cluster and all its watches, e.g. once
+// the cluster is deleted.
+func (t *ClusterCacheTracker) Stop(cluster types.NamespacedName) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.stopLocked(cluster)
+}
+
+// stopCache stops the caches of a cluster unless they were already
+// replaced by newer ones.
+func (t *ClusterCacheTracker) stopCache(cluster types.NamespacedName, cc *clusterCache) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.clusters[cluster] == cc {
+		t.stopLocked(cluster)
+	}
+}
+
+func (t *ClusterCacheTracker) stopLocked(cluster types.NamespacedName) {
+	cc, ok := t.clusters[cluster]
+	if !ok {
+		return
+	}
+
+	close(cc.stop)
+	delete(t.clusters, cluster)
+	t.log.Info("stopped remote cluster cache", "cluster", cluster)
+}
+
+// getClusterCache returns the caches of a cluster, or creates them.
+// Watches of the previous caches stop with them when the kubeconfig
+// changed, and are started again by the next call to Watch.
+func (t *ClusterCacheTracker) getClusterCache(cluster types.NamespacedName, kubeconfig []byte) (*clusterCache, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if cc, ok := t.clusters[cluster]; ok {
+		if bytes.Equal(cc.kubeconfig, kubeconfig) {
+			return cc, nil
+		}
+		t.stopLocked(cluster)
+	}
+
+	restConfig, err := clientcmd.RESTConfigFromKubeConfig(kubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create remote restclient: %w", err)
+	}
+
+	// Discovery waits for the first lookup, so it runs under the lock of
+	// the cluster rather than the lock of the tracker.
+	mapper, err := apiutil.NewDynamicRESTMapper(restConfig, apiutil.WithLazyDiscovery)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create remote rest mapper: %w", err)
+	}
+
+	cc := &clusterCache{
+		config:     restConfig,
+		mapper:     mapper,
+		caches:     map[string]cache.Cache{},
+		kubeconfig: kubeconfig,
+		stop:       make(chan struct{}),
+		watches:    sets.NewString(),
+	}
+
+	t.clusters[cluster] = cc
+	return cc, nil
+}
+
+// stopped reports whether the caches of the cluster were stopped.
+func (cc *clusterCache) stopped() bool {
+	select {
+	case <-cc.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// getCache returns the running cache of a namespace of a cluster, or starts
+// one. The caller holds the lock of the cluster.
+func (t *ClusterCacheTracker) getCache(cluster types.NamespacedName, cc *clusterCache, namespace string) (cache.Cache, error) {
+	if c, ok := cc.caches[namespace]; ok {
+		return c, nil
+	}
+
+	remoteCache, err := cache.New(cc.config, cache.Options{Scheme: t.scheme, Mapper: cc.mapper, Namespace: namespace})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create remote cache: %w", err)
+	}
+
+	go func() {
+		if err := remoteCache.Start(cc.stop); err != nil {
+			t.log.Error(err, "remote cluster cache stopped", "cluster", cluster, "namespace", namespace)
+		}
+	}()
+
+	cc.caches[namespace] = remoteCache
+	t.log.Info("started remote cluster cache", "cluster", cluster, "namespace", namespace)
+	return remoteCache, nil
+}
+
+// stoppableHandler passes events on until stop is closed.
+type stoppableHandler struct {
+	handler.EventHandler
+	stop <-chan struct{}
+}
+
+func (h *stoppableHandler) stopped() bool {
+	select {
+	case <-h.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// Create implements handler.EventHandler.
+func (h *stoppableHandler) Create(e event.CreateEvent, q workqueue.RateLimitingInterface) {
+	if !h.stopped() {
+		h.EventHandler.Create(e, q)
+	}
+}
+
+// Update implements handler.EventHandler.
+func (h *stoppableHandler) Update(e event.UpdateEvent, q workqueue.RateLimitingInterface) {
+	if !h.stopped() {
+		h.EventHandler.Update(e, q)
+	}
+}
+
+// Delete implements handler.EventHandler.
+func (h *stoppableHandler) Delete(e event.DeleteEvent, q workqueue.RateLimitingInterface) {
+	if !h.stopped() {
+		h.EventHandler.Delete(e, q)
+	}
+}
+
+// Generic implements handler.EventHandler.
+func (h *stoppableHandler) Generic(e event.GenericEvent, q workqueue.RateLimitingInterface) {
+	if !h.stopped() {
+		h.EventHandler.Generic(e, q)
+	}
+}
@@ -0,0 +1,79 @@
+package remote
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+func TestStoppableHandler(t *testing.T) {
+	g := NewWithT(t)
+
+	queue := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+	defer queue.ShutDown()
+
+	stop := make(chan struct{})
+	h := &stoppableHandler{EventHandler: &handler.EnqueueRequestForObject{}, stop: stop}
+
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}
+	h.Create(event.CreateEvent{Meta: node, Object: node}, queue)
+	g.Expect(queue.Len()).To(Equal(1))
+
+	close(stop)
+	node = &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-2"}}
+	h.Create(event.CreateEvent{Meta: node, Object: node}, queue)
+	h.Delete(event.DeleteEvent{Meta: node, Object: node}, queue)
+	g.Expect(queue.Len()).To(Equal(1), "events are dropped once the cluster is stopped")
+}
+
+func TestClusterLocksAreIndependent(t *testing.T) {
+	g := NewWithT(t)
+
+	tracker := NewClusterCacheTracker(log.NullLogger{}, runtime.NewScheme())
+	kubeconfig := func(server string) []byte {
+		return []byte(fmt.Sprintf(`apiVersion: v1
+kind: Config
+clusters:
+- name: remote
+  cluster:
+    server: %s
+contexts:
+- name: remote
+  context:
+    cluster: remote
+current-context: remote
+`, server))
+	}
+
+	slow := types.NamespacedName{Namespace: "default", Name: "slow"}
+	cc, err := tracker.getClusterCache(slow, kubeconfig("https://127.0.0.1:1"))
+	g.Expect(err).NotTo(HaveOccurred())
+
+	// Hold the lock of one cluster, as a watch waiting for its cache to
+	// sync does, and get a client of another.
+	cc.lock.Lock()
+	defer cc.lock.Unlock()
+
+	done := make(chan error)
+	go func() {
+		_, err := tracker.Client(types.NamespacedName{Namespace: "default", Name: "fast"}, kubeconfig("https://127.0.0.1:2"))
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		g.Expect(err).NotTo(HaveOccurred())
+	case <-time.After(5 * time.Second):
+		t.Fatal("client of one cluster waited for the lock of another")
+	}
+}